@@ -10,11 +10,15 @@ import (
 // new process group created for cmd. The returned function `bg` switches back
 // to the previous process group.
 //
-// The command's environment has all RESTIC_* variables removed.
+// The command's environment has all RESTIC_* variables removed. If cmd.Env is
+// set, it is used instead of the environment of the current process.
 func StartForeground(cmd *exec.Cmd) (bg func() error, err error) {
-	env := os.Environ() // Returns a copy that we can modify.
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
 
-	cmd.Env = env[:0]
+	cmd.Env = make([]string, 0, len(env))
 	for _, kv := range env {
 		if strings.HasPrefix(kv, "RESTIC_") {
 			continue
@@ -39,3 +39,27 @@ func TestForeground(t *testing.T) {
 	}
 	rtest.OK(t, err)
 }
+
+func TestForegroundEnv(t *testing.T) {
+	cmd := exec.Command("/usr/bin/env")
+	cmd.Env = []string{"FOO=bar", "RESTIC_PASSWORD=supersecret"}
+	stdout, err := cmd.StdoutPipe()
+	rtest.OK(t, err)
+
+	bg, err := backend.StartForeground(cmd)
+	rtest.OK(t, err)
+	defer func() {
+		rtest.OK(t, cmd.Wait())
+	}()
+
+	err = bg()
+	rtest.OK(t, err)
+
+	var env []string
+	sc := bufio.NewScanner(stdout)
+	for sc.Scan() {
+		env = append(env, sc.Text())
+	}
+	rtest.OK(t, sc.Err())
+	rtest.Equals(t, []string{"FOO=bar"}, env)
+}
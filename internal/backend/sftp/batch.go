@@ -0,0 +1,115 @@
+package sftp
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// SaveItem describes a single file saved by SaveMany.
+type SaveItem struct {
+	Handle restic.Handle
+	Reader restic.RewindReader
+}
+
+// SaveMany stores all items in the backend. Each item is saved by Save, which
+// waits for each of its requests in turn, so the requests for a single file
+// are not pipelined. Instead, up to Connections items are saved at the same
+// time, so that the round trips for opening, writing and renaming one file
+// overlap with those of the others. In contrast to Save, an error does not
+// abort the other uploads: all items are processed and the errors for all
+// failed items are returned combined.
+func (r *SFTP) SaveMany(ctx context.Context, items []SaveItem) error {
+	debug.Log("SaveMany %d items%v", len(items), r.tagInfo())
+	if err := r.checkReadOnly("save"); err != nil {
+		return err
+	}
+
+	var (
+		m      sync.Mutex
+		failed []string
+	)
+
+	var wg errgroup.Group
+	wg.SetLimit(int(r.Connections()))
+
+	for _, item := range items {
+		if ctx.Err() != nil {
+			break
+		}
+
+		item := item
+		wg.Go(func() error {
+			err := r.Save(ctx, item.Handle, item.Reader)
+			r.reportResult("save", objectName(item.Handle), item.Reader.Length(), err)
+			if err != nil {
+				m.Lock()
+				failed = append(failed, fmt.Sprintf("%v: %v", item.Handle, err))
+				m.Unlock()
+			}
+			return nil
+		})
+	}
+
+	_ = wg.Wait()
+
+	if len(failed) > 0 {
+		return errors.Errorf("unable to save %d of %d files:\n  %v", len(failed), len(items), strings.Join(failed, "\n  "))
+	}
+
+	return ctx.Err()
+}
+
+// RemoveMany removes the files for all handles. Like SaveMany, the removals
+// run concurrently using up to the configured number of connections, an error
+// does not abort the other removals and the errors for all failed handles are
+// returned combined.
+func (r *SFTP) RemoveMany(ctx context.Context, handles []restic.Handle) error {
+	debug.Log("RemoveMany %d handles%v", len(handles), r.tagInfo())
+	if err := r.checkReadOnly("remove"); err != nil {
+		return err
+	}
+
+	var (
+		m      sync.Mutex
+		failed []string
+	)
+
+	var wg errgroup.Group
+	wg.SetLimit(int(r.Connections()))
+
+	for _, h := range handles {
+		if ctx.Err() != nil {
+			break
+		}
+
+		h := h
+		wg.Go(func() error {
+			err := r.Remove(ctx, h)
+			r.reportResult("remove", objectName(h), 0, err)
+			if err != nil {
+				m.Lock()
+				failed = append(failed, fmt.Sprintf("%v: %v", h, err))
+				m.Unlock()
+			}
+			return nil
+		})
+	}
+
+	_ = wg.Wait()
+
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return errors.Errorf("unable to remove %d of %d files:\n  %v", len(failed), len(handles), strings.Join(failed, "\n  "))
+	}
+
+	return ctx.Err()
+}
@@ -0,0 +1,139 @@
+package sftp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestSaveMany(t *testing.T) {
+	be := newTestBackend(t, newTestConfig(t))
+
+	var items []SaveItem
+	files := make(map[restic.Handle][]byte)
+	for i := 0; i < 20; i++ {
+		data := []byte(fmt.Sprintf("index file %d", i))
+		h := restic.Handle{Type: restic.IndexFile, Name: restic.Hash(data).String()}
+		files[h] = data
+		items = append(items, SaveItem{Handle: h, Reader: restic.NewByteReader(data, nil)})
+	}
+
+	rtest.OK(t, be.SaveMany(context.TODO(), items))
+
+	for h, data := range files {
+		buf, err := backend.LoadAll(context.TODO(), nil, be, h)
+		rtest.OK(t, err)
+		rtest.Equals(t, data, buf)
+	}
+
+	// invalid handles fail, the other files are still saved
+	data := []byte("new index file")
+	h := restic.Handle{Type: restic.IndexFile, Name: restic.Hash(data).String()}
+	items = []SaveItem{
+		{Handle: restic.Handle{Type: restic.IndexFile}, Reader: restic.NewByteReader(data, nil)},
+		{Handle: h, Reader: restic.NewByteReader(data, nil)},
+		{Handle: restic.Handle{Type: restic.SnapshotFile}, Reader: restic.NewByteReader(data, nil)},
+	}
+
+	err := be.SaveMany(context.TODO(), items)
+	rtest.Assert(t, err != nil, "expected an error for invalid handles")
+	rtest.Assert(t, strings.Contains(err.Error(), "unable to save 2 of 3 files"), "unexpected error %v", err)
+
+	_, err = be.Stat(context.TODO(), h)
+	rtest.OK(t, err)
+}
+
+func TestSaveManyResults(t *testing.T) {
+	var buf bytes.Buffer
+	cfg := newTestConfig(t)
+	cfg.ResultStream = &buf
+	be := newTestBackend(t, cfg)
+
+	data := []byte("index file")
+	h := restic.Handle{Type: restic.IndexFile, Name: restic.Hash(data).String()}
+	items := []SaveItem{
+		{Handle: h, Reader: restic.NewByteReader(data, nil)},
+		{Handle: restic.Handle{Type: restic.IndexFile}, Reader: restic.NewByteReader(data, nil)},
+	}
+	rtest.Assert(t, be.SaveMany(context.TODO(), items) != nil, "expected an error for an invalid handle")
+
+	results := make(map[string]Result)
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var res Result
+		rtest.OK(t, dec.Decode(&res))
+		results[res.Object] = res
+	}
+
+	rtest.Equals(t, 2, len(results))
+	rtest.Equals(t, Result{Operation: "save", Object: "index/" + h.Name, Bytes: int64(len(data)), Outcome: "ok"}, results["index/"+h.Name])
+
+	res := results["index/"]
+	rtest.Equals(t, "error", res.Outcome)
+	rtest.Assert(t, res.Error != "", "missing error message in %v", res)
+}
+
+func TestRemoveMany(t *testing.T) {
+	be := newTestBackend(t, newTestConfig(t))
+
+	names := saveDataFiles(t, be, 20)
+	var handles []restic.Handle
+	for _, name := range names[:15] {
+		handles = append(handles, restic.Handle{Type: restic.PackFile, Name: name})
+	}
+	rtest.OK(t, be.RemoveMany(context.TODO(), handles))
+
+	remaining, err := be.ListSorted(context.TODO(), restic.PackFile)
+	rtest.OK(t, err)
+	rtest.Equals(t, names[15:], remaining)
+
+	// missing files fail, the other files are still removed
+	handles = []restic.Handle{
+		{Type: restic.PackFile, Name: names[0]},
+		{Type: restic.PackFile, Name: names[15]},
+		{Type: restic.PackFile, Name: names[1]},
+	}
+	err = be.RemoveMany(context.TODO(), handles)
+	rtest.Assert(t, err != nil, "expected an error for missing files")
+	rtest.Assert(t, strings.Contains(err.Error(), "unable to remove 2 of 3 files"), "unexpected error %v", err)
+
+	_, err = be.Stat(context.TODO(), handles[1])
+	rtest.Assert(t, be.IsNotExist(err), "file was not removed: %v", err)
+}
+
+func TestCloseFlushesResults(t *testing.T) {
+	var buf bytes.Buffer
+	wr := bufio.NewWriterSize(&buf, 1<<20)
+
+	cfg := newTestConfig(t)
+	cfg.ResultStream = wr
+	be, err := Create(context.TODO(), cfg)
+	rtest.OK(t, err)
+
+	var items []SaveItem
+	for i := 0; i < 50; i++ {
+		data := []byte(fmt.Sprintf("index file %d", i))
+		h := restic.Handle{Type: restic.IndexFile, Name: restic.Hash(data).String()}
+		items = append(items, SaveItem{Handle: h, Reader: restic.NewByteReader(data, nil)})
+	}
+	rtest.OK(t, be.SaveMany(context.TODO(), items))
+
+	// all results are still buffered
+	rtest.Equals(t, 0, buf.Len())
+
+	rtest.OK(t, be.Close())
+	rtest.Equals(t, 50, strings.Count(buf.String(), "\n"))
+
+	// results reported after Close are dropped
+	be.reportResult("save", "index/foo", 0, nil)
+	rtest.OK(t, wr.Flush())
+	rtest.Equals(t, 50, strings.Count(buf.String(), "\n"))
+}
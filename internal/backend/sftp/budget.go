@@ -0,0 +1,95 @@
+package sftp
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+// ErrRetryBudgetExhausted is returned when an operation failed and the retry
+// budget has been used up, see Config.RetryBudget.
+var ErrRetryBudgetExhausted = errors.New("retry budget exhausted")
+
+// defaultRetryRefill is the default interval after which a spent retry is
+// returned to the budget.
+const defaultRetryRefill = time.Minute
+
+// retryBudget is a token bucket which limits the number of retries across
+// all operations.
+type retryBudget struct {
+	m        sync.Mutex
+	capacity int
+	tokens   int
+	refill   time.Duration
+	last     time.Time
+	now      func() time.Time
+}
+
+func newRetryBudget(cfg Config) *retryBudget {
+	if cfg.RetryBudget == 0 {
+		return nil
+	}
+
+	refill := cfg.RetryRefill
+	if refill <= 0 {
+		refill = defaultRetryRefill
+	}
+
+	clock := clockFor(cfg)
+	return &retryBudget{
+		capacity: int(cfg.RetryBudget),
+		tokens:   int(cfg.RetryBudget),
+		refill:   refill,
+		last:     clock.Now(),
+		now:      clock.Now,
+	}
+}
+
+// take removes a token from the budget. It returns false if the budget is
+// exhausted.
+func (b *retryBudget) take() bool {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	now := b.now()
+	if n := int(now.Sub(b.last) / b.refill); n > 0 {
+		b.tokens += n
+		if b.tokens > b.capacity {
+			b.tokens = b.capacity
+		}
+		b.last = b.last.Add(time.Duration(n) * b.refill)
+	}
+
+	if b.tokens == 0 {
+		return false
+	}
+
+	// start refilling from now if the bucket was full
+	if b.tokens == b.capacity {
+		b.last = now
+	}
+	b.tokens--
+	return true
+}
+
+// takeRetry takes a token from the retry budget before an operation which
+// failed with err is retried by the backend. If the budget is exhausted, err
+// is returned as a permanent error, so that it is not retried by the caller
+// either. Otherwise nil is returned.
+func (r *SFTP) takeRetry(operation string, err error) error {
+	if r.budget == nil || r.budget.take() {
+		return nil
+	}
+
+	debug.Log("retry budget exhausted, not retrying %v: %v", operation, err)
+	var permanent *backoff.PermanentError
+	if errors.As(err, &permanent) {
+		err = permanent.Err
+	}
+	return backoff.Permanent(fmt.Errorf("%w: %v", ErrRetryBudgetExhausted, err))
+}
@@ -0,0 +1,84 @@
+package sftp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+func TestRetryBudgetRefill(t *testing.T) {
+	now := time.Unix(1000, 0)
+	b := newRetryBudget(Config{RetryBudget: 2, RetryRefill: time.Minute})
+	b.now = func() time.Time { return now }
+	b.last = now
+
+	rtest.Assert(t, b.take(), "first retry denied")
+	rtest.Assert(t, b.take(), "second retry denied")
+	rtest.Assert(t, !b.take(), "retry allowed with exhausted budget")
+
+	now = now.Add(59 * time.Second)
+	rtest.Assert(t, !b.take(), "retry allowed before refill")
+
+	now = now.Add(time.Second)
+	rtest.Assert(t, b.take(), "retry denied after refill")
+	rtest.Assert(t, !b.take(), "more than one retry refilled")
+
+	// the budget never exceeds its capacity
+	now = now.Add(time.Hour)
+	rtest.Assert(t, b.take(), "retry denied after refill")
+	rtest.Assert(t, b.take(), "retry denied after refill")
+	rtest.Assert(t, !b.take(), "budget exceeded its capacity")
+
+	rtest.Assert(t, newRetryBudget(Config{}) == nil, "budget enabled by default")
+}
+
+func TestRetryBudget(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.Reconnect = true
+	cfg.ReconnectInitialDelay = -1
+	cfg.MaxRetries = 5
+	cfg.RetryBudget = 2
+	cfg.RetryRefill = time.Hour
+	be := newTestBackend(t, cfg)
+
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+
+	// failed operations which are not retried don't use up the budget
+	for i := 0; i < 3; i++ {
+		_, err := be.Stat(context.TODO(), h)
+		rtest.Assert(t, be.IsNotExist(err), "unexpected error %v", err)
+	}
+
+	// the first attempt and two retries
+	calls := 0
+	err := be.retryOnConnectionLoss(context.TODO(), "test", "", func() error {
+		calls++
+		killServer(t, be)
+		return syscall.EPIPE
+	})
+	rtest.Assert(t, errors.Is(err, ErrRetryBudgetExhausted), "expected ErrRetryBudgetExhausted, got %v", err)
+	var permanent *backoff.PermanentError
+	rtest.Assert(t, errors.As(err, &permanent), "error is not permanent: %v", err)
+	rtest.Equals(t, 3, calls)
+
+	// the budget is shared by all operations
+	killServer(t, be)
+	err = be.Load(context.TODO(), h, 0, 0, func(rd io.Reader) error {
+		return nil
+	})
+	rtest.Assert(t, be.IsNotExist(err), "unexpected error %v", err)
+	err = be.retryOnConnectionLoss(context.TODO(), "test", "", func() error {
+		killServer(t, be)
+		return syscall.EPIPE
+	})
+	rtest.Assert(t, errors.Is(err, ErrRetryBudgetExhausted), "expected ErrRetryBudgetExhausted, got %v", err)
+}
@@ -0,0 +1,135 @@
+package sftp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// slowReader returns zeros and cancels the context after the first read.
+type slowReader struct {
+	length int64
+	pos    int64
+	cancel func()
+}
+
+func (rd *slowReader) Read(p []byte) (int, error) {
+	if rd.pos >= rd.length {
+		return 0, io.EOF
+	}
+	if rd.pos > 0 {
+		rd.cancel()
+		time.Sleep(time.Millisecond)
+	}
+	if int64(len(p)) > rd.length-rd.pos {
+		p = p[:rd.length-rd.pos]
+	}
+	for i := range p {
+		p[i] = 0
+	}
+	rd.pos += int64(len(p))
+	return len(p), nil
+}
+
+func (rd *slowReader) Rewind() error {
+	rd.pos = 0
+	return nil
+}
+
+func (rd *slowReader) Length() int64 { return rd.length }
+func (rd *slowReader) Hash() []byte  { return nil }
+
+func TestSaveCancel(t *testing.T) {
+	be := newTestBackend(t, newTestConfig(t))
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	h := restic.Handle{Type: restic.PackFile, Name: restic.NewRandomID().String()}
+	rd := &slowReader{length: 1 << 30, cancel: cancel}
+
+	start := time.Now()
+	err := be.Save(ctx, h, rd)
+	rtest.Assert(t, errors.Is(err, context.Canceled), "expected context.Canceled, got %v", err)
+	rtest.Assert(t, time.Since(start) < 30*time.Second, "upload was not aborted")
+	rtest.Assert(t, rd.pos < rd.length, "all data was read")
+
+	// the temporary file has been removed
+	rtest.Equals(t, 0, len(dirEntries(t, be, be.Dirname(h))))
+}
+
+func TestListCancel(t *testing.T) {
+	be := newTestBackend(t, newTestConfig(t))
+	saveDataFiles(t, be, 10)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	listed := 0
+	err := be.List(ctx, restic.PackFile, func(restic.FileInfo) error {
+		listed++
+		cancel()
+		return nil
+	})
+	rtest.Assert(t, errors.Is(err, context.Canceled), "expected context.Canceled, got %v", err)
+	rtest.Equals(t, 1, listed)
+
+	// a cancelled context stops the listing before any file is found
+	err = be.List(ctx, restic.PackFile, func(restic.FileInfo) error {
+		t.Error("fn called with cancelled context")
+		return nil
+	})
+	rtest.Assert(t, errors.Is(err, context.Canceled), "expected context.Canceled, got %v", err)
+}
+
+func TestCancelledContext(t *testing.T) {
+	be := newTestBackend(t, newTestConfig(t))
+
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	saveFile(t, be, h, data)
+	saveFile(t, be, restic.Handle{Type: restic.ConfigFile}, data)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+
+	snapshot := restic.Handle{Type: restic.SnapshotFile, Name: restic.NewRandomID().String()}
+	for name, fn := range map[string]func() error{
+		"Pipe": func() error {
+			return be.Pipe(ctx, h, restic.Handle{Type: restic.PackFile, Name: restic.NewRandomID().String()}, nil)
+		},
+		"LoadAndHash": func() error {
+			_, err := be.LoadAndHash(ctx, h, sha256.New())
+			return err
+		},
+		"Peek": func() error {
+			_, err := be.Peek(ctx, h, 2)
+			return err
+		},
+		"LoadConfig": func() error {
+			_, err := be.LoadConfig(ctx, 1024)
+			return err
+		},
+		"PublishSnapshot": func() error {
+			return be.PublishSnapshot(ctx, snapshot, bytes.NewReader(data), []restic.Handle{h})
+		},
+		"Overview": func() error {
+			_, err := be.Overview(ctx)
+			return err
+		},
+		"ResolveSnapshot": func() error {
+			_, err := be.ResolveSnapshot(ctx, "")
+			return err
+		},
+	} {
+		err := fn()
+		rtest.Assert(t, errors.Is(err, context.Canceled), "%v: expected context.Canceled, got %v", name, err)
+	}
+}
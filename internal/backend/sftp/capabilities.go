@@ -0,0 +1,102 @@
+package sftp
+
+import (
+	"os"
+	"sync"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+)
+
+// Capabilities describes the optional features supported by the server and
+// properties of the file system which stores the repository.
+type Capabilities struct {
+	// AtomicReplace is true if existing files can be replaced atomically
+	// (posix-rename@openssh.com).
+	AtomicReplace bool
+	// Hardlinks is true if the server can create hard links
+	// (hardlink@openssh.com).
+	Hardlinks bool
+	// Fsync is true if files can be synced to disk (fsync@openssh.com).
+	Fsync bool
+	// CaseInsensitive is true if the file system does not distinguish names
+	// which differ only in case. Files whose names differ only in case then
+	// refer to the same file, which makes saving the second one fail with
+	// "file already exists" or overwrite the first one.
+	CaseInsensitive bool
+}
+
+// Capabilities returns the capabilities of the server and the file system of
+// the repository. The file system is probed on the first call.
+func (r *SFTP) Capabilities() Capabilities {
+	_, hardlinks := r.client().HasExtension("hardlink@openssh.com")
+	_, fsync := r.client().HasExtension("fsync@openssh.com")
+	return Capabilities{
+		AtomicReplace:   r.HasAtomicReplace(),
+		Hardlinks:       hardlinks,
+		Fsync:           fsync,
+		CaseInsensitive: r.isCaseInsensitive(),
+	}
+}
+
+// caseProber is the part of *sftp.Client used by probeCaseInsensitive.
+type caseProber interface {
+	Mkdir(path string) error
+	Lstat(p string) (os.FileInfo, error)
+	RemoveDirectory(path string) error
+}
+
+// probeCaseInsensitive checks whether the file system is case-insensitive by
+// creating a directory with the name prefix+"-Aa" and checking whether the
+// name prefix+"-aA" refers to it. The directory is removed afterwards.
+func probeCaseInsensitive(c caseProber, prefix string) (bool, error) {
+	name := prefix + "-Aa"
+	err := c.Mkdir(name)
+	if err != nil {
+		return false, errors.Wrap(err, "Mkdir")
+	}
+	defer func() {
+		if err := c.RemoveDirectory(name); err != nil {
+			debug.Log("unable to remove %v: %v", name, err)
+		}
+	}()
+
+	_, err = c.Lstat(prefix + "-aA")
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return false, errors.Wrap(err, "Lstat")
+}
+
+// caseCheck holds the result of probing the file system of the repository.
+// It is shared with the copies returned by WithTag.
+type caseCheck struct {
+	once        sync.Once
+	insensitive bool
+}
+
+// isCaseInsensitive probes the file system of the repository the first time
+// it is called, as this takes several round trips. Errors, for example on
+// read-only servers, are only logged and the file system is assumed to be
+// case-sensitive. Read-only repositories are not probed.
+func (r *SFTP) isCaseInsensitive() bool {
+	r.caseCheck.once.Do(func() {
+		if r.Config.ReadOnly {
+			return
+		}
+
+		insensitive, err := probeCaseInsensitive(r.client(), r.Join(r.p, "restic-case-test-"+tempSuffix()))
+		if err != nil {
+			debug.Log("unable to check whether the file system is case-insensitive: %v", err)
+			return
+		}
+		if insensitive {
+			debug.Log("the file system of %v is case-insensitive", r.p)
+		}
+		r.caseCheck.insensitive = insensitive
+	})
+	return r.caseCheck.insensitive
+}
@@ -0,0 +1,68 @@
+package sftp
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// fakeCaseFS records the directories created by probeCaseInsensitive.
+type fakeCaseFS struct {
+	insensitive bool
+	dirs        map[string]bool
+}
+
+func (fs *fakeCaseFS) key(name string) string {
+	if fs.insensitive {
+		return strings.ToLower(name)
+	}
+	return name
+}
+
+func (fs *fakeCaseFS) Mkdir(path string) error {
+	if fs.dirs[fs.key(path)] {
+		return os.ErrExist
+	}
+	fs.dirs[fs.key(path)] = true
+	return nil
+}
+
+func (fs *fakeCaseFS) Lstat(p string) (os.FileInfo, error) {
+	if !fs.dirs[fs.key(p)] {
+		return nil, os.ErrNotExist
+	}
+	return nil, nil
+}
+
+func (fs *fakeCaseFS) RemoveDirectory(path string) error {
+	if !fs.dirs[fs.key(path)] {
+		return os.ErrNotExist
+	}
+	delete(fs.dirs, fs.key(path))
+	return nil
+}
+
+func TestProbeCaseInsensitive(t *testing.T) {
+	for _, insensitive := range []bool{false, true} {
+		fs := &fakeCaseFS{insensitive: insensitive, dirs: make(map[string]bool)}
+		detected, err := probeCaseInsensitive(fs, "/repo/restic-case-test")
+		rtest.OK(t, err)
+		rtest.Equals(t, insensitive, detected)
+		rtest.Equals(t, 0, len(fs.dirs))
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	be := newTestBackend(t, newTestConfig(t))
+
+	caps := be.Capabilities()
+	rtest.Equals(t, be.HasAtomicReplace(), caps.AtomicReplace)
+	rtest.Assert(t, !caps.CaseInsensitive, "test file system detected as case-insensitive")
+
+	// the probe does not leave anything behind
+	for _, name := range dirEntries(t, be, be.Location()) {
+		rtest.Assert(t, !strings.HasPrefix(name, "restic-case-test-"), "probe %v was not removed", name)
+	}
+}
@@ -0,0 +1,158 @@
+package sftp
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+// checksumDir is the directory in the repository which contains the
+// checksum files written if Config.StoreChecksums is set.
+const checksumDir = "checksums"
+
+// ErrChecksumMismatch is returned by VerifyChecksum if the content of a file
+// does not match its stored checksum.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// checksumFilename returns the name of the checksum file for h. The
+// checksum files are stored in a directory per file type below checksumDir.
+func (r *SFTP) checksumFilename(h restic.Handle) string {
+	if h.Type == restic.ConfigFile {
+		return r.Join(r.p, checksumDir, "config.sha256")
+	}
+	return r.Join(r.p, checksumDir, h.Type.String(), h.Name+".sha256")
+}
+
+// writeChecksum stores the SHA-256 hash sum of the data of the file for h.
+// The checksum file is replaced atomically.
+func (r *SFTP) writeChecksum(h restic.Handle, sum restic.ID) error {
+	return errors.Wrap(r.writeSidecar(h, r.checksumFilename(h), []byte(sum.String()+"\n")), "SaveChecksum")
+}
+
+// copyChecksum stores the checksum of src as the checksum of dst, which has
+// the same content. If src has no checksum, nothing is stored.
+func (r *SFTP) copyChecksum(src, dst restic.Handle) error {
+	sum, err := r.readChecksum(src)
+	if r.IsNotExist(err) {
+		debug.Log("%v has no checksum, none is stored for %v", src, dst)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return r.writeChecksum(dst, sum)
+}
+
+// writeSidecar atomically replaces the small file filename, which stores
+// additional data about the file for h, with data. Missing directories are
+// created.
+func (r *SFTP) writeSidecar(h restic.Handle, filename string, data []byte) error {
+	tmpFilename := r.tempFilenameFor(h, filename)
+	r.journalAdd(tmpFilename)
+	defer r.journalRemove(tmpFilename)
+
+	f, err := r.client().OpenFile(tmpFilename, os.O_CREATE|os.O_EXCL|os.O_WRONLY)
+	if r.IsNotExist(err) {
+		dir := path.Dir(filename)
+		if mkdirErr := r.mkdirAll(dir); mkdirErr != nil {
+			debug.Log("error creating dir %v: %v", dir, mkdirErr)
+		} else {
+			f, err = r.client().OpenFile(tmpFilename, os.O_CREATE|os.O_EXCL|os.O_WRONLY)
+		}
+	}
+	if err != nil {
+		return errors.Wrap(err, "OpenFile")
+	}
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Close()
+	} else {
+		_ = f.Close()
+	}
+	if err == nil {
+		err = r.rename("", tmpFilename, filename)
+	}
+	if err != nil {
+		if rmErr := r.client().Remove(tmpFilename); rmErr != nil {
+			debug.Log("sftp: failed to remove temp file %v: %v", tmpFilename, rmErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// removeChecksum removes the checksum file for h, if it exists. Errors are
+// only logged, a stale checksum file is replaced when the file is saved again.
+func (r *SFTP) removeChecksum(h restic.Handle) {
+	err := r.client().Remove(r.checksumFilename(h))
+	if err != nil && !r.IsNotExist(err) {
+		debug.Log("unable to remove checksum for %v: %v", h, err)
+	}
+}
+
+// readChecksum returns the stored checksum for h. If there is none, an error
+// for which IsNotExist returns true is returned.
+func (r *SFTP) readChecksum(h restic.Handle) (restic.ID, error) {
+	f, err := r.client().Open(r.checksumFilename(h))
+	if err != nil {
+		return restic.ID{}, err
+	}
+
+	buf, err := io.ReadAll(io.LimitReader(f, int64(2*len(restic.ID{})+1)))
+	_ = f.Close()
+	if err != nil {
+		return restic.ID{}, errors.Wrap(err, "Read")
+	}
+
+	id, err := restic.ParseID(strings.TrimSpace(string(buf)))
+	if err != nil {
+		return restic.ID{}, backoff.Permanent(errors.Errorf("invalid checksum file for %v: %v", h, err))
+	}
+	return id, nil
+}
+
+// VerifyChecksum reads the file for h and compares its SHA-256 hash to the
+// checksum stored when the file was saved with Config.StoreChecksums set. If
+// they differ, an error wrapping ErrChecksumMismatch is returned. If no
+// checksum has been stored, the returned error satisfies IsNotExist.
+func (r *SFTP) VerifyChecksum(ctx context.Context, h restic.Handle) error {
+	debug.Log("VerifyChecksum %v%v", h, r.tagInfo())
+	if err := h.Valid(); err != nil {
+		return backoff.Permanent(err)
+	}
+
+	r.sem.GetToken()
+	expected, err := r.readChecksum(h)
+	r.sem.ReleaseToken()
+	if err != nil {
+		return err
+	}
+
+	hash := sha256.New()
+	err = r.Load(ctx, h, 0, 0, func(rd io.Reader) error {
+		hash.Reset()
+		_, err := io.Copy(hash, rd)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	var id restic.ID
+	copy(id[:], hash.Sum(nil))
+	if !id.Equal(expected) {
+		return backoff.Permanent(fmt.Errorf("%w for %v: stored %v, computed %v", ErrChecksumMismatch, h, expected.Str(), id.Str()))
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package sftp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestStoreChecksums(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.StoreChecksums = true
+	cfg.CompressTypes = []restic.FileType{restic.IndexFile}
+	be := newTestBackend(t, cfg)
+
+	data := []byte("foobar")
+	for _, h := range []restic.Handle{
+		{Type: restic.PackFile, Name: restic.Hash(data).String()},
+		{Type: restic.IndexFile, Name: restic.Hash(data).String()},
+		{Type: restic.ConfigFile},
+	} {
+		saveFile(t, be, h, data)
+
+		buf, err := os.ReadFile(be.checksumFilename(h))
+		rtest.OK(t, err)
+		rtest.Equals(t, restic.Hash(data).String()+"\n", string(buf))
+
+		rtest.OK(t, be.VerifyChecksum(context.TODO(), h))
+	}
+
+	// verifying is aborted when the context is cancelled
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	err := be.VerifyChecksum(ctx, h)
+	rtest.Assert(t, errors.Is(err, context.Canceled), "expected context.Canceled, got %v", err)
+
+	// the checksum is removed together with the file
+	rtest.OK(t, be.Remove(context.TODO(), h))
+	_, err = os.Stat(be.checksumFilename(h))
+	rtest.Assert(t, errors.Is(err, os.ErrNotExist), "checksum file still exists: %v", err)
+}
+
+func TestStoreChecksumsPipe(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.StoreChecksums = true
+	be := newTestBackend(t, cfg)
+
+	data := []byte("foobar")
+	src := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	other := []byte("other data")
+	rtest.OK(t, be.SaveMany(context.TODO(), []SaveItem{
+		{Handle: src, Reader: restic.NewByteReader(data, nil)},
+		{Handle: restic.Handle{Type: restic.IndexFile, Name: restic.Hash(other).String()}, Reader: restic.NewByteReader(other, nil)},
+	}))
+	rtest.OK(t, be.VerifyChecksum(context.TODO(), src))
+
+	for _, transform := range []func(io.Reader) io.Reader{nil, upperTransform} {
+		dst := restic.Handle{Type: restic.PackFile, Name: restic.NewRandomID().String()}
+		rtest.OK(t, be.Pipe(context.TODO(), src, dst, transform))
+		rtest.OK(t, be.VerifyChecksum(context.TODO(), dst))
+	}
+}
+
+func TestVerifyChecksumCorrupted(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.StoreChecksums = true
+	be := newTestBackend(t, cfg)
+
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	saveFile(t, be, h, data)
+
+	filename := be.Filename(h)
+	rtest.OK(t, os.Chmod(filename, 0600))
+	rtest.OK(t, os.WriteFile(filename, []byte("fooBar"), 0600))
+
+	err := be.VerifyChecksum(context.TODO(), h)
+	rtest.Assert(t, errors.Is(err, ErrChecksumMismatch), "expected ErrChecksumMismatch, got %v", err)
+}
+
+func TestVerifyChecksumMissing(t *testing.T) {
+	be := newTestBackend(t, newTestConfig(t))
+
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	saveFile(t, be, h, data)
+
+	_, err := os.Stat(be.Join(be.p, checksumDir))
+	rtest.Assert(t, errors.Is(err, os.ErrNotExist), "checksums stored by default")
+
+	err = be.VerifyChecksum(context.TODO(), h)
+	rtest.Assert(t, be.IsNotExist(err), "expected not exist error, got %v", err)
+}
@@ -0,0 +1,64 @@
+package sftp
+
+import (
+	"os"
+
+	"github.com/restic/restic/internal/errors"
+
+	"github.com/pkg/sftp"
+)
+
+// Errors returned by Stat, Load, Save and Remove are classified by the status
+// code sent by the server, callers can check them with errors.Is. The original
+// error is still part of the chain.
+var (
+	// ErrPermission is returned when the server denied access to a file.
+	ErrPermission = errors.New("sftp: permission denied")
+	// ErrNotFound is returned when a file or directory does not exist.
+	ErrNotFound = errors.New("sftp: file not found")
+	// ErrFailure is returned when the server reported a generic failure.
+	ErrFailure = errors.New("sftp: operation failed")
+	// ErrEOF is returned when the server reported an unexpected end of file.
+	ErrEOF = errors.New("sftp: unexpected end of file")
+)
+
+// classifiedError adds one of the sentinel errors above to err, its message
+// is not changed.
+type classifiedError struct {
+	kind error
+	err  error
+}
+
+func (e *classifiedError) Error() string        { return e.err.Error() }
+func (e *classifiedError) Unwrap() error        { return e.err }
+func (e *classifiedError) Is(target error) bool { return target == e.kind }
+
+// classifyError maps the status code of the error returned by the server to
+// ErrPermission, ErrNotFound, ErrFailure or ErrEOF. Other errors are returned
+// unchanged.
+func classifyError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var kind error
+	var statusErr *sftp.StatusError
+	hasStatus := errors.As(err, &statusErr)
+	switch {
+	case errors.Is(err, os.ErrNotExist) || hasStatus && statusErr.FxCode() == sftp.ErrSSHFxNoSuchFile:
+		kind = ErrNotFound
+	case errors.Is(err, os.ErrPermission) || hasStatus && statusErr.FxCode() == sftp.ErrSSHFxPermissionDenied:
+		kind = ErrPermission
+	case hasStatus && statusErr.FxCode() == sftp.ErrSSHFxFailure:
+		kind = ErrFailure
+	case hasStatus && statusErr.FxCode() == sftp.ErrSSHFxEOF:
+		kind = ErrEOF
+	default:
+		return err
+	}
+
+	if errors.Is(err, kind) {
+		return err
+	}
+	return &classifiedError{kind: kind, err: err}
+}
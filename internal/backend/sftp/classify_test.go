@@ -0,0 +1,62 @@
+package sftp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/pkg/sftp"
+)
+
+func TestClassifyError(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		kind error
+	}{
+		{&sftp.StatusError{Code: uint32(sftp.ErrSSHFxNoSuchFile)}, ErrNotFound},
+		{&sftp.StatusError{Code: uint32(sftp.ErrSSHFxPermissionDenied)}, ErrPermission},
+		{&sftp.StatusError{Code: uint32(sftp.ErrSSHFxFailure)}, ErrFailure},
+		{&sftp.StatusError{Code: uint32(sftp.ErrSSHFxEOF)}, ErrEOF},
+		{&os.PathError{Op: "open", Path: "foo", Err: os.ErrNotExist}, ErrNotFound},
+		{errors.Wrap(os.ErrPermission, "Lstat"), ErrPermission},
+		{backoff.Permanent(errors.Wrap(&sftp.StatusError{Code: uint32(sftp.ErrSSHFxPermissionDenied)}, "Remove")), ErrPermission},
+		{&sftp.StatusError{Code: uint32(sftp.ErrSSHFxOpUnsupported)}, nil},
+		{errors.New("other error"), nil},
+	} {
+		t.Run(fmt.Sprint(test.err), func(t *testing.T) {
+			err := classifyError(test.err)
+			rtest.Equals(t, test.err.Error(), err.Error())
+			rtest.Assert(t, errors.Is(err, test.err), "original error missing from chain")
+			for _, kind := range []error{ErrNotFound, ErrPermission, ErrFailure, ErrEOF} {
+				rtest.Equals(t, kind == test.kind, errors.Is(err, kind))
+			}
+		})
+	}
+
+	rtest.Assert(t, classifyError(nil) == nil, "nil error was classified")
+}
+
+func TestClassifyErrorNotFound(t *testing.T) {
+	be := newTestBackend(t, newTestConfig(t))
+
+	h := restic.Handle{Type: restic.PackFile, Name: restic.NewRandomID().String()}
+
+	_, err := be.Stat(context.TODO(), h)
+	rtest.Assert(t, errors.Is(err, ErrNotFound), "Stat: expected ErrNotFound, got %v", err)
+	rtest.Assert(t, be.IsNotExist(err), "Stat: error is no longer a not exist error: %v", err)
+
+	err = be.Load(context.TODO(), h, 0, 0, func(rd io.Reader) error {
+		return nil
+	})
+	rtest.Assert(t, errors.Is(err, ErrNotFound), "Load: expected ErrNotFound, got %v", err)
+
+	err = be.Remove(context.TODO(), h)
+	rtest.Assert(t, errors.Is(err, ErrNotFound), "Remove: expected ErrNotFound, got %v", err)
+}
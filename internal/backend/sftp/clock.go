@@ -0,0 +1,49 @@
+package sftp
+
+import "time"
+
+// clock provides the current time and timers to the time-dependent parts of
+// the backend, so that tests can control them.
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	NewTimer(d time.Duration) timer
+}
+
+// timer is a timer which can be stopped and reset, like time.Timer.
+type timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// realClock is the clock used unless a test has set another one.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) NewTimer(d time.Duration) timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+type realTimer struct {
+	*time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+// clockFor returns the clock configured in cfg, or the real clock.
+func clockFor(cfg Config) clock {
+	if cfg.clk != nil {
+		return cfg.clk
+	}
+	return realClock{}
+}
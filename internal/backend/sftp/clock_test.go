@@ -0,0 +1,267 @@
+package sftp
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// fakeClock is a clock which only advances when Advance is called. If instant
+// is set, all timers fire immediately. The durations passed to After are
+// recorded in delays.
+type fakeClock struct {
+	m       sync.Mutex
+	now     time.Time
+	instant bool
+	timers  []*fakeTimer
+	delays  []time.Duration
+}
+
+type fakeTimer struct {
+	c  *fakeClock
+	at time.Time
+	ch chan time.Time
+}
+
+// setClock makes backends created with cfg use clk instead of the real clock.
+func setClock(cfg *Config, clk clock) {
+	cfg.clk = clk
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1000, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.delays = append(c.delays, d)
+	t := &fakeTimer{c: c, ch: make(chan time.Time, 1)}
+	c.start(t, d)
+	return t.ch
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) timer {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	t := &fakeTimer{c: c, ch: make(chan time.Time, 1)}
+	c.start(t, d)
+	return t
+}
+
+// start fires t after d, the caller must hold the lock.
+func (c *fakeClock) start(t *fakeTimer, d time.Duration) {
+	if c.instant || d <= 0 {
+		t.ch <- c.now
+		return
+	}
+	t.at = c.now.Add(d)
+	c.timers = append(c.timers, t)
+}
+
+// stop removes t from the pending timers and reports whether it was pending,
+// the caller must hold the lock.
+func (c *fakeClock) stop(t *fakeTimer) bool {
+	for i, pending := range c.timers {
+		if pending == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.c.m.Lock()
+	defer t.c.m.Unlock()
+	return t.c.stop(t)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.c.m.Lock()
+	defer t.c.m.Unlock()
+	pending := t.c.stop(t)
+	t.c.start(t, d)
+	return pending
+}
+
+// Advance moves the clock forward by d and fires all expired timers.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.now = c.now.Add(d)
+	var pending []*fakeTimer
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			pending = append(pending, t)
+			continue
+		}
+		t.ch <- c.now
+	}
+	c.timers = pending
+}
+
+// waitTimers blocks until n timers are pending.
+func (c *fakeClock) waitTimers(t testing.TB, n int) {
+	for i := 0; i < 1000; i++ {
+		c.m.Lock()
+		pending := len(c.timers)
+		c.m.Unlock()
+		if pending == n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timeout waiting for %d pending timers", n)
+}
+
+func TestClockReconnectDelay(t *testing.T) {
+	clock := newFakeClock()
+	cfg := newTestConfig(t)
+	cfg.Reconnect = true
+	cfg.ReconnectInitialDelay = time.Hour
+	setClock(&cfg, clock)
+	be := newTestBackend(t, cfg)
+
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	saveFile(t, be, h, data)
+
+	killServer(t, be)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := be.Stat(context.TODO(), h)
+		done <- err
+	}()
+
+	clock.waitTimers(t, 1)
+	clock.Advance(time.Hour - time.Second)
+	select {
+	case err := <-done:
+		t.Fatalf("Stat returned before the delay expired: %v", err)
+	default:
+	}
+
+	clock.Advance(time.Second)
+	rtest.OK(t, <-done)
+	rtest.Equals(t, 1, be.ReconnectCount())
+}
+
+func TestClockRetryBackoff(t *testing.T) {
+	clock := newFakeClock()
+	clock.instant = true
+	cfg := newTestConfig(t)
+	cfg.Reconnect = true
+	cfg.MaxRetries = 4
+	cfg.RetryBackoff = time.Second
+	setClock(&cfg, clock)
+	be, err := Create(context.TODO(), cfg)
+	rtest.OK(t, err)
+	defer func() {
+		// the server may be killed before it has exited by itself, as the
+		// close timeout expires immediately
+		_ = be.Close()
+	}()
+
+	// the connection is still alive, so the error is not retried after
+	// waiting for the backoff
+	calls := 0
+	err = be.retryOnConnectionLoss(context.TODO(), "test", "", func() error {
+		calls++
+		return syscall.EPIPE
+	})
+	rtest.Assert(t, err == syscall.EPIPE, "unexpected error %v", err)
+	rtest.Equals(t, 1, calls)
+	rtest.Equals(t, []time.Duration{time.Second}, clock.delays)
+
+	// each retry after the connection was lost waits twice as long
+	calls = 0
+	err = be.retryOnConnectionLoss(context.TODO(), "test", "", func() error {
+		calls++
+		if calls < 3 {
+			killServer(t, be)
+		}
+		return syscall.EPIPE
+	})
+	rtest.Assert(t, err == syscall.EPIPE, "unexpected error %v", err)
+	rtest.Equals(t, 3, calls)
+	rtest.Equals(t, 2, be.ReconnectCount())
+	rtest.Equals(t, time.Duration(4*time.Second), clock.delays[len(clock.delays)-1])
+}
+
+// blockingFlusher blocks in Flush until unblock is closed.
+type blockingFlusher struct {
+	strings.Builder
+	unblock chan struct{}
+}
+
+func (f *blockingFlusher) Flush() error {
+	<-f.unblock
+	return nil
+}
+
+func TestClockCloseResultsTimeout(t *testing.T) {
+	clock := newFakeClock()
+	clock.instant = true
+	wr := &blockingFlusher{unblock: make(chan struct{})}
+
+	cfg := newTestConfig(t)
+	cfg.ResultStream = wr
+	setClock(&cfg, clock)
+	be, err := Create(context.TODO(), cfg)
+	rtest.OK(t, err)
+	defer func() {
+		// flushing times out again
+		_ = be.Close()
+	}()
+
+	err = be.closeResults(time.Hour)
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "timed out"), "expected timeout, got %v", err)
+	rtest.Equals(t, []time.Duration{time.Hour}, clock.delays)
+	close(wr.unblock)
+}
+
+func TestClockTrash(t *testing.T) {
+	clock := newFakeClock()
+	cfg := newTestConfig(t)
+	cfg.TrashDir = "trash"
+	setClock(&cfg, clock)
+	be := newTestBackend(t, cfg)
+
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	saveFile(t, be, h, data)
+	rtest.OK(t, be.Remove(context.TODO(), h))
+
+	trash := dirEntries(t, be, be.trashDir())
+	rtest.Equals(t, 1, len(trash))
+	rtest.Assert(t, strings.HasPrefix(trash[0], clock.Now().UTC().Format(trashTimeFormat)), "unexpected name in trash: %v", trash[0])
+
+	clock.Advance(59 * time.Minute)
+	rtest.OK(t, be.EmptyTrash(context.TODO(), time.Hour))
+	rtest.Equals(t, 1, len(dirEntries(t, be, be.trashDir())))
+
+	clock.Advance(time.Minute)
+	rtest.OK(t, be.EmptyTrash(context.TODO(), time.Hour))
+	rtest.Equals(t, []string(nil), dirEntries(t, be, be.trashDir()))
+}
@@ -0,0 +1,49 @@
+package sftp
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestCloseNative(t *testing.T) {
+	srv := newTestSSHServer(t)
+	cfg := nativeTestConfig(t, srv)
+	cfg.IdentityFile = writeIdentity(t, t.TempDir(), "")
+
+	be, err := Create(context.TODO(), cfg)
+	rtest.OK(t, err)
+	rtest.Assert(t, be.conn.cmd == nil, "native connection has an ssh process")
+	rtest.OK(t, be.Close())
+}
+
+func TestCloseExited(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.CloseTimeout = time.Minute
+	be, err := Create(context.TODO(), cfg)
+	rtest.OK(t, err)
+
+	// the server exits when the connection is closed, Close must not wait
+	// for the timeout
+	start := time.Now()
+	rtest.OK(t, be.Close())
+	rtest.Assert(t, time.Since(start) < 10*time.Second, "Close took %v", time.Since(start))
+	rtest.Assert(t, be.conn.cmd.ProcessState.Success(), "server process did not exit cleanly: %v", be.conn.cmd.ProcessState)
+}
+
+func TestCloseHung(t *testing.T) {
+	cfg := newTestConfig(t)
+	// the process keeps running after the server has exited
+	cfg.Command = fmt.Sprintf(`sh -c '%q -e; exec sleep 60'`, testServer)
+	cfg.CloseTimeout = 100 * time.Millisecond
+	be, err := Create(context.TODO(), cfg)
+	rtest.OK(t, err)
+
+	start := time.Now()
+	rtest.OK(t, be.Close())
+	rtest.Assert(t, time.Since(start) < 10*time.Second, "Close took %v", time.Since(start))
+	rtest.Assert(t, !be.conn.cmd.ProcessState.Success(), "process was not killed")
+}
@@ -0,0 +1,134 @@
+package sftp
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// compressedMarker precedes the gzip stream in compressed files. Files
+// without it are read unmodified, so that files saved before compression was
+// enabled for their type can still be loaded.
+var compressedMarker = []byte("RSGZ\x01")
+
+// compressed reports whether files of type t are compressed.
+func (r *SFTP) compressed(t restic.FileType) bool {
+	for _, ct := range r.Config.CompressTypes {
+		if ct == t {
+			return true
+		}
+	}
+	return false
+}
+
+// writeTransform returns the transformation applied to the data of the file
+// for h when saving it, or nil if the data is stored unmodified. Compression
+// is applied before the configured WriteTransform.
+func (r *SFTP) writeTransform(h restic.Handle) func(io.Writer) io.Writer {
+	if !r.compressed(h.Type) {
+		return r.Config.WriteTransform
+	}
+
+	return func(w io.Writer) io.Writer {
+		if r.Config.WriteTransform == nil {
+			return &compressWriter{w: w}
+		}
+
+		tw := r.Config.WriteTransform(w)
+		cw := &compressWriter{w: tw}
+		if c, ok := tw.(io.Closer); ok {
+			cw.closer = c
+		}
+		return cw
+	}
+}
+
+// readTransform returns the transformation which reverses writeTransform(h),
+// or nil if the data is stored unmodified.
+func (r *SFTP) readTransform(h restic.Handle) func(io.Reader) io.Reader {
+	if !r.compressed(h.Type) {
+		return r.Config.ReadTransform
+	}
+
+	return func(rd io.Reader) io.Reader {
+		if r.Config.ReadTransform != nil {
+			rd = r.Config.ReadTransform(rd)
+		}
+		return &decompressReader{rd: bufio.NewReader(rd)}
+	}
+}
+
+// compressWriter writes the marker and the gzip compressed data to w. Close
+// must be called to flush the data, it also closes closer if it is set.
+type compressWriter struct {
+	w      io.Writer
+	closer io.Closer
+	gz     *gzip.Writer
+}
+
+func (cw *compressWriter) start() error {
+	if cw.gz != nil {
+		return nil
+	}
+
+	if _, err := cw.w.Write(compressedMarker); err != nil {
+		return err
+	}
+	cw.gz = gzip.NewWriter(cw.w)
+	return nil
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if err := cw.start(); err != nil {
+		return 0, err
+	}
+	return cw.gz.Write(p)
+}
+
+func (cw *compressWriter) Close() error {
+	// empty files are compressed as well
+	if err := cw.start(); err != nil {
+		return err
+	}
+	if err := cw.gz.Close(); err != nil {
+		return err
+	}
+
+	if cw.closer != nil {
+		return cw.closer.Close()
+	}
+	return nil
+}
+
+// decompressReader returns the decompressed data from rd if it starts with
+// the marker, and the data from rd unmodified otherwise.
+type decompressReader struct {
+	rd *bufio.Reader
+	r  io.Reader
+}
+
+func (dr *decompressReader) Read(p []byte) (int, error) {
+	if dr.r == nil {
+		marker, err := dr.rd.Peek(len(compressedMarker))
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+
+		if !bytes.Equal(marker, compressedMarker) {
+			dr.r = dr.rd
+		} else {
+			_, _ = dr.rd.Discard(len(compressedMarker))
+			gz, err := gzip.NewReader(dr.rd)
+			if err != nil {
+				return 0, errors.Wrap(err, "gzip.NewReader")
+			}
+			dr.r = gz
+		}
+	}
+
+	return dr.r.Read(p)
+}
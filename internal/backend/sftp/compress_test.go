@@ -0,0 +1,117 @@
+package sftp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestCompressTypes(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.CompressTypes = []restic.FileType{restic.IndexFile, restic.SnapshotFile, restic.ConfigFile}
+	be := newTestBackend(t, cfg)
+
+	data := []byte(strings.Repeat(`{"id":"0123456789abcdef","type":"tree"},`, 200))
+
+	for _, test := range []struct {
+		t          restic.FileType
+		compressed bool
+	}{
+		{restic.PackFile, false},
+		{restic.KeyFile, false},
+		{restic.LockFile, false},
+		{restic.IndexFile, true},
+		{restic.SnapshotFile, true},
+		{restic.ConfigFile, true},
+	} {
+		t.Run(test.t.String(), func(t *testing.T) {
+			h := restic.Handle{Type: test.t, Name: restic.Hash(data).String()}
+			if test.t == restic.ConfigFile {
+				h.Name = ""
+			}
+			saveFile(t, be, h, data)
+
+			stored, err := os.ReadFile(be.Filename(h))
+			rtest.OK(t, err)
+			rtest.Equals(t, test.compressed, bytes.HasPrefix(stored, compressedMarker))
+			if !test.compressed {
+				rtest.Equals(t, data, stored)
+			}
+
+			fi, err := be.Stat(context.TODO(), h)
+			rtest.OK(t, err)
+			rtest.Equals(t, int64(len(stored)), fi.Size)
+			rtest.Assert(t, !test.compressed || fi.Size < int64(len(data)), "data was not compressed, size %v", fi.Size)
+			rtest.OK(t, be.List(context.TODO(), test.t, func(fi restic.FileInfo) error {
+				rtest.Equals(t, int64(len(stored)), fi.Size)
+				return nil
+			}))
+
+			buf, err := backend.LoadAll(context.TODO(), nil, be, h)
+			rtest.OK(t, err)
+			rtest.Equals(t, data, buf)
+
+			// offsets refer to the uncompressed data
+			err = be.Load(context.TODO(), h, 100, 1000, func(rd io.Reader) error {
+				buf, err := io.ReadAll(rd)
+				rtest.OK(t, err)
+				rtest.Equals(t, data[1000:1100], buf)
+				return nil
+			})
+			rtest.OK(t, err)
+		})
+	}
+}
+
+func TestCompressTypesUncompressedFiles(t *testing.T) {
+	cfg := newTestConfig(t)
+	be := newTestBackend(t, cfg)
+
+	data := []byte("index saved before compression was enabled")
+	h := restic.Handle{Type: restic.IndexFile, Name: restic.Hash(data).String()}
+	saveFile(t, be, h, data)
+
+	// an empty file does not contain the marker either
+	empty := restic.Handle{Type: restic.IndexFile, Name: restic.Hash(nil).String()}
+	saveFile(t, be, empty, nil)
+
+	cfg.CompressTypes = []restic.FileType{restic.IndexFile}
+	be = newTestBackend(t, cfg)
+
+	buf, err := backend.LoadAll(context.TODO(), nil, be, h)
+	rtest.OK(t, err)
+	rtest.Equals(t, data, buf)
+
+	buf, err = backend.LoadAll(context.TODO(), nil, be, empty)
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, len(buf))
+}
+
+func TestCompressTypesTransform(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.CompressTypes = []restic.FileType{restic.IndexFile}
+	cfg.WriteTransform = func(wr io.Writer) io.Writer { return xorWriter{wr} }
+	cfg.ReadTransform = func(rd io.Reader) io.Reader { return xorReader{rd} }
+	be := newTestBackend(t, cfg)
+
+	data := []byte(strings.Repeat("index data ", 100))
+	id := restic.Hash(data)
+	h := restic.Handle{Type: restic.IndexFile, Name: id.String()}
+	rtest.OK(t, be.SaveVerified(context.TODO(), h, restic.NewByteReader(data, nil), id))
+
+	// the compressed data is transformed
+	stored, err := os.ReadFile(be.Filename(h))
+	rtest.OK(t, err)
+	rtest.Assert(t, !bytes.HasPrefix(stored, compressedMarker), "compressed data was stored without transformation")
+
+	buf, err := backend.LoadAll(context.TODO(), nil, be, h)
+	rtest.OK(t, err)
+	rtest.Equals(t, data, buf)
+}
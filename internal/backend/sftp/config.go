@@ -1,22 +1,211 @@
 package sftp
 
 import (
+	"io"
 	"net/url"
 	"path"
+	"reflect"
 	"strings"
+	"time"
 
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/options"
+	"github.com/restic/restic/internal/restic"
 )
 
 // Config collects all information required to connect to an sftp server.
 type Config struct {
 	User, Host, Port, Path string
 
-	Layout  string `option:"layout" help:"use this backend directory layout (default: auto-detect)"`
-	Command string `option:"command" help:"specify command to create sftp connection"`
+	Layout    string `option:"layout" help:"use this backend directory layout (default: auto-detect)"`
+	Command   string `option:"command" help:"specify command to create sftp connection"`
+	SSHBinary string `option:"ssh-binary" help:"path to the ssh program (default: ssh)"`
+
+	// ExtraArgs are passed to the ssh program after the connection
+	// arguments, for example []string{"-o", "ServerAliveInterval=30"}. They
+	// must only contain options and their values.
+	ExtraArgs []string
+
+	HostKeyFingerprint string `option:"host-key-fingerprint" help:"only connect if the SHA256 fingerprint of the host key matches"`
+
+	// ProxyJump is passed to ssh as the -J option. It contains one or more
+	// comma-separated jump hosts in the form [user@]host[:port].
+	// HostKeyFingerprint only applies to the target host, the host keys of
+	// the jump hosts are checked by ssh as usual.
+	ProxyJump string `option:"proxy-jump" help:"connect via these comma-separated jump hosts ([user@]host[:port]), passed to ssh as -J"`
+
+	// IdentityFile is the path to a private key in PEM format. If it is set,
+	// the connection is established directly without running ssh. Passphrase
+	// decrypts the key, if it is encrypted. The host key must either match
+	// HostKeyFingerprint or be listed in KnownHostsFile. If
+	// InsecureAcceptUnknownHostKeys is set, host keys which are not listed
+	// are accepted as well, but a host key which differs from the listed one
+	// is always rejected.
+	IdentityFile string `option:"identity-file" help:"connect without running ssh and authenticate with the private key in this file"`
+	Passphrase   string `secret:"true"`
+
+	KnownHostsFile                string `option:"known-hosts-file" help:"verify host keys using this file when connecting with an identity file (default: ~/.ssh/known_hosts)"`
+	InsecureAcceptUnknownHostKeys bool   `option:"insecure-accept-unknown-host-keys" help:"accept host keys which are not listed in the known_hosts file when connecting with an identity file"`
+
+	// If UseSSHConfig is set, Host is looked up as a host alias in the OpenSSH
+	// config file SSHConfigFile, and the host name, port and user found there
+	// are used unless they are set explicitly. This only applies to
+	// connections established with IdentityFile, as ssh reads its config file
+	// itself. Hosts which are reached via ProxyJump or ProxyCommand are
+	// rejected.
+	UseSSHConfig  bool   `option:"use-ssh-config" help:"resolve the host as an alias from the ssh config file when connecting with an identity file"`
+	SSHConfigFile string `option:"ssh-config-file" help:"read host aliases from this file with use-ssh-config (default: ~/.ssh/config)"`
+
+	// KeepaliveInterval is the interval for sending keepalive requests on
+	// connections established with an identity file. They are sent while
+	// files are transferred as well, so that servers which drop silent
+	// connections do not close the connection while a slow upload stalls.
+	KeepaliveInterval time.Duration `option:"keepalive-interval" help:"send SSH keepalive requests at this interval when connecting with an identity file, negative to disable (default: 15s)"`
+
+	// AllowExec enables Exec, which runs arbitrary commands on the server.
+	// It is disabled by default, as the commands are not limited to the
+	// repository.
+	AllowExec bool `option:"allow-exec" help:"allow running remote commands over connections established with an identity file"`
+
+	// Env sets environment variables for the ssh process. If InheritEnv is
+	// set, they are merged into the environment of the current process,
+	// otherwise only the variables in Env are passed. If Env is nil, the
+	// environment of the current process is used. Variables starting with
+	// RESTIC_ are never passed to the ssh process.
+	Env        map[string]string `secret:"true"`
+	InheritEnv bool
+
+	// StderrPolicy configures which lines written to stderr by the ssh
+	// process abort the connection and which ones are ignored.
+	StderrPolicy StderrPolicy
 
 	Connections uint `option:"connections" help:"set a limit for the number of concurrent connections (default: 5)"`
+	Reconnect   bool `option:"reconnect" help:"reconnect automatically if the connection to the server is lost"`
+
+	// NumConnections is the number of connections opened to the server.
+	// Uploads and downloads are spread across all of them, which is faster
+	// than a single connection on links with a high latency. Additional
+	// connections which are lost are re-established if Reconnect is set.
+	NumConnections uint `option:"num-connections" help:"open this many connections to the server and spread uploads and downloads across them (default: 1)"`
+
+	// Timeout limits the time for starting the sftp session, and how long
+	// each attempt of Save, Load, Stat, Remove and List may wait for the
+	// server without making progress. When it expires, an error wrapping
+	// ErrTimeout is returned as soon as the operation has stopped. If it is
+	// still hanging after another Timeout, its connection is closed.
+	Timeout time.Duration `option:"timeout" help:"abort connecting and operations which make no progress for this long (default: no timeout)"`
+
+	// CloseTimeout is the time Close waits for the ssh process to exit
+	// before killing it.
+	CloseTimeout time.Duration `option:"close-timeout" help:"wait this long for the ssh process to exit when closing the connection before killing it (default: 2s)"`
+
+	ReconnectInitialDelay time.Duration `option:"reconnect-initial-delay" help:"wait this long before reconnecting after the connection was lost, negative to reconnect immediately (default: 100ms)"`
+	MaxRetries            uint          `option:"max-retries" help:"retry operations this often after reconnecting if the connection was lost (default: 3)"`
+	RetryBackoff          time.Duration `option:"retry-backoff" help:"wait up to this long for the connection to exit after an operation failed with a connection error, it is only retried if it did, doubled for each further retry (default: 100ms)"`
+
+	MaxConcurrentLists uint `option:"max-concurrent-lists" help:"set a limit for the number of concurrent directory listings (default: unlimited)"`
+	MaxListDepth       uint `option:"max-list-depth" help:"fail listing files nested deeper than this below the base directory (default: 8)"`
+
+	TrashDir string `option:"trash-dir" help:"move removed files to this directory relative to the repository instead of deleting them"`
+
+	OverwritePolicy string `option:"overwrite-policy" help:"what to do when saving a file which already exists: error, skip or overwrite (default: overwrite if supported by the server)"`
+	SymlinkPolicy   string `option:"symlink-policy" help:"what to do with symlinks found when listing files: include, skip or error (default: skip)"`
+	RemoveEmptyDirs bool   `option:"remove-empty-dirs" help:"remove data subdirectories when the last file in them is removed"`
+
+	StrictNames bool `option:"strict-names" help:"reject file names which are not 64 lowercase hex characters"`
+
+	// ReadOnly makes all methods which modify the repository fail with
+	// ErrReadOnly before sending any request to the server, and Create
+	// refuses to create a repository.
+	ReadOnly bool `option:"read-only" help:"open the repository read-only, all modifications fail"`
+
+	StartupTempPolicy string        `option:"startup-temp-policy" help:"what to do with temporary files left behind by crashed processes when opening the repository: leave, cleanup (journaled files) or cleanup-older-than (default: leave)"`
+	StartupTempAge    time.Duration `option:"startup-temp-age" help:"minimum age of temporary files removed by the cleanup-older-than startup temp policy, at least 1h (default: 24h)"`
+
+	RetryBudget uint          `option:"retry-budget" help:"limit the number of retries after a lost connection across the whole session (default: unlimited)"`
+	RetryRefill time.Duration `option:"retry-refill" help:"return one retry to the budget after this duration (default: 1m)"`
+
+	CanonicalizePath bool `option:"canonicalize-path" help:"resolve the repository path on the server before using it"`
+	OpenRetries      uint `option:"open-retries" help:"retry this often if the repository directory is not found when opening it (default: 0)"`
+	GroupShared      bool `option:"group-shared" help:"make new files and directories accessible for the group (setgid directories, group-readable files)"`
+	FileMode         uint `option:"file-mode" help:"set the permissions of new files, for example 0640 (default: derived from the config file)"`
+	DirMode          uint `option:"dir-mode" help:"set the permissions of new directories, for example 0750 (default: derived from the config file)"`
+	TempJournal      bool `option:"temp-journal" help:"record temporary files in a journal so they can be cleaned up after a crash"`
+
+	DurabilityWarnings bool `option:"durability-warnings" help:"emit events for saved files which may be lost if the server crashes because it does not support fsync"`
+
+	StoreChecksums bool `option:"store-checksums" help:"store the SHA-256 hash of each saved file in a separate file, so that VerifyChecksum can check it"`
+
+	MaintenanceLockMaxAge time.Duration `option:"maintenance-lock-max-age" help:"consider maintenance locks older than this stale (default: 24h)"`
+
+	VerifyListAfterSave bool `option:"verify-list-after-save" help:"after saving a file, wait until the server reports that it exists, for servers which show new files with a delay"`
+
+	VerifyUploads bool `option:"verify-uploads" help:"after saving a file, check that its size on the server matches the uploaded data"`
+
+	// TypePaths stores the files of the given types in separate directories
+	// instead of the ones from the layout. Relative directories are
+	// interpreted relative to the repository path.
+	TypePaths map[restic.FileType]string
+
+	// PathJoiner overrides how path components are combined, for servers
+	// which use nonstandard path conventions. If nil, path.Join is used.
+	PathJoiner func(parts ...string) string
+
+	// OnReconnect is called each time the connection to the server has been
+	// re-established, attempt counts the reconnects and err is the error
+	// which caused the reconnect.
+	OnReconnect func(attempt int, err error)
+
+	// ResultStream receives a newline-delimited JSON record (see Result) for
+	// each object processed by the bulk operations SaveMany, RemoveMany,
+	// CompactPrefixDirs and EmptyTrash.
+	ResultStream io.Writer
+
+	// EmitEvents enables sending events about connections, retries, slow
+	// operations and errors to the channel returned by Events. Operations
+	// which take longer than SlowOpThreshold are reported if it is positive.
+	EmitEvents      bool
+	SlowOpThreshold time.Duration
+
+	// Progress is called while Save and Load transfer the contents of the
+	// file for h, each time another 256 KiB have been transferred and once
+	// more at the end. total is -1 when loading a file without a length.
+	Progress func(h restic.Handle, done, total int64)
+
+	// Metrics is called after each Save and Load with the number of bytes
+	// transferred and the duration of the operation. For Save, these are the
+	// bytes written to the server, including those of failed attempts.
+	Metrics func(TransferMetric)
+
+	// TempNameFunc returns the suffix of the temporary file name used while
+	// saving the file for h, the default is a random string. The names must be
+	// unique, saving fails if the temporary file already exists.
+	TempNameFunc func(h restic.Handle) string
+
+	// WriteTransform and ReadTransform are applied to the contents of all
+	// files when saving and loading them, respectively. ReadTransform must
+	// reverse WriteTransform. If the writer returned by WriteTransform
+	// implements io.Closer, it is closed after all data has been written.
+	// Offsets passed to Load refer to the data returned by ReadTransform,
+	// while Stat and List report the size of the stored data.
+	WriteTransform func(io.Writer) io.Writer
+	ReadTransform  func(io.Reader) io.Reader
+
+	// CompressTypes lists the file types whose contents are compressed with
+	// gzip when saving them, which is useful for the JSON based index and
+	// snapshot files. Files of these types saved without compression can
+	// still be loaded. As for WriteTransform, Stat and List report the size
+	// of the stored, compressed data.
+	CompressTypes []restic.FileType
+
+	// LocalFallback serves the repository from the local file system by an
+	// sftp server running in the current process instead of connecting to a
+	// remote server. It is only meant to be used in tests.
+	LocalFallback bool
+
+	// clk replaces the real clock for timeouts, delays between retries and
+	// timestamps. It is only set in tests.
+	clk clock
 }
 
 // NewConfig returns a new config with default options applied.
@@ -30,6 +219,42 @@ func init() {
 	options.Register("sftp", Config{})
 }
 
+// redacted replaces the values of secret fields in RedactedConfig.
+const redacted = "**redacted**"
+
+// RedactedConfig returns a copy of cfg which can be logged. The values of all
+// fields tagged with `secret:"true"` are replaced, for maps only the values
+// are replaced and the keys are kept.
+func (cfg Config) RedactedConfig() Config {
+	v := reflect.ValueOf(&cfg).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if v.Type().Field(i).Tag.Get("secret") != "true" {
+			continue
+		}
+
+		f := v.Field(i)
+		switch {
+		case f.Kind() == reflect.String:
+			if f.Len() > 0 {
+				f.SetString(redacted)
+			}
+		case f.Kind() == reflect.Map && f.Type().Elem().Kind() == reflect.String:
+			if f.IsNil() {
+				continue
+			}
+			m := reflect.MakeMapWithSize(f.Type(), f.Len())
+			iter := f.MapRange()
+			for iter.Next() {
+				m.SetMapIndex(iter.Key(), reflect.ValueOf(redacted).Convert(f.Type().Elem()))
+			}
+			f.Set(m)
+		default:
+			f.Set(reflect.Zero(f.Type()))
+		}
+	}
+	return cfg
+}
+
 // ParseConfig parses the string s and extracts the sftp config. The
 // supported configuration formats are sftp://user@host[:port]/directory
 // and sftp:user@host:directory.  The directory will be path Cleaned and can
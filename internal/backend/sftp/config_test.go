@@ -1,6 +1,9 @@
 package sftp
 
 import (
+	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -84,7 +87,7 @@ func TestParseConfig(t *testing.T) {
 			continue
 		}
 
-		if cfg != test.cfg {
+		if !reflect.DeepEqual(cfg, test.cfg) {
 			t.Errorf("test %d:\ninput:\n  %s\n wrong config, want:\n  %v\ngot:\n  %v",
 				i, test.in, test.cfg, cfg)
 			continue
@@ -105,3 +108,42 @@ func TestParseConfigInvalid(t *testing.T) {
 		}
 	}
 }
+
+func TestRedactedConfig(t *testing.T) {
+	cfg := NewConfig()
+	cfg.User = "user"
+	cfg.Host = "host"
+	cfg.Port = "10022"
+	cfg.Path = "/dir"
+	cfg.IdentityFile = "/home/user/.ssh/id_ed25519"
+	cfg.Passphrase = "secret passphrase"
+	cfg.Env = map[string]string{"SSH_AUTH_SOCK": "secret socket"}
+
+	redactedCfg := cfg.RedactedConfig()
+
+	if redactedCfg.Passphrase != redacted {
+		t.Errorf("passphrase not redacted: %q", redactedCfg.Passphrase)
+	}
+	if !reflect.DeepEqual(redactedCfg.Env, map[string]string{"SSH_AUTH_SOCK": redacted}) {
+		t.Errorf("environment not redacted: %v", redactedCfg.Env)
+	}
+	if s := fmt.Sprintf("%#v", redactedCfg); strings.Contains(s, "secret") {
+		t.Errorf("formatted config contains a secret: %v", s)
+	}
+
+	// the other fields and the original config are unchanged
+	redactedCfg.Passphrase = cfg.Passphrase
+	redactedCfg.Env = cfg.Env
+	if !reflect.DeepEqual(cfg, redactedCfg) {
+		t.Errorf("wrong redacted config, want %#v, got %#v", cfg, redactedCfg)
+	}
+	if cfg.Env["SSH_AUTH_SOCK"] != "secret socket" {
+		t.Errorf("original environment modified: %v", cfg.Env)
+	}
+
+	// empty secrets stay empty
+	redactedCfg = NewConfig().RedactedConfig()
+	if redactedCfg.Passphrase != "" || redactedCfg.Env != nil {
+		t.Errorf("empty secrets were replaced: %#v", redactedCfg)
+	}
+}
@@ -0,0 +1,60 @@
+package sftp
+
+import (
+	"sync"
+
+	"github.com/restic/restic/internal/debug"
+)
+
+// connLimiter limits the number of ssh processes running at the same time
+// for all backends in this process.
+type connLimiter struct {
+	m     sync.Mutex
+	cond  *sync.Cond
+	limit uint
+	used  uint
+}
+
+var globalConnLimiter = newConnLimiter()
+
+func newConnLimiter() *connLimiter {
+	l := &connLimiter{}
+	l.cond = sync.NewCond(&l.m)
+	return l
+}
+
+// SetGlobalConnectionLimit sets the maximum number of connections to sftp
+// servers for all backends in this process. New connections wait until an
+// existing one is closed. A limit of zero removes the limit.
+func SetGlobalConnectionLimit(n uint) {
+	globalConnLimiter.setLimit(n)
+}
+
+func (l *connLimiter) setLimit(n uint) {
+	l.m.Lock()
+	l.limit = n
+	l.m.Unlock()
+	l.cond.Broadcast()
+}
+
+// acquire waits until a connection may be started and returns a function
+// which must be called once the connection is closed.
+func (l *connLimiter) acquire() (release func()) {
+	l.m.Lock()
+	for l.limit > 0 && l.used >= l.limit {
+		debug.Log("waiting for one of %d connections to be closed", l.used)
+		l.cond.Wait()
+	}
+	l.used++
+	l.m.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.m.Lock()
+			l.used--
+			l.m.Unlock()
+			l.cond.Signal()
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package sftp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestGlobalConnectionLimit(t *testing.T) {
+	SetGlobalConnectionLimit(2)
+	defer SetGlobalConnectionLimit(0)
+
+	cfg := newTestConfig(t)
+	var backends []*SFTP
+	for i := 0; i < 2; i++ {
+		be, err := Create(context.TODO(), cfg)
+		rtest.OK(t, err)
+		backends = append(backends, be)
+	}
+
+	type result struct {
+		be  *SFTP
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		be, err := Open(context.TODO(), cfg)
+		ch <- result{be, err}
+	}()
+
+	select {
+	case <-ch:
+		t.Fatal("third connection was not blocked")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	rtest.OK(t, backends[0].Close())
+
+	select {
+	case res := <-ch:
+		rtest.OK(t, res.err)
+		rtest.OK(t, res.be.Close())
+	case <-time.After(5 * time.Second):
+		t.Fatal("third connection still blocked after closing another one")
+	}
+
+	rtest.OK(t, backends[1].Close())
+}
+
+func TestConnLimiterSetLimit(t *testing.T) {
+	l := newConnLimiter()
+	l.setLimit(1)
+
+	release := l.acquire()
+	done := make(chan struct{})
+	go func() {
+		l.acquire()()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("second acquire was not blocked")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// raising the limit unblocks waiting connections
+	l.setLimit(2)
+	<-done
+
+	// releasing twice has no effect
+	release()
+	release()
+	rtest.Equals(t, uint(0), l.used)
+}
@@ -0,0 +1,37 @@
+package sftp
+
+import (
+	"context"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestDelete(t *testing.T) {
+	be := newTestBackend(t, newTestConfig(t))
+
+	saveDataFiles(t, be, 5)
+	saveFile(t, be, restic.Handle{Type: restic.ConfigFile}, []byte("config"))
+
+	// a partially created repository
+	basedir, _ := be.Basedir(restic.IndexFile)
+	rtest.OK(t, be.client().RemoveDirectory(basedir))
+
+	rtest.OK(t, be.Delete(context.TODO()))
+	rtest.Equals(t, []string(nil), dirEntries(t, be, be.Location()))
+
+	// deleting again is not an error
+	rtest.OK(t, be.Delete(context.TODO()))
+}
+
+func TestDeleteInvalidPath(t *testing.T) {
+	be := newTestBackend(t, newTestConfig(t))
+
+	for _, p := range []string{"", "/", "."} {
+		other := *be
+		other.p = p
+		err := other.Delete(context.TODO())
+		rtest.Assert(t, err != nil, "Delete accepted path %q", p)
+	}
+}
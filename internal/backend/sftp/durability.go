@@ -0,0 +1,24 @@
+package sftp
+
+import (
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/restic"
+)
+
+// warnNotDurable records that the file for h was saved on a server which does
+// not support the fsync extension. Then neither the file nor its directory
+// can be flushed to stable storage, so the file may be lost if the server
+// crashes, even if it was saved by SaveVerified. If
+// Config.DurabilityWarnings is set, an EventNotDurable event is emitted for
+// the file. Nothing is recorded if the server supports fsync.
+func (r *SFTP) warnNotDurable(h restic.Handle) {
+	if !r.Config.DurabilityWarnings {
+		return
+	}
+	if _, ok := r.client().HasExtension("fsync@openssh.com"); ok {
+		return
+	}
+
+	debug.Log("%v saved on a server without fsync support%v", h, r.tagInfo())
+	r.emit(BackendEvent{Type: EventNotDurable, Operation: "save", Object: objectName(h)})
+}
@@ -0,0 +1,48 @@
+package sftp
+
+import (
+	"context"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestDurabilityWarnings(t *testing.T) {
+	data := []byte("foobar")
+	id := restic.Hash(data)
+	h := restic.Handle{Type: restic.PackFile, Name: id.String()}
+
+	for _, test := range []struct {
+		name     string
+		enabled  bool
+		verified bool
+	}{
+		{"disabled", false, false},
+		{"save", true, false},
+		{"save-verified", true, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			cfg.EmitEvents = true
+			cfg.DurabilityWarnings = test.enabled
+			be, err := Create(context.TODO(), cfg)
+			rtest.OK(t, err)
+
+			if test.verified {
+				rtest.OK(t, be.SaveVerified(context.TODO(), h, restic.NewByteReader(data, nil), id))
+			} else {
+				saveFile(t, be, h, data)
+			}
+
+			// the warning is only emitted if the server cannot sync files,
+			// for both Save and SaveVerified
+			_, canSync := be.client().HasExtension("fsync@openssh.com")
+			want := []EventType{EventConnect}
+			if test.enabled && !canSync {
+				want = append(want, EventNotDurable)
+			}
+			rtest.Equals(t, want, collectEvents(t, be))
+		})
+	}
+}
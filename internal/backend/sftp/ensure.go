@@ -0,0 +1,174 @@
+package sftp
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// initLockName is the name of the marker file in the repository directory
+// which exists while a process created by EnsureInitialized has not saved the
+// config file yet.
+const initLockName = "init.lock"
+
+// initLockMaxAge is the age after which the marker file of a process which
+// neither saved the config file nor closed the backend is considered stale.
+const initLockMaxAge = time.Hour
+
+// initLockPollInterval is the interval at which EnsureInitialized checks
+// whether another process has finished creating the repository.
+const initLockPollInterval = 100 * time.Millisecond
+
+// errInitInProgress is used internally while another process creates the
+// repository.
+var errInitInProgress = errors.New("repository is being created by another process")
+
+// initLock is the marker file held by the backend which creates the
+// repository in EnsureInitialized. It is shared with the copies returned by
+// WithTag.
+type initLock struct {
+	m        sync.Mutex
+	filename string
+}
+
+// EnsureInitialized opens the repository described by cfg and creates it
+// first if it does not exist yet. The repository exists once its config file
+// has been saved. The returned bool reports whether the repository was
+// created, the caller must then save the config file. With ReadOnly, a
+// repository which does not exist yet is not created.
+//
+// When several processes run EnsureInitialized for the same repository
+// concurrently, exactly one of them creates it. The others wait until the
+// config file has been saved, or until the creating process has closed the
+// backend without saving it, in which case one of them creates the
+// repository instead. A process which neither saved the config file nor
+// closed the backend within an hour is assumed to have crashed.
+func EnsureInitialized(ctx context.Context, cfg Config) (_ *SFTP, created bool, err error) {
+	debug.Log("ensure initialized backend with config %#v", cfg.RedactedConfig())
+
+	sftp, err := startClient(cfg)
+	if err != nil {
+		debug.Log("unable to start program: %v", err)
+		return nil, false, err
+	}
+	sftp.events = newEventStream(cfg)
+
+	lock, err := sftp.claimCreation(ctx, cfg)
+	if err != nil {
+		_ = sftp.Close()
+		return nil, false, err
+	}
+
+	if lock == "" {
+		be, err := openClient(ctx, sftp, cfg)
+		return be, false, err
+	}
+
+	// the marker file is removed when the config file has been saved or the
+	// backend is closed
+	sftp.creating = &initLock{filename: lock}
+
+	debug.Log("creating repository at %v", cfg.Path)
+	be, err := createClient(ctx, sftp, cfg)
+	return be, err == nil, err
+}
+
+// claimCreation returns the name of the marker file it created if the
+// repository does not exist and the caller is responsible for creating it,
+// or "" if the repository exists. While another process creates the
+// repository, it waits until that process has finished.
+func (r *SFTP) claimCreation(ctx context.Context, cfg Config) (string, error) {
+	p, err := r.canonicalPath(cfg)
+	if err != nil {
+		return "", err
+	}
+	cfg.Path = p
+	// the server time is determined using a file in the repository directory
+	r.setPath(p)
+
+	l, err := r.parseLayout(ctx, cfg)
+	if err != nil {
+		return "", err
+	}
+	configFile := l.Filename(restic.Handle{Type: restic.ConfigFile})
+	lockFile := r.Join(p, initLockName)
+
+	for {
+		exists, err := r.exists(configFile)
+		if err != nil {
+			return "", errors.Wrap(err, "Lstat")
+		}
+		if exists {
+			return "", nil
+		}
+		if cfg.ReadOnly {
+			return "", fmt.Errorf("%w: cannot create a repository", ErrReadOnly)
+		}
+
+		err = r.client().MkdirAll(p)
+		if err != nil {
+			return "", errors.Wrap(err, "MkdirAll")
+		}
+
+		err = r.createLockFile(lockFile, tempSuffix())
+		if err == nil {
+			// the config file may have been saved by a process which
+			// released the marker file after it was checked above
+			exists, err = r.exists(configFile)
+			if err != nil || exists {
+				r.removeInitLock(lockFile)
+				return "", errors.Wrap(err, "Lstat")
+			}
+			return lockFile, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return "", err
+		}
+
+		err = r.takeOverStaleFile(lockFile, initLockMaxAge, errInitInProgress)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, errInitInProgress) {
+			return "", err
+		}
+
+		debug.Log("repository at %v is being created by another process, waiting", p)
+		select {
+		case <-clockFor(cfg).After(initLockPollInterval):
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
+
+// releaseInitLock removes the marker file if this backend is still creating
+// the repository.
+func (r *SFTP) releaseInitLock() {
+	if r.creating == nil {
+		return
+	}
+
+	r.creating.m.Lock()
+	defer r.creating.m.Unlock()
+	if r.creating.filename == "" {
+		return
+	}
+
+	r.removeInitLock(r.creating.filename)
+	r.creating.filename = ""
+}
+
+// removeInitLock removes the marker file filename. Errors are only logged,
+// a stale marker file is taken over eventually.
+func (r *SFTP) removeInitLock(filename string) {
+	if err := r.client().Remove(filename); err != nil {
+		debug.Log("unable to remove %v: %v", filename, err)
+	}
+}
@@ -0,0 +1,131 @@
+package sftp
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestEnsureInitialized(t *testing.T) {
+	cfg := newTestConfig(t)
+
+	be, created, err := EnsureInitialized(context.TODO(), cfg)
+	rtest.OK(t, err)
+	rtest.Assert(t, created, "repository was not created")
+
+	data := []byte("config")
+	h := restic.Handle{Type: restic.ConfigFile}
+	saveFile(t, be, h, data)
+	rtest.OK(t, be.Close())
+
+	for i := 0; i < 2; i++ {
+		be, created, err = EnsureInitialized(context.TODO(), cfg)
+		rtest.OK(t, err)
+		rtest.Assert(t, !created, "existing repository was created again")
+
+		_, err = be.Stat(context.TODO(), h)
+		rtest.OK(t, err)
+		rtest.OK(t, be.Close())
+	}
+
+	// a repository created by Create is not created again
+	cfg = newTestConfig(t)
+	be, err = Create(context.TODO(), cfg)
+	rtest.OK(t, err)
+	saveFile(t, be, h, data)
+	rtest.OK(t, be.Close())
+
+	be, created, err = EnsureInitialized(context.TODO(), cfg)
+	rtest.OK(t, err)
+	rtest.Assert(t, !created, "existing repository was created again")
+	rtest.OK(t, be.Close())
+}
+
+func TestEnsureInitializedConcurrent(t *testing.T) {
+	cfg := newTestConfig(t)
+
+	const n = 5
+	var wg sync.WaitGroup
+	results := make([]bool, n)
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var be *SFTP
+			be, results[i], errs[i] = EnsureInitialized(context.TODO(), cfg)
+			if errs[i] != nil {
+				return
+			}
+			h := restic.Handle{Type: restic.ConfigFile}
+			if results[i] {
+				errs[i] = be.Save(context.TODO(), h, restic.NewByteReader([]byte("config"), nil))
+			} else {
+				// the others only return once the config file exists
+				_, errs[i] = be.Stat(context.TODO(), h)
+			}
+			if errs[i] == nil {
+				errs[i] = be.Close()
+			}
+		}()
+	}
+	wg.Wait()
+
+	creators := 0
+	for i := 0; i < n; i++ {
+		rtest.OK(t, errs[i])
+		if results[i] {
+			creators++
+		}
+	}
+	rtest.Equals(t, 1, creators)
+
+	// the repository is complete
+	be, err := Open(context.TODO(), cfg)
+	rtest.OK(t, err)
+	data := []byte("foo")
+	saveFile(t, be, restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}, data)
+	rtest.OK(t, be.Close())
+}
+
+func TestEnsureInitializedAbandoned(t *testing.T) {
+	cfg := newTestConfig(t)
+
+	// the config file is never saved
+	be, created, err := EnsureInitialized(context.TODO(), cfg)
+	rtest.OK(t, err)
+	rtest.Assert(t, created, "repository was not created")
+	rtest.OK(t, be.Close())
+
+	be, created, err = EnsureInitialized(context.TODO(), cfg)
+	rtest.OK(t, err)
+	rtest.Assert(t, created, "incomplete repository was not created again")
+	rtest.OK(t, be.Close())
+}
+
+func TestEnsureInitializedStaleLock(t *testing.T) {
+	cfg := newTestConfig(t)
+
+	// a process crashed while creating the repository
+	rtest.OK(t, os.MkdirAll(cfg.Path, 0700))
+	lock := filepath.Join(cfg.Path, initLockName)
+	rtest.OK(t, os.WriteFile(lock, []byte("token"), 0600))
+	old := time.Now().Add(-2 * initLockMaxAge)
+	rtest.OK(t, os.Chtimes(lock, old, old))
+
+	be, created, err := EnsureInitialized(context.TODO(), cfg)
+	rtest.OK(t, err)
+	rtest.Assert(t, created, "repository with a stale lock was not created")
+
+	saveFile(t, be, restic.Handle{Type: restic.ConfigFile}, []byte("config"))
+	_, err = os.Stat(lock)
+	rtest.Assert(t, os.IsNotExist(err), "lock still exists after saving the config: %v", err)
+	rtest.OK(t, be.Close())
+}
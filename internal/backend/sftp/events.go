@@ -0,0 +1,125 @@
+package sftp
+
+import (
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+)
+
+// EventType describes the kind of a BackendEvent.
+type EventType string
+
+// Types of events emitted by the backend.
+const (
+	// EventConnect is emitted when the backend has been opened.
+	EventConnect EventType = "connect"
+	// EventReconnect is emitted when the connection has been re-established.
+	EventReconnect EventType = "reconnect"
+	// EventRetry is emitted when an operation is retried.
+	EventRetry EventType = "retry"
+	// EventSlowOp is emitted when an operation took longer than
+	// Config.SlowOpThreshold.
+	EventSlowOp EventType = "slow-op"
+	// EventError is emitted when the connection to the server failed.
+	EventError EventType = "error"
+	// EventNotDurable is emitted when a file has been saved on a server
+	// which does not support syncing files and directories, if
+	// Config.DurabilityWarnings is set.
+	EventNotDurable EventType = "not-durable"
+)
+
+// BackendEvent describes something that happened in the backend. Depending on
+// the type, only some of the fields are set.
+type BackendEvent struct {
+	Type      EventType
+	Time      time.Time
+	Operation string
+	Object    string
+	Duration  time.Duration
+	Attempt   int
+	Err       error
+	// Tag is the tag of the backend which emitted the event, see WithTag.
+	Tag string
+}
+
+// eventBufferSize is the number of events buffered for the consumer. When the
+// buffer is full, new events are dropped.
+const eventBufferSize = 64
+
+// eventStream delivers events to the consumer without blocking the backend.
+type eventStream struct {
+	m       sync.Mutex
+	ch      chan BackendEvent
+	closed  bool
+	dropped int
+}
+
+func newEventStream(cfg Config) *eventStream {
+	if !cfg.EmitEvents {
+		return nil
+	}
+	return &eventStream{ch: make(chan BackendEvent, eventBufferSize)}
+}
+
+// Events returns the channel which receives the events of the backend if
+// Config.EmitEvents is set, otherwise nil is returned. Events are dropped
+// when the consumer does not keep up. The channel is closed by Close.
+func (r *SFTP) Events() <-chan BackendEvent {
+	if r.events == nil {
+		return nil
+	}
+	return r.events.ch
+}
+
+// emit sends ev to the event channel, if enabled.
+func (r *SFTP) emit(ev BackendEvent) {
+	if r.events == nil {
+		return
+	}
+
+	ev.Time = clockFor(r.Config).Now()
+	ev.Tag = r.tag
+
+	r.events.m.Lock()
+	defer r.events.m.Unlock()
+
+	if r.events.closed {
+		return
+	}
+
+	select {
+	case r.events.ch <- ev:
+	default:
+		r.events.dropped++
+		debug.Log("event buffer full, dropped %v event (%d dropped in total)", ev.Type, r.events.dropped)
+	}
+}
+
+// closeEvents closes the event channel, if enabled.
+func (r *SFTP) closeEvents() {
+	if r.events == nil {
+		return
+	}
+
+	r.events.m.Lock()
+	defer r.events.m.Unlock()
+
+	if !r.events.closed {
+		r.events.closed = true
+		close(r.events.ch)
+	}
+}
+
+// trackOp emits a slow-op event if the operation which started at start took
+// longer than Config.SlowOpThreshold. It is meant to be deferred.
+func (r *SFTP) trackOp(operation, object string, start time.Time) {
+	if r.events == nil || r.Config.SlowOpThreshold <= 0 {
+		return
+	}
+
+	d := clockFor(r.Config).Now().Sub(start)
+	if d > r.Config.SlowOpThreshold {
+		r.emit(BackendEvent{Type: EventSlowOp, Operation: operation, Object: object, Duration: d})
+	}
+}
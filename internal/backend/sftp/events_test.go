@@ -0,0 +1,89 @@
+package sftp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// collectEvents closes the backend and returns the types of all events which
+// have been emitted.
+func collectEvents(t testing.TB, be *SFTP) []EventType {
+	// ignore the error as the server may have been killed
+	_ = be.Close()
+
+	var types []EventType
+	for ev := range be.Events() {
+		rtest.Assert(t, !ev.Time.IsZero(), "event %v has no time", ev.Type)
+		types = append(types, ev.Type)
+	}
+	return types
+}
+
+func TestEventsReconnectRetry(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.Reconnect = true
+	cfg.EmitEvents = true
+	be, err := Create(context.TODO(), cfg)
+	rtest.OK(t, err)
+
+	saveDataFiles(t, be, 20)
+
+	listed := 0
+	err = be.List(context.TODO(), restic.PackFile, func(fi restic.FileInfo) error {
+		listed++
+		if listed == 5 {
+			killServer(t, be)
+		}
+		return nil
+	})
+	rtest.OK(t, err)
+	rtest.Equals(t, 20, listed)
+
+	rtest.Equals(t, []EventType{EventConnect, EventError, EventReconnect, EventRetry}, collectEvents(t, be))
+}
+
+func TestEventsSlowOp(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.EmitEvents = true
+	cfg.SlowOpThreshold = time.Nanosecond
+	be, err := Create(context.TODO(), cfg)
+	rtest.OK(t, err)
+
+	h := restic.Handle{Type: restic.PackFile, Name: restic.NewRandomID().String()}
+	_, _ = be.Stat(context.TODO(), h)
+
+	ev := <-be.Events()
+	rtest.Equals(t, EventConnect, ev.Type)
+	ev = <-be.Events()
+	rtest.Equals(t, EventSlowOp, ev.Type)
+	rtest.Equals(t, "stat", ev.Operation)
+	rtest.Equals(t, objectName(h), ev.Object)
+	rtest.Assert(t, ev.Duration > 0, "slow-op event without duration")
+
+	rtest.OK(t, be.Close())
+}
+
+func TestEventsSlowConsumer(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.EmitEvents = true
+	cfg.SlowOpThreshold = time.Nanosecond
+	be, err := Create(context.TODO(), cfg)
+	rtest.OK(t, err)
+
+	// nobody reads the events, operations must not block
+	h := restic.Handle{Type: restic.PackFile, Name: restic.NewRandomID().String()}
+	for i := 0; i < 2*eventBufferSize; i++ {
+		_, _ = be.Stat(context.TODO(), h)
+	}
+
+	rtest.Equals(t, eventBufferSize, len(collectEvents(t, be)))
+}
+
+func TestEventsDisabled(t *testing.T) {
+	be := newTestBackend(t, newTestConfig(t))
+	rtest.Assert(t, be.Events() == nil, "events enabled by default")
+}
@@ -0,0 +1,62 @@
+package sftp
+
+import (
+	"context"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+// ErrExecNotAllowed is returned by Exec if Config.AllowExec is not set.
+var ErrExecNotAllowed = errors.New("running remote commands is not allowed, set allow-exec to enable it")
+
+// Exec runs cmd on the server in a new session on the existing SSH connection
+// and returns its combined stdout and stderr. A command which exits with a
+// non-zero status returns its output together with an error. Exec requires
+// Config.AllowExec and a connection established with Config.IdentityFile, as
+// the connection of the ssh program cannot be shared. If ctx is cancelled,
+// the session is closed and the command is left to the server to terminate.
+func (r *SFTP) Exec(ctx context.Context, cmd string) ([]byte, error) {
+	debug.Log("Exec %q%v", cmd, r.tagInfo())
+	if !r.Config.AllowExec {
+		return nil, backoff.Permanent(ErrExecNotAllowed)
+	}
+
+	if err := r.clientError(ctx); err != nil {
+		return nil, err
+	}
+
+	r.conn.m.RLock()
+	sshClient := r.conn.ssh
+	r.conn.m.RUnlock()
+	if sshClient == nil {
+		return nil, backoff.Permanent(errors.New("running remote commands requires a connection established with an identity file"))
+	}
+
+	r.sem.GetToken()
+	defer r.sem.ReleaseToken()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	session, err := sshClient.NewSession()
+	if err != nil {
+		return nil, errors.Wrap(err, "NewSession")
+	}
+
+	stop := closeOnCancel(ctx, session)
+	out, err := session.CombinedOutput(cmd)
+	if stop() {
+		return out, ctx.Err()
+	}
+	// the session has already been closed by the server
+	_ = session.Close()
+	if err != nil {
+		return out, errors.Wrapf(err, "Exec %q", cmd)
+	}
+
+	return out, nil
+}
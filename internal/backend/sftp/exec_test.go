@@ -0,0 +1,54 @@
+package sftp
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestExec(t *testing.T) {
+	srv := newTestSSHServer(t)
+	cfg := nativeTestConfig(t, srv)
+	cfg.IdentityFile = writeIdentity(t, t.TempDir(), "")
+	cfg.AllowExec = true
+	be := newTestBackend(t, cfg)
+
+	out, err := be.Exec(context.TODO(), "echo hello")
+	rtest.OK(t, err)
+	rtest.Equals(t, "hello\n", string(out))
+
+	// the output is returned together with the exit status
+	out, err = be.Exec(context.TODO(), "echo failed >&2; exit 3")
+	var exitErr *ssh.ExitError
+	rtest.Assert(t, errors.As(err, &exitErr), "expected ExitError, got %v", err)
+	rtest.Equals(t, 3, exitErr.ExitStatus())
+	rtest.Equals(t, "failed\n", string(out))
+
+	// the sftp session is still usable
+	data := []byte("foobar")
+	saveFile(t, be, restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}, data)
+}
+
+func TestExecNotAllowed(t *testing.T) {
+	srv := newTestSSHServer(t)
+	cfg := nativeTestConfig(t, srv)
+	cfg.IdentityFile = writeIdentity(t, t.TempDir(), "")
+	be := newTestBackend(t, cfg)
+
+	_, err := be.Exec(context.TODO(), "echo hello")
+	rtest.Assert(t, errors.Is(err, ErrExecNotAllowed), "expected ErrExecNotAllowed, got %v", err)
+}
+
+func TestExecWithoutNativeSSH(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.AllowExec = true
+	be := newTestBackend(t, cfg)
+
+	_, err := be.Exec(context.TODO(), "echo hello")
+	rtest.Assert(t, err != nil, "Exec succeeded without a native SSH connection")
+}
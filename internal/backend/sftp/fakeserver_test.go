@@ -0,0 +1,273 @@
+package sftp
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/pkg/sftp"
+)
+
+// fakeServerEnv is set to the name of a fault when the test binary is started
+// as an in-memory sftp server which injects this fault.
+const fakeServerEnv = "SFTP_TEST_FAKE_SERVER"
+
+// Faults injected by the fake server.
+const (
+	// faultCorruptConfig corrupts config files when they are read.
+	faultCorruptConfig = "corrupt-config"
+	// faultCrossDevice rejects renaming files between different top-level
+	// directories of the repository.
+	faultCrossDevice = "cross-device"
+	// faultHang never answers requests to stat data files or read data
+	// from them.
+	faultHang = "hang"
+	// faultTruncate silently drops the last byte written to data files.
+	faultTruncate = "truncate"
+	// faultDelayVisible reports renamed files as missing for the first few
+	// times they are looked up.
+	faultDelayVisible = "delay-visible"
+	// faultRemoveDir removes the subdirectories of the data directory right
+	// after they have been created the first few times, like a concurrent
+	// Remove with RemoveEmptyDirs.
+	faultRemoveDir = "remove-dir"
+	// faultStartDir resolves relative paths in fakeStartDir.
+	faultStartDir = "start-dir"
+	// faultHiddenRepo serves an existing repository directory, which is
+	// reported as missing for the first few times it is looked up.
+	faultHiddenRepo = "hidden-repo"
+)
+
+// fakeStartDir is the start directory of the server for faultStartDir.
+const fakeStartDir = "/home/restic"
+
+// fakeServerConfig returns a config for a repository on an in-memory server
+// which injects fault. The server does not support the fsync extension.
+func fakeServerConfig(fault string) Config {
+	cfg := NewConfig()
+	cfg.Path = "/repo"
+	cfg.Command = fmt.Sprintf("%q -test.run=^TestFakeServerProcess$", os.Args[0])
+	cfg.Env = map[string]string{fakeServerEnv: fault}
+	cfg.InheritEnv = true
+	return cfg
+}
+
+type corruptingReader struct {
+	sftp.FileReader
+}
+
+func (c corruptingReader) Fileread(req *sftp.Request) (io.ReaderAt, error) {
+	rd, err := c.FileReader.Fileread(req)
+	if err != nil || !strings.HasPrefix(path.Base(req.Filepath), "config") {
+		return rd, err
+	}
+	return flipReaderAt{rd}, nil
+}
+
+// flipReaderAt inverts the first byte of the file.
+type flipReaderAt struct {
+	io.ReaderAt
+}
+
+func (f flipReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := f.ReaderAt.ReadAt(p, off)
+	if off == 0 && n > 0 {
+		p[0] ^= 0xff
+	}
+	return n, err
+}
+
+// crossDeviceCmder treats each top-level directory below /repo as a separate
+// file system.
+type crossDeviceCmder struct {
+	sftp.FileCmder
+}
+
+func device(p string) string {
+	p = strings.TrimPrefix(p, "/repo/")
+	dev, _, _ := strings.Cut(p, "/")
+	return dev
+}
+
+func (c crossDeviceCmder) Filecmd(req *sftp.Request) error {
+	if req.Method == "Rename" && device(req.Filepath) != device(req.Target) {
+		return &os.LinkError{Op: "rename", Old: req.Filepath, New: req.Target, Err: syscall.EXDEV}
+	}
+	return c.FileCmder.Filecmd(req)
+}
+
+// hangs returns true if requests for p are never answered, which is the
+// case for data files, but not for their directories and temporary files.
+func hangs(p string) bool {
+	return strings.HasPrefix(p, "/repo/data/") && len(path.Base(p)) == 64
+}
+
+type hangingReader struct {
+	sftp.FileReader
+}
+
+func (h hangingReader) Fileread(req *sftp.Request) (io.ReaderAt, error) {
+	rd, err := h.FileReader.Fileread(req)
+	if err != nil || !hangs(req.Filepath) {
+		return rd, err
+	}
+	return hangingReaderAt{}, nil
+}
+
+type hangingReaderAt struct{}
+
+func (hangingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	select {}
+}
+
+type hangingLister struct {
+	sftp.FileLister
+}
+
+func (h hangingLister) Filelist(req *sftp.Request) (sftp.ListerAt, error) {
+	if req.Method != "List" && hangs(req.Filepath) {
+		select {}
+	}
+	return h.FileLister.Filelist(req)
+}
+
+type truncatingWriter struct {
+	sftp.FileWriter
+}
+
+func (w truncatingWriter) Filewrite(req *sftp.Request) (io.WriterAt, error) {
+	wr, err := w.FileWriter.Filewrite(req)
+	if err != nil || !strings.HasPrefix(req.Filepath, "/repo/data/") {
+		return wr, err
+	}
+	return truncatingWriterAt{wr}, nil
+}
+
+// truncatingWriterAt drops the last byte of each write, but reports success.
+type truncatingWriterAt struct {
+	io.WriterAt
+}
+
+func (w truncatingWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	_, err := w.WriterAt.WriteAt(p[:len(p)-1], off)
+	return len(p), err
+}
+
+// hiddenLookups is the number of times a renamed file is reported as missing.
+const hiddenLookups = 3
+
+// delayedVisibility tracks the files which are not visible yet.
+type delayedVisibility struct {
+	mu     sync.Mutex
+	hidden map[string]int
+}
+
+type delayingCmder struct {
+	sftp.FileCmder
+	v *delayedVisibility
+}
+
+func (c delayingCmder) Filecmd(req *sftp.Request) error {
+	err := c.FileCmder.Filecmd(req)
+	if err == nil && (req.Method == "Rename" || req.Method == "PosixRename") {
+		c.v.mu.Lock()
+		c.v.hidden[req.Target] = hiddenLookups
+		c.v.mu.Unlock()
+	}
+	return err
+}
+
+type delayingLister struct {
+	sftp.FileLister
+	v *delayedVisibility
+}
+
+func (l delayingLister) Filelist(req *sftp.Request) (sftp.ListerAt, error) {
+	if req.Method != "List" {
+		l.v.mu.Lock()
+		n := l.v.hidden[req.Filepath]
+		if n > 0 {
+			l.v.hidden[req.Filepath] = n - 1
+		}
+		l.v.mu.Unlock()
+		if n > 0 {
+			return nil, os.ErrNotExist
+		}
+	}
+	return l.FileLister.Filelist(req)
+}
+
+// removedMkdirs is the number of times a created directory is removed again.
+const removedMkdirs = 2
+
+type removingCmder struct {
+	sftp.FileCmder
+	mu      sync.Mutex
+	created map[string]int
+}
+
+func (c *removingCmder) Filecmd(req *sftp.Request) error {
+	err := c.FileCmder.Filecmd(req)
+	if err != nil || req.Method != "Mkdir" || path.Dir(req.Filepath) != "/repo/data" {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.created[req.Filepath]++
+	if c.created[req.Filepath] <= removedMkdirs {
+		return c.FileCmder.Filecmd(sftp.NewRequest("Rmdir", req.Filepath))
+	}
+	return nil
+}
+
+type stdio struct{}
+
+func (stdio) Read(p []byte) (int, error)  { return os.Stdin.Read(p) }
+func (stdio) Write(p []byte) (int, error) { return os.Stdout.Write(p) }
+func (stdio) Close() error                { return os.Stdin.Close() }
+
+func TestFakeServerProcess(t *testing.T) {
+	fault := os.Getenv(fakeServerEnv)
+	if fault == "" {
+		t.Skip("only run as a helper process")
+	}
+
+	handlers := sftp.InMemHandler()
+	var options []sftp.RequestServerOption
+	switch fault {
+	case faultCorruptConfig:
+		handlers.FileGet = corruptingReader{handlers.FileGet}
+	case faultCrossDevice:
+		handlers.FileCmd = crossDeviceCmder{handlers.FileCmd}
+	case faultHang:
+		handlers.FileGet = hangingReader{handlers.FileGet}
+		handlers.FileList = hangingLister{handlers.FileList}
+	case faultTruncate:
+		handlers.FilePut = truncatingWriter{handlers.FilePut}
+	case faultDelayVisible:
+		v := &delayedVisibility{hidden: make(map[string]int)}
+		handlers.FileCmd = delayingCmder{handlers.FileCmd, v}
+		handlers.FileList = delayingLister{handlers.FileList, v}
+	case faultRemoveDir:
+		handlers.FileCmd = &removingCmder{FileCmder: handlers.FileCmd, created: make(map[string]int)}
+	case faultStartDir:
+		options = append(options, sftp.WithStartDirectory(fakeStartDir))
+	case faultHiddenRepo:
+		if err := handlers.FileCmd.Filecmd(sftp.NewRequest("Mkdir", "/repo")); err != nil {
+			t.Fatal(err)
+		}
+		v := &delayedVisibility{hidden: map[string]int{"/repo": hiddenLookups}}
+		handlers.FileList = delayingLister{handlers.FileList, v}
+	}
+	_ = sftp.NewRequestServer(stdio{}, handlers, options...).Serve()
+	os.Exit(0)
+}
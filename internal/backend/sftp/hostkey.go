@@ -0,0 +1,65 @@
+package sftp
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"runtime"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// hostKeyFingerprintRe matches SHA256 fingerprints as printed by ssh.
+var hostKeyFingerprintRe = regexp.MustCompile(`^SHA256:[A-Za-z0-9+/]{43}$`)
+
+func validHostKeyFingerprint(fingerprint string) error {
+	if !hostKeyFingerprintRe.MatchString(fingerprint) {
+		return errors.Errorf("invalid host key fingerprint %q, expected the format SHA256:<base64> as printed by ssh-keygen -l", fingerprint)
+	}
+	return nil
+}
+
+// writeHostKeyScript writes a script for the KnownHostsCommand option of ssh
+// to a temporary file. It prints a known_hosts line for the key of the host
+// only if the key has the expected fingerprint, so that ssh rejects all other
+// keys. The caller must remove the file.
+func writeHostKeyScript(fingerprint string) (string, error) {
+	if runtime.GOOS == "windows" {
+		return "", errors.New("checking the host key fingerprint is not supported on Windows")
+	}
+
+	if err := validHostKeyFingerprint(fingerprint); err != nil {
+		return "", err
+	}
+
+	f, err := os.CreateTemp("", "restic-known-hosts-*.sh")
+	if err != nil {
+		return "", errors.Wrap(err, "CreateTemp")
+	}
+
+	script := fmt.Sprintf("#!/bin/sh\n# called by ssh with the arguments %%f %%H %%t %%K\nif [ \"$1\" = %q ]; then\n\techo \"$2 $3 $4\"\nfi\n", fingerprint)
+	_, err = f.WriteString(script)
+	if err == nil {
+		err = f.Close()
+	} else {
+		_ = f.Close()
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return "", errors.Wrap(err, "Write")
+	}
+
+	return f.Name(), nil
+}
+
+// hostKeyArgs returns the arguments for ssh to only accept the host key
+// which the script at filename accepts.
+func hostKeyArgs(filename string) []string {
+	return []string{
+		"-o", "StrictHostKeyChecking=yes",
+		"-o", "CheckHostIP=yes",
+		"-o", "UserKnownHostsFile=/dev/null",
+		"-o", "GlobalKnownHostsFile=/dev/null",
+		"-o", "KnownHostsCommand=/bin/sh " + filename + " %f %H %t %K",
+	}
+}
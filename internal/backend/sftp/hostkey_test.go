@@ -0,0 +1,62 @@
+package sftp
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestHostKeyFingerprint(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("not supported on Windows")
+	}
+
+	srv := newTestSSHServer(t)
+
+	cfg := srv.sshConfig(t)
+	cfg.HostKeyFingerprint = srv.Fingerprint
+	be, err := Create(context.TODO(), cfg)
+	rtest.OK(t, err)
+
+	// the script is removed when the connection is closed
+	scripts, err := filepath.Glob(filepath.Join(os.TempDir(), "restic-known-hosts-*.sh"))
+	rtest.OK(t, err)
+	rtest.Assert(t, len(scripts) > 0, "no known hosts script found")
+
+	rtest.OK(t, be.Close())
+	for _, script := range scripts {
+		_, err := os.Stat(script)
+		rtest.Assert(t, os.IsNotExist(err), "script %v not removed: %v", script, err)
+	}
+
+	// a different fingerprint is rejected
+	cfg = srv.sshConfig(t)
+	cfg.HostKeyFingerprint = "SHA256:" + strings.Repeat("A", 43)
+	be, err = Create(context.TODO(), cfg)
+	if err == nil {
+		_ = be.Close()
+	}
+	rtest.Assert(t, err != nil, "connected to a host with a mismatched key")
+}
+
+func TestHostKeyFingerprintInvalid(t *testing.T) {
+	for _, fp := range []string{
+		"",
+		"SHA256:abc",
+		"MD5:" + strings.Repeat("a", 43),
+		"SHA256:" + strings.Repeat("A", 42) + "\"",
+		"SHA256:" + strings.Repeat("A", 42) + " ",
+	} {
+		rtest.Assert(t, validHostKeyFingerprint(fp) != nil, "fingerprint %q accepted", fp)
+	}
+
+	rtest.OK(t, validHostKeyFingerprint("SHA256:"+strings.Repeat("A", 43)))
+
+	_, _, err := buildSSHCommand(Config{Command: "ssh foo", HostKeyFingerprint: "SHA256:" + strings.Repeat("A", 43)})
+	rtest.Assert(t, err != nil, "fingerprint accepted with a custom command")
+}
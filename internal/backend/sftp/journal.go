@@ -0,0 +1,189 @@
+package sftp
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+)
+
+// journalDir is the directory below the repository which contains the temp
+// file journals.
+const journalDir = "tmp-journal"
+
+// tempJournal records each temporary file in a separate journal file on the
+// server before it is created, and removes the journal file once the
+// temporary file has been renamed or deleted. This allows removing temporary
+// files left behind by a crashed process. The journal files of a backend
+// instance share a unique prefix, so that concurrent saves never write to the
+// same journal file.
+type tempJournal struct {
+	dir    string
+	prefix string
+}
+
+func newTempJournal(dir string) *tempJournal {
+	return &tempJournal{
+		dir:    dir,
+		prefix: tempSuffix(),
+	}
+}
+
+// entryFilename returns the name of the journal file for the temporary file
+// name.
+func (j *tempJournal) entryFilename(name string) string {
+	return path.Join(j.dir, j.prefix+"-"+path.Base(name))
+}
+
+// owns returns true if the journal file with the base name name belongs to
+// this backend instance.
+func (j *tempJournal) owns(name string) bool {
+	return strings.HasPrefix(name, j.prefix+"-")
+}
+
+// journalAdd records the temporary file name in the journal, if enabled. The
+// journal is only an aid for cleaning up, so errors are logged but otherwise
+// ignored.
+func (r *SFTP) journalAdd(name string) {
+	if r.journal == nil {
+		return
+	}
+
+	filename := r.journal.entryFilename(name)
+	f, err := r.client().OpenFile(filename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY)
+	if r.IsNotExist(err) {
+		mkdirErr := r.mkdirAll(r.journal.dir)
+		if mkdirErr == nil {
+			f, err = r.client().OpenFile(filename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY)
+		}
+	}
+	if err != nil {
+		debug.Log("unable to open journal %v: %v", filename, err)
+		return
+	}
+
+	_, err = f.Write([]byte(name + "\n"))
+	if err != nil {
+		debug.Log("unable to write journal %v: %v", filename, err)
+	}
+
+	err = f.Close()
+	if err != nil {
+		debug.Log("unable to close journal %v: %v", filename, err)
+	}
+}
+
+// journalRemove removes the temporary file name from the journal, if enabled.
+func (r *SFTP) journalRemove(name string) {
+	if r.journal == nil {
+		return
+	}
+
+	filename := r.journal.entryFilename(name)
+	if err := r.client().Remove(filename); err != nil {
+		debug.Log("unable to remove journal %v: %v", filename, err)
+	}
+}
+
+// isTempFilename returns true if name is a temporary file within the
+// repository.
+func (r *SFTP) isTempFilename(name string) bool {
+	return strings.HasPrefix(name, r.p+"/") &&
+		!strings.Contains(name, "/../") &&
+		strings.Contains(path.Base(name), "-restic-temp-")
+}
+
+// CleanupTemp removes all temporary files recorded in the journal files of
+// other backend instances, which are left behind when a process crashes while
+// saving files. Afterwards the journals are removed. Invalid entries in a
+// journal are ignored. The number of removed files is returned.
+//
+// CleanupTemp must only be called while no other process is saving files to
+// the repository, for example while holding an exclusive lock.
+func (r *SFTP) CleanupTemp(ctx context.Context) (int, error) {
+	if err := r.checkReadOnly("remove"); err != nil {
+		return 0, err
+	}
+
+	dir := r.Join(r.p, journalDir)
+
+	r.sem.GetToken()
+	defer r.sem.ReleaseToken()
+
+	entries, err := r.ReadDir(ctx, dir)
+	if err != nil {
+		if r.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, errors.Wrap(err, "ReadDir")
+	}
+
+	removed := 0
+	for _, fi := range entries {
+		if ctx.Err() != nil {
+			return removed, ctx.Err()
+		}
+
+		if !fi.Mode().IsRegular() || (r.journal != nil && r.journal.owns(fi.Name())) {
+			continue
+		}
+		filename := r.Join(dir, fi.Name())
+
+		names, err := r.readJournal(filename)
+		if err != nil {
+			debug.Log("unable to read journal %v, ignoring it: %v", filename, err)
+			continue
+		}
+
+		for _, name := range names {
+			err := r.client().Remove(name)
+			if err == nil {
+				debug.Log("removed temp file %v", name)
+				removed++
+				continue
+			}
+			if !r.IsNotExist(err) {
+				return removed, errors.Wrap(err, "Remove")
+			}
+		}
+
+		err = r.client().Remove(filename)
+		if err != nil && !r.IsNotExist(err) {
+			return removed, errors.Wrap(err, "Remove")
+		}
+	}
+
+	return removed, nil
+}
+
+// readJournal returns the valid temporary file names recorded in the journal
+// file.
+func (r *SFTP) readJournal(filename string) ([]string, error) {
+	f, err := r.client().Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		name := sc.Text()
+		if !r.isTempFilename(name) {
+			debug.Log("ignoring invalid journal entry %q", name)
+			continue
+		}
+		names = append(names, name)
+	}
+
+	err = sc.Err()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	return names, f.Close()
+}
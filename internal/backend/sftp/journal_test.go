@@ -0,0 +1,97 @@
+package sftp
+
+import (
+	"context"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func writeServerFile(t testing.TB, be *SFTP, filename string, data []byte) {
+	f, err := be.client().Create(filename)
+	rtest.OK(t, err)
+	_, err = f.Write(data)
+	rtest.OK(t, err)
+	rtest.OK(t, f.Close())
+}
+
+func TestTempJournalCleanup(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.TempJournal = true
+	be := newTestBackend(t, cfg)
+
+	// simulate a crashed process which left temp files and its journal behind
+	orphan1 := be.Join(be.Location(), "data", "ab", "abcdef-restic-temp-123456")
+	orphan2 := be.Join(be.Location(), "index", "012345-restic-temp-654321")
+	missing := be.Join(be.Location(), "data", "cd", "cdef01-restic-temp-111111")
+	other := be.Join(be.Location(), "keys", "somekey")
+	outside := be.Join(cfg.Path+"-other", "x-restic-temp-222222")
+
+	for _, name := range []string{orphan1, orphan2, other} {
+		writeServerFile(t, be, name, []byte("foo"))
+	}
+
+	journal := strings.Join([]string{orphan1, "\x00garbage", missing, other, outside, "", orphan2}, "\n")
+	rtest.OK(t, be.client().MkdirAll(be.Join(be.Location(), journalDir)))
+	writeServerFile(t, be, be.Join(be.Location(), journalDir, "crashed"), []byte(journal))
+
+	// a normal save does not leave entries in the own journal
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	saveFile(t, be, h, data)
+
+	rtest.Equals(t, []string{"crashed"}, dirEntries(t, be, be.Join(be.Location(), journalDir)))
+
+	// the journal file of a temp file being saved is not touched
+	pending := be.Join(be.Location(), "data", "ef", "ef0123-restic-temp-333333")
+	writeServerFile(t, be, pending, []byte("foo"))
+	be.journalAdd(pending)
+	names, err := be.readJournal(be.journal.entryFilename(pending))
+	rtest.OK(t, err)
+	rtest.Equals(t, []string{pending}, names)
+
+	n, err := be.CleanupTemp(context.TODO())
+	rtest.OK(t, err)
+	rtest.Equals(t, 2, n)
+
+	for _, name := range []string{orphan1, orphan2} {
+		_, err := be.client().Lstat(name)
+		rtest.Assert(t, be.IsNotExist(err), "orphan %v was not removed: %v", name, err)
+	}
+
+	_, err = be.client().Lstat(other)
+	rtest.OK(t, err)
+	_, err = be.client().Lstat(be.Filename(h))
+	rtest.OK(t, err)
+
+	// only the journal of this instance is left
+	_, err = be.client().Lstat(pending)
+	rtest.OK(t, err)
+	rtest.Equals(t, []string{path.Base(be.journal.entryFilename(pending))}, dirEntries(t, be, be.Join(be.Location(), journalDir)))
+	be.journalRemove(pending)
+	rtest.OK(t, be.client().Remove(pending))
+
+	// a second cleanup has nothing to do
+	n, err = be.CleanupTemp(context.TODO())
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, n)
+}
+
+func TestTempJournalUnreadable(t *testing.T) {
+	cfg := newTestConfig(t)
+	be := newTestBackend(t, cfg)
+
+	// no journal directory
+	n, err := be.CleanupTemp(context.TODO())
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, n)
+
+	// directories in the journal directory are skipped
+	rtest.OK(t, be.client().MkdirAll(be.Join(be.Location(), journalDir, "subdir")))
+	n, err = be.CleanupTemp(context.TODO())
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, n)
+}
@@ -0,0 +1,111 @@
+package sftp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// saveDataFiles stores n data files in different subdirectories and returns
+// their sorted names.
+func saveDataFiles(t testing.TB, be *SFTP, n int) []string {
+	var names []string
+	for i := 0; i < n; i++ {
+		data := []byte(fmt.Sprintf("file %d", i))
+		h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+		saveFile(t, be, h, data)
+		names = append(names, h.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestListInterrupted(t *testing.T) {
+	be, err := Create(context.TODO(), newTestConfig(t))
+	rtest.OK(t, err)
+	defer func() {
+		// ignore the error as the server has been killed
+		_ = be.Close()
+	}()
+
+	saveDataFiles(t, be, 50)
+
+	listed := 0
+	err = be.List(context.TODO(), restic.PackFile, func(fi restic.FileInfo) error {
+		listed++
+		if listed == 10 {
+			killServer(t, be)
+		}
+		return nil
+	})
+	rtest.Assert(t, errors.Is(err, ErrListInterrupted), "expected ErrListInterrupted, got %v", err)
+	// entries which were already received from the server by the concurrent
+	// directory listings may still be reported after the server was killed
+	rtest.Assert(t, listed >= 10 && listed < 50, "unexpected number of listed files %v", listed)
+}
+
+func TestListInterruptedReconnect(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.Reconnect = true
+	be := newTestBackend(t, cfg)
+
+	want := saveDataFiles(t, be, 50)
+
+	var names []string
+	err := be.List(context.TODO(), restic.PackFile, func(fi restic.FileInfo) error {
+		names = append(names, fi.Name)
+		if len(names) == 10 || len(names) == 30 {
+			killServer(t, be)
+		}
+		return nil
+	})
+	rtest.OK(t, err)
+
+	sort.Strings(names)
+	rtest.Equals(t, want, names)
+	rtest.Equals(t, 2, be.ReconnectCount())
+}
+
+func TestListParallel(t *testing.T) {
+	be := newTestBackend(t, newTestConfig(t))
+
+	want := saveDataFiles(t, be, 200)
+
+	seen := make(map[string]int)
+	err := be.List(context.TODO(), restic.PackFile, func(fi restic.FileInfo) error {
+		seen[fi.Name]++
+		return nil
+	})
+	rtest.OK(t, err)
+
+	var names []string
+	for name, n := range seen {
+		rtest.Equals(t, 1, n)
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	rtest.Equals(t, want, names)
+}
+
+func TestListParallelStop(t *testing.T) {
+	be := newTestBackend(t, newTestConfig(t))
+
+	saveDataFiles(t, be, 100)
+
+	errStop := errors.New("stop")
+	listed := 0
+	err := be.List(context.TODO(), restic.PackFile, func(fi restic.FileInfo) error {
+		listed++
+		if listed == 5 {
+			return errStop
+		}
+		return nil
+	})
+	rtest.Assert(t, errors.Is(err, errStop), "expected errStop, got %v", err)
+	rtest.Equals(t, 5, listed)
+}
@@ -0,0 +1,47 @@
+package sftp
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// ErrConfigTooLarge is returned by LoadConfig if the config file exceeds the
+// size limit.
+var ErrConfigTooLarge = errors.New("config file too large")
+
+// LoadConfig returns the contents of the config file, which must not be
+// larger than maxSize bytes. If the repository has not been initialized, the
+// returned error satisfies IsNotExist.
+func (r *SFTP) LoadConfig(ctx context.Context, maxSize int64) ([]byte, error) {
+	debug.Log("LoadConfig, max size %v%v", maxSize, r.tagInfo())
+
+	rd, err := r.openReader(ctx, restic.Handle{Type: restic.ConfigFile}, 0, 0)
+	if err != nil {
+		if r.IsNotExist(err) {
+			return nil, fmt.Errorf("repository not initialized: %w", err)
+		}
+		return nil, errors.Wrap(err, "Open")
+	}
+
+	buf, err := io.ReadAll(io.LimitReader(rd, maxSize+1))
+	if err != nil {
+		_ = rd.Close()
+		return nil, errors.Wrap(err, "Read")
+	}
+
+	err = rd.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "Close")
+	}
+
+	if int64(len(buf)) > maxSize {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrConfigTooLarge, maxSize)
+	}
+
+	return buf, nil
+}
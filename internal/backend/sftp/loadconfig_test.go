@@ -0,0 +1,43 @@
+package sftp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestLoadConfig(t *testing.T) {
+	be := newTestBackend(t, newTestConfig(t))
+
+	_, err := be.LoadConfig(context.TODO(), 1024)
+	rtest.Assert(t, be.IsNotExist(err), "expected not-exist error for missing config, got %v", err)
+
+	data := []byte("config data")
+	saveFile(t, be, restic.Handle{Type: restic.ConfigFile}, data)
+
+	buf, err := be.LoadConfig(context.TODO(), 1024)
+	rtest.OK(t, err)
+	rtest.Equals(t, data, buf)
+
+	buf, err = be.LoadConfig(context.TODO(), int64(len(data)))
+	rtest.OK(t, err)
+	rtest.Equals(t, data, buf)
+
+	_, err = be.LoadConfig(context.TODO(), int64(len(data))-1)
+	rtest.Assert(t, errors.Is(err, ErrConfigTooLarge), "expected ErrConfigTooLarge, got %v", err)
+	rtest.Assert(t, !be.IsNotExist(err), "oversized config reported as missing")
+}
+
+func TestLoadConfigLarge(t *testing.T) {
+	be := newTestBackend(t, newTestConfig(t))
+
+	data := bytes.Repeat([]byte("x"), 1<<20)
+	saveFile(t, be, restic.Handle{Type: restic.ConfigFile}, data)
+
+	_, err := be.LoadConfig(context.TODO(), 4096)
+	rtest.Assert(t, errors.Is(err, ErrConfigTooLarge), "expected ErrConfigTooLarge, got %v", err)
+}
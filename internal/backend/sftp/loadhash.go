@@ -0,0 +1,31 @@
+package sftp
+
+import (
+	"context"
+	"hash"
+	"io"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// LoadAndHash streams the contents of the file through hasher without
+// buffering it and returns the number of bytes hashed. The caller is
+// responsible for checking the digest. Like Load, the file is read again if
+// the connection is lost, in which case hasher is reset first.
+func (r *SFTP) LoadAndHash(ctx context.Context, h restic.Handle, hasher hash.Hash) (int64, error) {
+	debug.Log("LoadAndHash %v%v", h, r.tagInfo())
+
+	var n int64
+	err := r.Load(ctx, h, 0, 0, func(rd io.Reader) error {
+		hasher.Reset()
+		var err error
+		n, err = io.Copy(hasher, rd)
+		if err != nil {
+			return errors.Wrap(err, "Copy")
+		}
+		return nil
+	})
+	return n, err
+}
@@ -0,0 +1,57 @@
+package sftp
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestLoadAndHash(t *testing.T) {
+	be := newTestBackend(t, newTestConfig(t))
+
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	saveFile(t, be, h, data)
+
+	hasher := sha256.New()
+	n, err := be.LoadAndHash(context.TODO(), h, hasher)
+	rtest.OK(t, err)
+	rtest.Equals(t, int64(len(data)), n)
+	rtest.Equals(t, "c3ab8ff13720e8ad9047dd39466b3c8974e592c2fa383d4a3960714caef0c4f2", hex.EncodeToString(hasher.Sum(nil)))
+
+	data = rtest.Random(23, 3<<20)
+	h = restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	saveFile(t, be, h, data)
+
+	hasher = sha256.New()
+	n, err = be.LoadAndHash(context.TODO(), h, hasher)
+	rtest.OK(t, err)
+	rtest.Equals(t, int64(len(data)), n)
+	rtest.Equals(t, h.Name, hex.EncodeToString(hasher.Sum(nil)))
+
+	_, err = be.LoadAndHash(context.TODO(), restic.Handle{Type: restic.PackFile, Name: "missing"}, sha256.New())
+	rtest.Assert(t, be.IsNotExist(err), "expected not-exist error, got %v", err)
+}
+
+func TestLoadAndHashReconnect(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.Reconnect = true
+	be := newTestBackend(t, cfg)
+
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	saveFile(t, be, h, data)
+
+	killServer(t, be)
+
+	hasher := sha256.New()
+	n, err := be.LoadAndHash(context.TODO(), h, hasher)
+	rtest.OK(t, err)
+	rtest.Equals(t, int64(len(data)), n)
+	rtest.Equals(t, h.Name, hex.EncodeToString(hasher.Sum(nil)))
+	rtest.Equals(t, 1, be.ReconnectCount())
+}
@@ -0,0 +1,47 @@
+package sftp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestLoadRange(t *testing.T) {
+	be := newTestBackend(t, newTestConfig(t))
+
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	saveFile(t, be, h, data)
+
+	load := func(length int, offset int64) ([]byte, error) {
+		var buf []byte
+		err := be.Load(context.TODO(), h, length, offset, func(rd io.Reader) error {
+			var err error
+			buf, err = io.ReadAll(rd)
+			return err
+		})
+		return buf, err
+	}
+
+	// a length which extends past the end returns the available data
+	buf, err := load(10, 3)
+	rtest.OK(t, err)
+	rtest.Equals(t, []byte("bar"), buf)
+
+	// reading at the end of the file returns no data
+	for _, length := range []int{0, 5} {
+		buf, err = load(length, int64(len(data)))
+		rtest.OK(t, err)
+		rtest.Equals(t, 0, len(buf))
+	}
+
+	// offsets past the end are an error
+	for _, length := range []int{0, 5} {
+		_, err = load(length, int64(len(data))+1)
+		rtest.Assert(t, errors.Is(err, ErrOffsetBeyondEOF), "expected ErrOffsetBeyondEOF, got %v", err)
+	}
+}
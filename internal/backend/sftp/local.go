@@ -0,0 +1,60 @@
+package sftp
+
+import (
+	"net"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+
+	"github.com/pkg/sftp"
+)
+
+// startLocalClient serves the local file system with an sftp server running
+// in the current process and connects to it through an in-memory pipe. This
+// exercises the same code paths as a remote server, without running ssh.
+func startLocalClient(cfg Config) (_ *SFTP, err error) {
+	if cfg.Command != "" {
+		return nil, errors.New("the local file system cannot be used with a custom command")
+	}
+	if cfg.IdentityFile != "" {
+		return nil, errors.New("the local file system cannot be used with an identity file")
+	}
+
+	clientConn, serverConn := net.Pipe()
+	defer func() {
+		if err != nil {
+			_ = clientConn.Close()
+			_ = serverConn.Close()
+		}
+	}()
+
+	server, err := sftp.NewServer(serverConn)
+	if err != nil {
+		return nil, errors.Wrap(err, "NewServer")
+	}
+
+	// wait in a different goroutine
+	ch := newExitResult()
+	go func() {
+		err := server.Serve()
+		debug.Log("local sftp server stopped, err %v", err)
+		_ = server.Close()
+		ch.exit(errors.Wrap(err, "local sftp server stopped"))
+	}()
+
+	client, err := startSession(cfg.Timeout, func() (*sftp.Client, error) {
+		return sftp.NewClientPipe(clientConn, clientConn)
+	}, func() {
+		_ = serverConn.Close()
+	})
+	if err != nil {
+		if errors.Is(err, ErrTimeout) {
+			return nil, err
+		}
+		return nil, errors.Errorf("unable to start the sftp session, error: %v", err)
+	}
+
+	_, posixRename := client.HasExtension("posix-rename@openssh.com")
+	conn := &connection{c: client, local: serverConn, result: ch, posixRename: posixRename}
+	return &SFTP{conn: conn, results: &resultWriter{}, Config: cfg}, nil
+}
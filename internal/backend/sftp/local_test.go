@@ -0,0 +1,69 @@
+package sftp
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// newLocalTestConfig returns a config for a repository served from the local
+// file system.
+func newLocalTestConfig(t testing.TB) Config {
+	cfg := NewConfig()
+	cfg.Path = filepath.Join(rtest.TempDir(t), "repo")
+	cfg.LocalFallback = true
+	return cfg
+}
+
+func TestLocalFallback(t *testing.T) {
+	cfg := newLocalTestConfig(t)
+	cfg.TempNameFunc = func(h restic.Handle) string {
+		return "test"
+	}
+	be := newTestBackend(t, cfg)
+
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+
+	_, err := be.Stat(context.TODO(), h)
+	rtest.Assert(t, be.IsNotExist(err), "expected a not exist error, got %v", err)
+
+	saveFile(t, be, h, data)
+
+	// the file is stored with the sharded layout and the configured mode
+	filename := filepath.Join(cfg.Path, "data", h.Name[:2], h.Name)
+	fi, err := os.Lstat(filename)
+	rtest.OK(t, err)
+	rtest.Equals(t, be.Modes.File, fi.Mode())
+
+	buf, err := os.ReadFile(filename)
+	rtest.OK(t, err)
+	rtest.Equals(t, data, buf)
+
+	// an existing temporary file is not overwritten
+	data = []byte("other data")
+	h = restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	tmpFilename := be.Filename(h) + "-restic-temp-test"
+	rtest.OK(t, os.MkdirAll(filepath.Dir(tmpFilename), 0700))
+	rtest.OK(t, os.WriteFile(tmpFilename, []byte("in use"), 0600))
+
+	err = be.Save(context.TODO(), h, restic.NewByteReader(data, nil))
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "already exists"), "expected collision error, got %v", err)
+
+	buf, err = os.ReadFile(tmpFilename)
+	rtest.OK(t, err)
+	rtest.Equals(t, []byte("in use"), buf)
+}
+
+func TestLocalFallbackCommand(t *testing.T) {
+	cfg := newLocalTestConfig(t)
+	cfg.Command = "ssh-wrapper"
+
+	_, err := Create(context.TODO(), cfg)
+	rtest.Assert(t, err != nil, "custom command accepted for the local file system")
+}
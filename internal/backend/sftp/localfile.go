@@ -0,0 +1,42 @@
+package sftp
+
+import (
+	"io"
+	"os"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// uploadSource returns the reader to upload the data of rd from. If rd reads
+// from a local file, the size of the file is checked against rd.Length()
+// before anything is uploaded, and a reader is returned which reports its
+// size to the sftp client, so that ReadFrom can split the upload into
+// concurrent requests. The returned reader always starts at the beginning of
+// the file.
+func uploadSource(rd restic.RewindReader) (io.Reader, error) {
+	fr, ok := rd.(*restic.FileReader)
+	if !ok {
+		return rd, nil
+	}
+
+	f, ok := fr.ReadSeeker.(*os.File)
+	if !ok {
+		return rd, nil
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, errors.Wrap(err, "Stat")
+	}
+
+	if !fi.Mode().IsRegular() {
+		return rd, nil
+	}
+
+	if fi.Size() != rd.Length() {
+		return nil, errors.Errorf("size of %v changed from %d to %d bytes", f.Name(), rd.Length(), fi.Size())
+	}
+
+	return io.NewSectionReader(f, 0, fi.Size()), nil
+}
@@ -0,0 +1,77 @@
+package sftp
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// tempFileReader writes data to a local file and returns a reader for it.
+func tempFileReader(t testing.TB, data []byte) (*os.File, *restic.FileReader) {
+	filename := filepath.Join(t.TempDir(), "source")
+	rtest.OK(t, os.WriteFile(filename, data, 0600))
+
+	f, err := os.Open(filename)
+	rtest.OK(t, err)
+	t.Cleanup(func() {
+		_ = f.Close()
+	})
+
+	rd, err := restic.NewFileReader(f, nil)
+	rtest.OK(t, err)
+	return f, rd
+}
+
+func TestSaveLocalFile(t *testing.T) {
+	be := newTestBackend(t, newTestConfig(t))
+
+	data := rtest.Random(23, 5*1024*1024+17)
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	f, rd := tempFileReader(t, data)
+
+	src, err := uploadSource(rd)
+	rtest.OK(t, err)
+	_, ok := src.(*io.SectionReader)
+	rtest.Assert(t, ok, "local file not detected, got %T", src)
+
+	// the upload does not depend on the current position in the file
+	_, err = f.Seek(1000, io.SeekStart)
+	rtest.OK(t, err)
+
+	rtest.OK(t, be.Save(context.TODO(), h, rd))
+
+	buf, err := backend.LoadAll(context.TODO(), nil, be, h)
+	rtest.OK(t, err)
+	rtest.Equals(t, len(data), len(buf))
+	rtest.Equals(t, restic.Hash(data), restic.Hash(buf))
+}
+
+func TestSaveLocalFileSizeChanged(t *testing.T) {
+	be := newTestBackend(t, newTestConfig(t))
+
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	f, rd := tempFileReader(t, data)
+
+	// the file grows after the reader has been created
+	rtest.OK(t, os.WriteFile(f.Name(), append(data, "baz"...), 0600))
+
+	err := be.Save(context.TODO(), h, rd)
+	rtest.Assert(t, err != nil, "expected error for changed file size")
+
+	_, err = be.Stat(context.TODO(), h)
+	rtest.Assert(t, be.IsNotExist(err), "file was saved: %v", err)
+}
+
+func TestUploadSourceOther(t *testing.T) {
+	rd := restic.NewByteReader([]byte("foobar"), nil)
+	src, err := uploadSource(rd)
+	rtest.OK(t, err)
+	rtest.Equals(t, restic.RewindReader(rd), src.(restic.RewindReader))
+}
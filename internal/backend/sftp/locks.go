@@ -0,0 +1,179 @@
+package sftp
+
+import (
+	"context"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+// serverTime returns the current time according to the server's clock. It
+// creates a temporary file in the lock directory and uses its modification
+// time. The file is named and journaled like the other temporary files, so
+// the startup temp policies and CleanupTemp remove it if it is left behind.
+func (r *SFTP) serverTime() (time.Time, error) {
+	if err := r.checkReadOnly("determining the server time"); err != nil {
+		return time.Time{}, err
+	}
+
+	// the layout is unknown while the repository is being created
+	dir := r.p
+	if r.Layout != nil {
+		dir, _ = r.Basedir(restic.LockFile)
+	}
+	filename := r.Join(dir, "restic-clock-restic-temp-"+tempSuffix())
+
+	r.journalAdd(filename)
+	f, err := r.client().OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY)
+	if r.IsNotExist(err) {
+		mkdirErr := r.mkdirAll(dir)
+		if mkdirErr == nil {
+			f, err = r.client().OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY)
+		}
+	}
+	if err != nil {
+		r.journalRemove(filename)
+		return time.Time{}, errors.Wrap(err, "OpenFile")
+	}
+
+	fi, err := f.Stat()
+	_ = f.Close()
+
+	// keep the journal entry if the file could not be removed
+	if rmErr := r.client().Remove(filename); rmErr != nil {
+		debug.Log("unable to remove %v: %v", filename, rmErr)
+	} else {
+		r.journalRemove(filename)
+	}
+
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "Stat")
+	}
+
+	return fi.ModTime(), nil
+}
+
+// LockAge returns the time which has passed since the lock file for h was
+// last modified. The age is computed using the server's clock, so it is not
+// affected by a clock skew between the client and the server. If the lock
+// file does not exist, an error for which IsNotExist returns true is returned.
+// As determining the server's clock requires creating a temporary file,
+// LockAge fails with ErrReadOnly if the repository is opened read-only.
+func (r *SFTP) LockAge(ctx context.Context, h restic.Handle) (time.Duration, error) {
+	debug.Log("LockAge(%v)", h)
+	if err := r.clientError(ctx); err != nil {
+		return 0, err
+	}
+
+	if err := h.Valid(); err != nil {
+		return 0, backoff.Permanent(err)
+	}
+
+	r.sem.GetToken()
+	defer r.sem.ReleaseToken()
+
+	fi, err := r.client().Lstat(r.Filename(h))
+	if err != nil {
+		return 0, errors.Wrap(err, "Lstat")
+	}
+
+	now, err := r.serverTime()
+	if err != nil {
+		return 0, err
+	}
+
+	return now.Sub(fi.ModTime()), nil
+}
+
+// PruneStaleLocks removes all lock files which have not been modified for
+// longer than maxAge and returns their names. Like LockAge, the age is
+// computed using the server's clock. Read-only lock files are made writable
+// if the server refuses to remove them. Lock files which are removed
+// concurrently are skipped. It fails with ErrMaintenanceInProgress while
+// another process holds the maintenance lock.
+func (r *SFTP) PruneStaleLocks(ctx context.Context, maxAge time.Duration) ([]string, error) {
+	debug.Log("PruneStaleLocks(%v)", maxAge)
+	if err := r.checkReadOnly("remove"); err != nil {
+		return nil, err
+	}
+
+	if err := r.clientError(ctx); err != nil {
+		return nil, err
+	}
+	if err := r.checkMaintenance(); err != nil {
+		return nil, err
+	}
+
+	r.sem.GetToken()
+	defer r.sem.ReleaseToken()
+
+	dir, _ := r.Basedir(restic.LockFile)
+	entries, err := r.ReadDir(ctx, dir)
+	if err != nil {
+		if r.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "ReadDir")
+	}
+
+	now, err := r.serverTime()
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	for _, fi := range entries {
+		if ctx.Err() != nil {
+			return removed, ctx.Err()
+		}
+
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		if _, err := restic.ParseID(fi.Name()); err != nil {
+			continue
+		}
+
+		age := now.Sub(fi.ModTime())
+		if age <= maxAge {
+			continue
+		}
+
+		filename := r.Join(dir, fi.Name())
+		debug.Log("removing lock %v, age %v", fi.Name(), age)
+		err = r.removeLock(filename)
+		if r.IsNotExist(err) {
+			continue
+		}
+		r.reportResult("remove", objectName(restic.Handle{Type: restic.LockFile, Name: fi.Name()}), fi.Size(), err)
+		if err != nil {
+			return removed, errors.Wrap(err, "Remove")
+		}
+		removed = append(removed, fi.Name())
+	}
+
+	sort.Strings(removed)
+	return removed, nil
+}
+
+// removeLock removes the lock file filename. If the server denies this, the
+// file is made writable and removing it is tried again.
+func (r *SFTP) removeLock(filename string) error {
+	err := r.client().Remove(filename)
+	if !errors.Is(err, os.ErrPermission) {
+		return err
+	}
+
+	debug.Log("removing %v failed, making it writable: %v", filename, err)
+	if chmodErr := r.client().Chmod(filename, r.Modes.File); chmodErr != nil {
+		debug.Log("unable to chmod %v: %v", filename, chmodErr)
+		return err
+	}
+	return r.client().Remove(filename)
+}
@@ -0,0 +1,90 @@
+package sftp
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestLockAge(t *testing.T) {
+	be := newTestBackend(t, newTestConfig(t))
+
+	data := []byte("lock")
+	h := restic.Handle{Type: restic.LockFile, Name: restic.Hash(data).String()}
+	saveFile(t, be, h, data)
+
+	// the lock was last modified an hour ago on the server
+	mtime := time.Now().Add(-time.Hour)
+	rtest.OK(t, be.client().Chtimes(be.Filename(h), mtime, mtime))
+
+	age, err := be.LockAge(context.TODO(), h)
+	rtest.OK(t, err)
+	rtest.Assert(t, age >= time.Hour-2*time.Second && age <= time.Hour+2*time.Second,
+		"unexpected lock age %v", age)
+
+	// the temporary file used to determine the server time is removed, only
+	// the directories of the repository remain
+	rtest.Equals(t, 5, len(dirEntries(t, be, be.Location())))
+
+	_, err = be.LockAge(context.TODO(), restic.Handle{Type: restic.LockFile, Name: restic.NewRandomID().String()})
+	rtest.Assert(t, be.IsNotExist(err), "expected a not exist error, got %v", err)
+}
+
+func TestServerTime(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.TempJournal = true
+	be := newTestBackend(t, cfg)
+
+	now, err := be.serverTime()
+	rtest.OK(t, err)
+
+	// client and server run on the same host in this test
+	diff := time.Since(now)
+	rtest.Assert(t, diff > -2*time.Second && diff < 2*time.Second, "server time differs by %v", diff)
+
+	// neither the temporary file nor its journal entry are left behind
+	lockDir, _ := be.Basedir(restic.LockFile)
+	rtest.Equals(t, 0, len(dirEntries(t, be, lockDir)))
+	rtest.Equals(t, 0, len(dirEntries(t, be, be.Join(be.Location(), journalDir))))
+}
+
+func TestPruneStaleLocks(t *testing.T) {
+	be := newTestBackend(t, newTestConfig(t))
+
+	var fresh, stale []string
+	for i := 0; i < 4; i++ {
+		data := []byte(fmt.Sprintf("lock %d", i))
+		h := restic.Handle{Type: restic.LockFile, Name: restic.Hash(data).String()}
+		saveFile(t, be, h, data)
+
+		if i%2 == 0 {
+			fresh = append(fresh, h.Name)
+			continue
+		}
+		mtime := time.Now().Add(-2 * time.Hour)
+		rtest.OK(t, be.client().Chtimes(be.Filename(h), mtime, mtime))
+		stale = append(stale, h.Name)
+	}
+	sort.Strings(fresh)
+	sort.Strings(stale)
+
+	// one of the stale locks is read-only
+	rtest.OK(t, be.client().Chmod(be.Filename(restic.Handle{Type: restic.LockFile, Name: stale[0]}), 0400))
+
+	removed, err := be.PruneStaleLocks(context.TODO(), time.Hour)
+	rtest.OK(t, err)
+	rtest.Equals(t, stale, removed)
+	remaining := dirEntries(t, be, be.Dirname(restic.Handle{Type: restic.LockFile}))
+	sort.Strings(remaining)
+	rtest.Equals(t, fresh, remaining)
+
+	// pruning again is a no-op
+	removed, err = be.PruneStaleLocks(context.TODO(), time.Hour)
+	rtest.OK(t, err)
+	rtest.Equals(t, []string(nil), removed)
+}
@@ -0,0 +1,274 @@
+package sftp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+// ErrMaintenanceInProgress is returned by MaintenanceLock and by destructive
+// operations while another process holds the maintenance lock.
+var ErrMaintenanceInProgress = errors.New("repository maintenance in progress")
+
+// maintenanceLockName is the name of the marker file in the repository
+// directory which exists while the maintenance lock is held.
+const maintenanceLockName = "maintenance.lock"
+
+// defaultMaintenanceLockMaxAge is the default age after which a maintenance
+// lock is considered stale.
+const defaultMaintenanceLockMaxAge = 24 * time.Hour
+
+// maintenanceState records the token written to the marker file while this
+// backend holds the maintenance lock. It is shared with the copies returned
+// by WithTag.
+type maintenanceState struct {
+	m     sync.Mutex
+	token string
+}
+
+func (r *SFTP) maintenanceLockFile() string {
+	return r.Join(r.p, maintenanceLockName)
+}
+
+func (r *SFTP) maintenanceLockMaxAge() time.Duration {
+	if r.Config.MaintenanceLockMaxAge > 0 {
+		return r.Config.MaintenanceLockMaxAge
+	}
+	return defaultMaintenanceLockMaxAge
+}
+
+// MaintenanceLock acquires the repository-wide maintenance lock by creating a
+// marker file exclusively. While the lock is held, Delete, CompactPrefixDirs
+// and EmptyTrash fail with ErrMaintenanceInProgress in other processes. If
+// the lock is held by someone else, ErrMaintenanceInProgress is returned,
+// unless the marker file is older than MaintenanceLockMaxAge, in which case
+// the stale lock is taken over. The returned function releases the lock, it
+// only removes the marker file if it has not been taken over in the meantime.
+func (r *SFTP) MaintenanceLock(ctx context.Context) (unlock func(), err error) {
+	debug.Log("MaintenanceLock%v", r.tagInfo())
+	if err := r.checkReadOnly("lock"); err != nil {
+		return nil, err
+	}
+
+	if err := r.clientError(ctx); err != nil {
+		return nil, err
+	}
+
+	r.maintenance.m.Lock()
+	defer r.maintenance.m.Unlock()
+	if r.maintenance.token != "" {
+		return nil, errors.New("maintenance lock is already held by this backend")
+	}
+
+	r.sem.GetToken()
+	defer r.sem.ReleaseToken()
+
+	// the lock is not acquired if ctx has been cancelled while waiting
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	token := tempSuffix()
+	err = r.createMaintenanceLock(token)
+	if errors.Is(err, os.ErrExist) {
+		err = r.takeOverMaintenanceLock()
+		if err == nil {
+			err = r.createMaintenanceLock(token)
+		}
+	}
+	if errors.Is(err, os.ErrExist) {
+		// someone else has created or taken over the lock concurrently
+		err = backoff.Permanent(ErrMaintenanceInProgress)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r.maintenance.token = token
+	return func() {
+		r.releaseMaintenanceLock(token)
+	}, nil
+}
+
+// createMaintenanceLock creates the marker file containing token. If it
+// already exists, an error wrapping os.ErrExist is returned.
+func (r *SFTP) createMaintenanceLock(token string) error {
+	return r.createLockFile(r.maintenanceLockFile(), token)
+}
+
+// createLockFile creates the marker file filename exclusively and writes token
+// to it. If it already exists, an error wrapping os.ErrExist is returned.
+func (r *SFTP) createLockFile(filename, token string) error {
+	f, err := r.client().OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY)
+	if err != nil {
+		// the server does not report why O_EXCL failed
+		if _, statErr := r.client().Lstat(filename); statErr == nil {
+			return fmt.Errorf("%w: %v", os.ErrExist, filename)
+		}
+		return errors.Wrap(err, "OpenFile")
+	}
+
+	_, err = f.Write([]byte(token))
+	if err != nil {
+		_ = f.Close()
+		_ = r.client().Remove(filename)
+		return errors.Wrap(err, "Write")
+	}
+
+	err = f.Close()
+	if err != nil {
+		_ = r.client().Remove(filename)
+		return errors.Wrap(err, "Close")
+	}
+	return nil
+}
+
+// takeOverMaintenanceLock removes the marker file if it is stale. If it is
+// not, ErrMaintenanceInProgress is returned.
+func (r *SFTP) takeOverMaintenanceLock() error {
+	return r.takeOverStaleFile(r.maintenanceLockFile(), r.maintenanceLockMaxAge(), ErrMaintenanceInProgress)
+}
+
+// takeOverStaleFile removes the marker file filename if it is older than
+// maxAge according to the server's clock. If it is not, an error wrapping
+// errHeld is returned. The marker file is first renamed to a unique name, so
+// that only one of several processes taking over the file concurrently
+// succeeds. If the file does not exist (anymore), nil is returned.
+func (r *SFTP) takeOverStaleFile(filename string, maxAge time.Duration, errHeld error) error {
+	age, err := r.fileAge(filename)
+	if r.IsNotExist(err) {
+		// the file has been removed in the meantime
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if age < maxAge {
+		return backoff.Permanent(fmt.Errorf("%w: lock created %v ago", errHeld, age.Round(time.Second)))
+	}
+
+	stale := filename + "-stale-" + tempSuffix()
+	err = r.client().Rename(filename, stale)
+	if r.IsNotExist(err) {
+		// another process has taken over the file
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "Rename")
+	}
+
+	// the file may have been replaced after its age was checked
+	fi, err := r.client().Lstat(stale)
+	if err == nil {
+		var now time.Time
+		now, err = r.serverTime()
+		if err == nil && now.Sub(fi.ModTime()) < maxAge {
+			if err := r.client().Rename(stale, filename); err != nil {
+				debug.Log("unable to restore lock %v: %v", stale, err)
+			}
+			return backoff.Permanent(errHeld)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	debug.Log("taking over stale lock %v created %v ago", filename, age)
+	if err := r.client().Remove(stale); err != nil {
+		debug.Log("unable to remove stale lock %v: %v", stale, err)
+	}
+	return nil
+}
+
+// maintenanceLockAge returns the age of the marker file according to the
+// server's clock.
+func (r *SFTP) maintenanceLockAge() (time.Duration, error) {
+	return r.fileAge(r.maintenanceLockFile())
+}
+
+// fileAge returns the time which has passed since filename was last
+// modified according to the server's clock.
+func (r *SFTP) fileAge(filename string) (time.Duration, error) {
+	fi, err := r.client().Lstat(filename)
+	if err != nil {
+		return 0, err
+	}
+
+	now, err := r.serverTime()
+	if err != nil {
+		return 0, err
+	}
+	return now.Sub(fi.ModTime()), nil
+}
+
+// releaseMaintenanceLock removes the marker file if it still contains token.
+// Errors are only logged, a stale lock is taken over eventually.
+func (r *SFTP) releaseMaintenanceLock(token string) {
+	r.maintenance.m.Lock()
+	defer r.maintenance.m.Unlock()
+	if r.maintenance.token != token {
+		return
+	}
+	r.maintenance.token = ""
+
+	r.sem.GetToken()
+	defer r.sem.ReleaseToken()
+
+	filename := r.maintenanceLockFile()
+	f, err := r.client().Open(filename)
+	if err != nil {
+		debug.Log("unable to open maintenance lock: %v", err)
+		return
+	}
+	buf, err := io.ReadAll(io.LimitReader(f, int64(len(token))+1))
+	_ = f.Close()
+	if err != nil {
+		debug.Log("unable to read maintenance lock: %v", err)
+		return
+	}
+
+	if string(buf) != token {
+		debug.Log("maintenance lock has been taken over, not removing it")
+		return
+	}
+
+	if err := r.client().Remove(filename); err != nil {
+		debug.Log("unable to remove maintenance lock: %v", err)
+	}
+}
+
+// checkMaintenance returns ErrMaintenanceInProgress if another process holds
+// the maintenance lock. Stale locks are ignored.
+func (r *SFTP) checkMaintenance() error {
+	r.maintenance.m.Lock()
+	held := r.maintenance.token != ""
+	r.maintenance.m.Unlock()
+	if held {
+		return nil
+	}
+
+	r.sem.GetToken()
+	defer r.sem.ReleaseToken()
+
+	age, err := r.maintenanceLockAge()
+	if r.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "Lstat")
+	}
+
+	if age >= r.maintenanceLockMaxAge() {
+		debug.Log("ignoring stale maintenance lock created %v ago", age)
+		return nil
+	}
+	return backoff.Permanent(fmt.Errorf("%w: lock created %v ago", ErrMaintenanceInProgress, age.Round(time.Second)))
+}
@@ -0,0 +1,124 @@
+package sftp
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// openTestBackend opens the existing repository for cfg. The backend is
+// closed when the test finishes.
+func openTestBackend(t testing.TB, cfg Config) *SFTP {
+	be, err := Open(context.TODO(), cfg)
+	rtest.OK(t, err)
+	t.Cleanup(func() {
+		rtest.OK(t, be.Close())
+	})
+	return be
+}
+
+func TestMaintenanceLock(t *testing.T) {
+	cfg := newTestConfig(t)
+	be := newTestBackend(t, cfg)
+	other := openTestBackend(t, cfg)
+
+	unlock, err := be.MaintenanceLock(context.TODO())
+	rtest.OK(t, err)
+	_, err = os.Stat(be.maintenanceLockFile())
+	rtest.OK(t, err)
+
+	_, err = be.MaintenanceLock(context.TODO())
+	rtest.Assert(t, err != nil, "lock acquired twice")
+
+	// the holder can run maintenance operations
+	_, err = be.CompactPrefixDirs(context.TODO())
+	rtest.OK(t, err)
+	_, err = be.WithTag("tagged").CompactPrefixDirs(context.TODO())
+	rtest.OK(t, err)
+
+	unlock()
+	_, err = os.Stat(be.maintenanceLockFile())
+	rtest.Assert(t, errors.Is(err, os.ErrNotExist), "lock file still exists: %v", err)
+
+	// unlocking twice does nothing
+	unlock()
+
+	unlock, err = other.MaintenanceLock(context.TODO())
+	rtest.OK(t, err)
+	unlock()
+
+	// a cancelled context does not acquire the lock
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	_, err = be.MaintenanceLock(ctx)
+	rtest.Assert(t, errors.Is(err, context.Canceled), "expected context.Canceled, got %v", err)
+	_, err = os.Stat(be.maintenanceLockFile())
+	rtest.Assert(t, errors.Is(err, os.ErrNotExist), "lock file created: %v", err)
+}
+
+func TestMaintenanceLockContended(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.TrashDir = "trash"
+	be := newTestBackend(t, cfg)
+	other := openTestBackend(t, cfg)
+
+	unlock, err := be.MaintenanceLock(context.TODO())
+	rtest.OK(t, err)
+	defer unlock()
+
+	_, err = other.MaintenanceLock(context.TODO())
+	rtest.Assert(t, errors.Is(err, ErrMaintenanceInProgress), "expected ErrMaintenanceInProgress, got %v", err)
+
+	// destructive operations are rejected
+	_, err = other.CompactPrefixDirs(context.TODO())
+	rtest.Assert(t, errors.Is(err, ErrMaintenanceInProgress), "expected ErrMaintenanceInProgress, got %v", err)
+	err = other.EmptyTrash(context.TODO(), 0)
+	rtest.Assert(t, errors.Is(err, ErrMaintenanceInProgress), "expected ErrMaintenanceInProgress, got %v", err)
+	err = other.Delete(context.TODO())
+	rtest.Assert(t, errors.Is(err, ErrMaintenanceInProgress), "expected ErrMaintenanceInProgress, got %v", err)
+
+	_, err = os.Stat(cfg.Path)
+	rtest.OK(t, err)
+}
+
+func TestMaintenanceLockStale(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.MaintenanceLockMaxAge = time.Hour
+	be := newTestBackend(t, cfg)
+	other := openTestBackend(t, cfg)
+
+	unlock, err := be.MaintenanceLock(context.TODO())
+	rtest.OK(t, err)
+
+	old := time.Now().Add(-2 * time.Hour)
+	rtest.OK(t, os.Chtimes(be.maintenanceLockFile(), old, old))
+
+	// stale locks are ignored and can be taken over
+	_, err = other.CompactPrefixDirs(context.TODO())
+	rtest.OK(t, err)
+
+	otherUnlock, err := other.MaintenanceLock(context.TODO())
+	rtest.OK(t, err)
+
+	// the previous holder does not remove the lock which has been taken over
+	unlock()
+	_, err = os.Stat(be.maintenanceLockFile())
+	rtest.OK(t, err)
+	_, err = be.CompactPrefixDirs(context.TODO())
+	rtest.Assert(t, errors.Is(err, ErrMaintenanceInProgress), "expected ErrMaintenanceInProgress, got %v", err)
+
+	otherUnlock()
+	_, err = os.Stat(be.maintenanceLockFile())
+	rtest.Assert(t, errors.Is(err, os.ErrNotExist), "lock file still exists: %v", err)
+
+	// the stale lock has been removed
+	entries, err := os.ReadDir(cfg.Path)
+	rtest.OK(t, err)
+	for _, e := range entries {
+		rtest.Assert(t, e.IsDir(), "unexpected file %v", e.Name())
+	}
+}
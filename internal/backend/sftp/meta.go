@@ -0,0 +1,158 @@
+package sftp
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+// metaDir is the directory in the repository which contains the metadata
+// files written by SaveWithMeta.
+const metaDir = "meta"
+
+// maxMetaSize is the maximum size of a metadata file read by LoadMeta.
+const maxMetaSize = 1 << 20
+
+// metaFilename returns the name of the metadata file for h. The metadata
+// files are stored as JSON in a directory per file type below metaDir, the
+// files themselves are not modified.
+func (r *SFTP) metaFilename(h restic.Handle) string {
+	if h.Type == restic.ConfigFile {
+		return r.Join(r.p, metaDir, "config.json")
+	}
+	return r.Join(r.p, metaDir, h.Type.String(), h.Name+".json")
+}
+
+// metaState records whether metadata files are used in the repository, so
+// that Remove only tries to remove them if they are. It is shared with the
+// copies returned by WithTag.
+type metaState struct {
+	m       sync.Mutex
+	checked bool
+	inUse   bool
+}
+
+// metaInUse returns true if metadata files may exist in the repository. The
+// metadata directory is checked once, afterwards only SaveWithMeta marks
+// metadata as used.
+func (r *SFTP) metaInUse() bool {
+	if r.meta == nil {
+		return true
+	}
+
+	r.meta.m.Lock()
+	defer r.meta.m.Unlock()
+	if !r.meta.checked {
+		_, err := r.client().Lstat(r.Join(r.p, metaDir))
+		r.meta.inUse = !r.IsNotExist(err)
+		r.meta.checked = true
+	}
+	return r.meta.inUse
+}
+
+// markMetaInUse records that metadata files are used in the repository.
+func (r *SFTP) markMetaInUse() {
+	if r.meta == nil {
+		return
+	}
+
+	r.meta.m.Lock()
+	defer r.meta.m.Unlock()
+	r.meta.checked = true
+	r.meta.inUse = true
+}
+
+// SaveWithMeta saves the file for h like Save and stores meta in a separate
+// metadata file, which can be read with LoadMeta. The metadata is written
+// once the file has been saved, so that the metadata of an existing file is
+// kept if saving fails. If writing the metadata fails, the file keeps its
+// previous metadata, if any.
+func (r *SFTP) SaveWithMeta(ctx context.Context, h restic.Handle, rd restic.RewindReader, meta map[string]string) error {
+	debug.Log("SaveWithMeta %v%v", h, r.tagInfo())
+	if err := r.checkReadOnly("save"); err != nil {
+		return err
+	}
+
+	if err := h.Valid(); err != nil {
+		return backoff.Permanent(err)
+	}
+	if err := r.checkName(h); err != nil {
+		return err
+	}
+
+	buf, err := json.Marshal(meta)
+	if err != nil {
+		return backoff.Permanent(errors.Wrap(err, "json.Marshal"))
+	}
+
+	err = r.Save(ctx, h, rd)
+	if err != nil {
+		return err
+	}
+
+	r.markMetaInUse()
+	r.sem.GetToken()
+	err = r.writeSidecar(h, r.metaFilename(h), buf)
+	r.sem.ReleaseToken()
+	return errors.Wrap(err, "SaveMeta")
+}
+
+// LoadMeta returns the metadata stored for h by SaveWithMeta. If the file
+// exists but has no metadata, nil is returned. If the file does not exist, an
+// error for which IsNotExist returns true is returned.
+func (r *SFTP) LoadMeta(ctx context.Context, h restic.Handle) (map[string]string, error) {
+	debug.Log("LoadMeta %v%v", h, r.tagInfo())
+	if err := r.clientError(ctx); err != nil {
+		return nil, err
+	}
+	if err := h.Valid(); err != nil {
+		return nil, backoff.Permanent(err)
+	}
+
+	r.sem.GetToken()
+	defer r.sem.ReleaseToken()
+
+	f, err := r.client().Open(r.metaFilename(h))
+	if r.IsNotExist(err) {
+		_, err = r.client().Lstat(r.Filename(h))
+		if err != nil {
+			return nil, errors.Wrap(err, "Lstat")
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Open")
+	}
+
+	buf, err := io.ReadAll(io.LimitReader(f, maxMetaSize+1))
+	_ = f.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "Read")
+	}
+	if len(buf) > maxMetaSize {
+		return nil, backoff.Permanent(errors.Errorf("metadata file for %v is larger than %d bytes", h, maxMetaSize))
+	}
+
+	var meta map[string]string
+	err = json.Unmarshal(buf, &meta)
+	if err != nil {
+		return nil, backoff.Permanent(errors.Wrapf(err, "invalid metadata file for %v", h))
+	}
+	return meta, nil
+}
+
+// removeMeta removes the metadata file for h, if it exists. Errors are only
+// logged, a stale metadata file is replaced when the file is saved again.
+func (r *SFTP) removeMeta(h restic.Handle) {
+	err := r.client().Remove(r.metaFilename(h))
+	if err != nil && !r.IsNotExist(err) {
+		debug.Log("unable to remove metadata for %v: %v", h, err)
+	}
+}
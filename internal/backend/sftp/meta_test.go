@@ -0,0 +1,107 @@
+package sftp
+
+import (
+	"context"
+	"testing"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestSaveWithMeta(t *testing.T) {
+	be := newTestBackend(t, newTestConfig(t))
+
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	meta := map[string]string{"retention": "long", "owner": "backup"}
+	rtest.OK(t, be.SaveWithMeta(context.TODO(), h, restic.NewByteReader(data, nil), meta))
+
+	loaded, err := be.LoadMeta(context.TODO(), h)
+	rtest.OK(t, err)
+	rtest.Equals(t, meta, loaded)
+
+	// the file itself is unchanged and the metadata file is not listed
+	buf, err := backend.LoadAll(context.TODO(), nil, be, h)
+	rtest.OK(t, err)
+	rtest.Equals(t, data, buf)
+
+	var names []string
+	rtest.OK(t, be.List(context.TODO(), restic.PackFile, func(fi restic.FileInfo) error {
+		names = append(names, fi.Name)
+		return nil
+	}))
+	rtest.Equals(t, []string{h.Name}, names)
+
+	// saving again replaces the metadata
+	meta = map[string]string{"retention": "short"}
+	rtest.OK(t, be.SaveWithMeta(context.TODO(), h, restic.NewByteReader(data, nil), meta))
+	loaded, err = be.LoadMeta(context.TODO(), h)
+	rtest.OK(t, err)
+	rtest.Equals(t, meta, loaded)
+
+	// the metadata is removed together with the file
+	rtest.OK(t, be.Remove(context.TODO(), h))
+	_, err = be.Stat(context.TODO(), h)
+	rtest.Assert(t, be.IsNotExist(err), "file still exists: %v", err)
+	_, err = be.client().Lstat(be.metaFilename(h))
+	rtest.Assert(t, be.IsNotExist(err), "metadata file still exists: %v", err)
+}
+
+func TestLoadMetaMissing(t *testing.T) {
+	be := newTestBackend(t, newTestConfig(t))
+
+	// a file without metadata
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	saveFile(t, be, h, data)
+
+	meta, err := be.LoadMeta(context.TODO(), h)
+	rtest.OK(t, err)
+	rtest.Assert(t, meta == nil, "unexpected metadata %v", meta)
+
+	// a missing file
+	h = restic.Handle{Type: restic.PackFile, Name: restic.NewRandomID().String()}
+	_, err = be.LoadMeta(context.TODO(), h)
+	rtest.Assert(t, be.IsNotExist(err), "expected not exist error, got %v", err)
+}
+
+func TestSaveWithMetaFailed(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.OverwritePolicy = OverwritePolicyError
+	be := newTestBackend(t, cfg)
+
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	meta := map[string]string{"a": "b"}
+	rtest.OK(t, be.SaveWithMeta(context.TODO(), h, restic.NewByteReader(data, nil), meta))
+
+	// the metadata is kept if the file cannot be saved
+	err := be.SaveWithMeta(context.TODO(), h, restic.NewByteReader(data, nil), map[string]string{"c": "d"})
+	rtest.Assert(t, err != nil, "file was overwritten")
+	loaded, err := be.LoadMeta(context.TODO(), h)
+	rtest.OK(t, err)
+	rtest.Equals(t, meta, loaded)
+}
+
+func TestMetaInUse(t *testing.T) {
+	cfg := newTestConfig(t)
+	be := newTestBackend(t, cfg)
+	rtest.Assert(t, !be.metaInUse(), "metadata in use in a new repository")
+
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	rtest.OK(t, be.SaveWithMeta(context.TODO(), h, restic.NewByteReader(data, nil), map[string]string{"a": "b"}))
+	rtest.Assert(t, be.metaInUse(), "metadata not in use after SaveWithMeta")
+
+	// the metadata directory is found by other processes
+	be2, err := Open(context.TODO(), cfg)
+	rtest.OK(t, err)
+	defer func() {
+		rtest.OK(t, be2.Close())
+	}()
+	rtest.Assert(t, be2.metaInUse(), "metadata directory not detected")
+	rtest.OK(t, be2.Remove(context.TODO(), h))
+	_, err = be2.client().Lstat(be2.metaFilename(h))
+	rtest.Assert(t, be2.IsNotExist(err), "metadata file still exists: %v", err)
+}
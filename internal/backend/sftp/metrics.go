@@ -0,0 +1,76 @@
+package sftp
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/restic/restic/internal/restic"
+)
+
+// Directions of a transfer.
+const (
+	Upload   = "upload"
+	Download = "download"
+)
+
+// TransferMetric describes the data transferred by a single Save or Load,
+// see Config.Metrics.
+type TransferMetric struct {
+	Direction string
+	Object    string
+	Bytes     int64
+	Duration  time.Duration
+	Err       error
+	Tag       string
+}
+
+// reportTransfer passes the metric for a completed transfer to the
+// configured sink, if there is one.
+func (r *SFTP) reportTransfer(direction string, h restic.Handle, bytes int64, start time.Time, err error) {
+	r.Config.Metrics(TransferMetric{
+		Direction: direction,
+		Object:    objectName(h),
+		Bytes:     bytes,
+		Duration:  clockFor(r.Config).Now().Sub(start),
+		Err:       err,
+		Tag:       r.tag,
+	})
+}
+
+// countingReader counts the bytes read from the underlying reader.
+type countingReader struct {
+	io.ReadCloser
+	n *int64
+}
+
+func (rd *countingReader) Read(p []byte) (int, error) {
+	n, err := rd.ReadCloser.Read(p)
+	atomic.AddInt64(rd.n, int64(n))
+	return n, err
+}
+
+func (rd *countingReader) WriteTo(w io.Writer) (int64, error) {
+	var n int64
+	var err error
+	if wt, ok := rd.ReadCloser.(io.WriterTo); ok {
+		n, err = wt.WriteTo(w)
+	} else {
+		n, err = io.Copy(w, struct{ io.Reader }{rd.ReadCloser})
+	}
+	atomic.AddInt64(rd.n, n)
+	return n, err
+}
+
+// countingOpenReader returns a function like openReader, which counts the
+// bytes read from all returned readers in n.
+func (r *SFTP) countingOpenReader(n *int64) func(context.Context, restic.Handle, int, int64) (io.ReadCloser, error) {
+	return func(ctx context.Context, h restic.Handle, length int, offset int64) (io.ReadCloser, error) {
+		rd, err := r.loadReader(ctx, h, length, offset)
+		if err != nil {
+			return nil, err
+		}
+		return &countingReader{ReadCloser: rd, n: n}, nil
+	}
+}
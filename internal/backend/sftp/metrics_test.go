@@ -0,0 +1,110 @@
+package sftp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+type fakeMetricsSink struct {
+	m       sync.Mutex
+	metrics []TransferMetric
+}
+
+func (s *fakeMetricsSink) observe(m TransferMetric) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.metrics = append(s.metrics, m)
+}
+
+func (s *fakeMetricsSink) reset() []TransferMetric {
+	s.m.Lock()
+	defer s.m.Unlock()
+	metrics := s.metrics
+	s.metrics = nil
+	return metrics
+}
+
+func TestMetrics(t *testing.T) {
+	var sink fakeMetricsSink
+	cfg := newTestConfig(t)
+	cfg.Metrics = sink.observe
+	be := newTestBackend(t, cfg)
+
+	data := rtest.Random(42, 100000)
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	saveFile(t, be, h, data)
+
+	metrics := sink.reset()
+	rtest.Equals(t, 1, len(metrics))
+	rtest.Equals(t, Upload, metrics[0].Direction)
+	rtest.Equals(t, objectName(h), metrics[0].Object)
+	rtest.Equals(t, int64(len(data)), metrics[0].Bytes)
+	rtest.Assert(t, metrics[0].Duration > 0, "no duration recorded")
+	rtest.OK(t, metrics[0].Err)
+
+	buf, err := backend.LoadAll(context.TODO(), nil, be, h)
+	rtest.OK(t, err)
+	rtest.Equals(t, data, buf)
+
+	metrics = sink.reset()
+	rtest.Equals(t, 1, len(metrics))
+	rtest.Equals(t, Download, metrics[0].Direction)
+	rtest.Equals(t, int64(len(data)), metrics[0].Bytes)
+	rtest.Assert(t, metrics[0].Duration > 0, "no duration recorded")
+
+	// partial loads count the bytes actually read
+	err = be.Load(context.TODO(), h, 1000, 500, func(rd io.Reader) error {
+		_, err := io.Copy(io.Discard, rd)
+		return err
+	})
+	rtest.OK(t, err)
+
+	metrics = sink.reset()
+	rtest.Equals(t, 1, len(metrics))
+	rtest.Equals(t, int64(1000), metrics[0].Bytes)
+
+	// failed operations are reported with the error
+	missing := restic.Handle{Type: restic.PackFile, Name: restic.NewRandomID().String()}
+	err = be.Load(context.TODO(), missing, 0, 0, func(rd io.Reader) error { return nil })
+	rtest.Assert(t, err != nil, "expected error for missing file")
+
+	metrics = sink.reset()
+	rtest.Equals(t, 1, len(metrics))
+	rtest.Equals(t, int64(0), metrics[0].Bytes)
+	rtest.Assert(t, metrics[0].Err != nil, "missing error in metric")
+}
+
+func TestMetricsUploadedBytes(t *testing.T) {
+	var sink fakeMetricsSink
+	cfg := newTestConfig(t)
+	cfg.Metrics = sink.observe
+	cfg.CompressTypes = []restic.FileType{restic.IndexFile}
+	cfg.OverwritePolicy = OverwritePolicySkip
+	be := newTestBackend(t, cfg)
+
+	// the compressed data is reported
+	data := bytes.Repeat([]byte("foobar"), 10000)
+	h := restic.Handle{Type: restic.IndexFile, Name: restic.Hash(data).String()}
+	saveFile(t, be, h, data)
+
+	stored, err := os.ReadFile(be.Filename(h))
+	rtest.OK(t, err)
+	metrics := sink.reset()
+	rtest.Equals(t, 1, len(metrics))
+	rtest.Equals(t, int64(len(stored)), metrics[0].Bytes)
+
+	// nothing is transferred for a skipped file
+	saveFile(t, be, h, data)
+	metrics = sink.reset()
+	rtest.Equals(t, 1, len(metrics))
+	rtest.Equals(t, int64(0), metrics[0].Bytes)
+	rtest.OK(t, metrics[0].Err)
+}
@@ -0,0 +1,141 @@
+package sftp
+
+import (
+	"context"
+	"hash"
+	"io"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// Mirror is a backend which applies all modifications to a primary and a
+// secondary sftp backend. All read operations use the primary backend only.
+//
+// A file is only saved successfully if it was saved to both backends. When
+// saving to the secondary backend fails, the file is removed from the primary
+// backend again, unless it already existed there before. Removing a file is attempted on both backends, an error from
+// either one is returned. There is no coordination between concurrent
+// operations on the two backends, so after a failed operation (or a crash)
+// the backends may temporarily differ.
+//
+// Only the methods of restic.Backend are provided. The other methods of SFTP
+// which modify the repository are not mirrored and therefore not available.
+type Mirror struct {
+	primary   *SFTP
+	secondary *SFTP
+}
+
+var _ restic.Backend = &Mirror{}
+
+// NewMirror returns a backend which mirrors all modifications of primary to
+// secondary.
+func NewMirror(primary, secondary *SFTP) *Mirror {
+	return &Mirror{primary: primary, secondary: secondary}
+}
+
+// Location returns the location of the primary backend.
+func (m *Mirror) Location() string {
+	return m.primary.Location()
+}
+
+// Connections returns the number of concurrent connections of the primary
+// backend.
+func (m *Mirror) Connections() uint {
+	return m.primary.Connections()
+}
+
+// Hasher may return a hash function for calculating a content hash for the backend
+func (m *Mirror) Hasher() hash.Hash {
+	return nil
+}
+
+// HasAtomicReplace returns whether Save() can atomically replace files on
+// both backends.
+func (m *Mirror) HasAtomicReplace() bool {
+	return m.primary.HasAtomicReplace() && m.secondary.HasAtomicReplace()
+}
+
+// IsNotExist returns true if the error is caused by a not existing file.
+func (m *Mirror) IsNotExist(err error) bool {
+	return m.primary.IsNotExist(err)
+}
+
+// Load runs fn with a reader that yields the contents of the file at h at the
+// given offset on the primary backend.
+func (m *Mirror) Load(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+	return m.primary.Load(ctx, h, length, offset, fn)
+}
+
+// Stat returns information about the file on the primary backend.
+func (m *Mirror) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+	return m.primary.Stat(ctx, h)
+}
+
+// List runs fn for each file of type t on the primary backend.
+func (m *Mirror) List(ctx context.Context, t restic.FileType, fn func(restic.FileInfo) error) error {
+	return m.primary.List(ctx, t, fn)
+}
+
+// Save stores data in both backends at the handle.
+func (m *Mirror) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	// only remove the file from the primary backend if this call created it,
+	// existing files such as the config must never be removed by a rollback
+	_, err := m.primary.Stat(ctx, h)
+	created := m.primary.IsNotExist(err)
+
+	err = m.primary.Save(ctx, h, rd)
+	if err != nil {
+		return err
+	}
+
+	err = rd.Rewind()
+	if err == nil {
+		err = m.secondary.Save(ctx, h, rd)
+	}
+	if err != nil {
+		if !created {
+			debug.Log("saving %v to mirror failed, keeping existing file on primary: %v", h, err)
+			return errors.Wrap(err, "mirror")
+		}
+
+		debug.Log("saving %v to mirror failed, removing it from primary: %v", h, err)
+		if rmErr := m.primary.Remove(ctx, h); rmErr != nil {
+			debug.Log("unable to remove %v from primary: %v", h, rmErr)
+		}
+		return errors.Wrap(err, "mirror")
+	}
+
+	return nil
+}
+
+// Remove removes the file from both backends.
+func (m *Mirror) Remove(ctx context.Context, h restic.Handle) error {
+	err := m.primary.Remove(ctx, h)
+	mirrorErr := m.secondary.Remove(ctx, h)
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(mirrorErr, "mirror")
+}
+
+// Delete removes all data in both backends.
+func (m *Mirror) Delete(ctx context.Context) error {
+	err := m.primary.Delete(ctx)
+	mirrorErr := m.secondary.Delete(ctx)
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(mirrorErr, "mirror")
+}
+
+// Close closes the connections to both backends.
+func (m *Mirror) Close() error {
+	err := m.primary.Close()
+	mirrorErr := m.secondary.Close()
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(mirrorErr, "mirror")
+}
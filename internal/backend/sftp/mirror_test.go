@@ -0,0 +1,80 @@
+package sftp
+
+import (
+	"context"
+	"testing"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestMirror(t *testing.T) {
+	primary := newTestBackend(t, newTestConfig(t))
+	secondary := newTestBackend(t, newTestConfig(t))
+	m := NewMirror(primary, secondary)
+
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	rtest.OK(t, m.Save(context.TODO(), h, restic.NewByteReader(data, nil)))
+
+	for _, be := range []*SFTP{primary, secondary} {
+		buf, err := backend.LoadAll(context.TODO(), nil, be, h)
+		rtest.OK(t, err)
+		rtest.Equals(t, data, buf)
+	}
+
+	rtest.OK(t, m.Remove(context.TODO(), h))
+	for _, be := range []*SFTP{primary, secondary} {
+		_, err := be.Stat(context.TODO(), h)
+		rtest.Assert(t, be.IsNotExist(err), "file still exists after Remove: %v", err)
+	}
+}
+
+func TestMirrorFailure(t *testing.T) {
+	primary := newTestBackend(t, newTestConfig(t))
+	secondary, err := Create(context.TODO(), newTestConfig(t))
+	rtest.OK(t, err)
+	m := NewMirror(primary, secondary)
+
+	killServer(t, secondary)
+
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	err = m.Save(context.TODO(), h, restic.NewByteReader(data, nil))
+	rtest.Assert(t, err != nil, "expected an error when the mirror fails")
+
+	// the file must have been removed from the primary again
+	_, err = primary.Stat(context.TODO(), h)
+	rtest.Assert(t, primary.IsNotExist(err), "file still exists on primary: %v", err)
+
+	// ignore the error as the server has been killed
+	_ = secondary.Close()
+}
+
+func TestMirrorFailureExistingFile(t *testing.T) {
+	primary := newTestBackend(t, newTestConfig(t))
+	secondary, err := Create(context.TODO(), newTestConfig(t))
+	rtest.OK(t, err)
+	m := NewMirror(primary, secondary)
+
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.ConfigFile}
+	saveFile(t, primary, h, data)
+
+	killServer(t, secondary)
+
+	for _, policy := range []string{OverwritePolicySkip, OverwritePolicyOverwrite} {
+		ctx := WithOverwritePolicy(context.TODO(), policy)
+		err = m.Save(ctx, h, restic.NewByteReader(data, nil))
+		rtest.Assert(t, err != nil, "expected an error when the mirror fails")
+
+		// the file existed before and must be kept on the primary
+		buf, err := backend.LoadAll(context.TODO(), nil, primary, h)
+		rtest.OK(t, err)
+		rtest.Equals(t, data, buf)
+	}
+
+	// ignore the error as the server has been killed
+	_ = secondary.Close()
+}
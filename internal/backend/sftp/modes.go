@@ -0,0 +1,116 @@
+package sftp
+
+import (
+	"os"
+	"path"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+)
+
+// groupSharedModes are the permissions used for repositories which are shared
+// by the members of a group. New directories inherit the group of their parent
+// directory because of the setgid bit.
+var groupSharedModes = backend.Modes{Dir: 0775 | os.ModeSetgid, File: 0640}
+
+// validModes checks that cfg.FileMode and cfg.DirMode only contain
+// permission bits.
+func validModes(cfg Config) error {
+	for _, mode := range []uint{cfg.FileMode, cfg.DirMode} {
+		if mode&^uint(os.ModePerm) != 0 {
+			return errors.Errorf("invalid mode %#o, only permission bits are allowed", mode)
+		}
+	}
+	return nil
+}
+
+// configuredModes returns m with the permissions replaced by cfg.FileMode and
+// cfg.DirMode, if they are set. Other bits such as setgid are kept.
+func configuredModes(cfg Config, m backend.Modes) backend.Modes {
+	if cfg.FileMode != 0 {
+		m.File = m.File&^os.ModePerm | os.FileMode(cfg.FileMode)
+	}
+	if cfg.DirMode != 0 {
+		m.Dir = m.Dir&^os.ModePerm | os.FileMode(cfg.DirMode)
+	}
+	return m
+}
+
+// chmodDir sets the permissions of a newly created directory, if the
+// repository is shared by a group or DirMode is set. Otherwise, the server's
+// default permissions are kept.
+func (r *SFTP) chmodDir(dir string) error {
+	if !r.Config.GroupShared && r.Config.DirMode == 0 {
+		return nil
+	}
+
+	return errors.Wrap(r.client().Chmod(dir, r.Modes.Dir), "Chmod")
+}
+
+// mkdirAll creates dir and its missing parent directories like MkdirAll of
+// the sftp client, and sets the permissions of each directory it created
+// with chmodDir.
+func (r *SFTP) mkdirAll(dir string) error {
+	if !r.Config.GroupShared && r.Config.DirMode == 0 {
+		return errors.Wrap(r.client().MkdirAll(dir), "MkdirAll")
+	}
+
+	// collect the missing directories, starting at dir
+	var missing []string
+	for d := dir; ; d = path.Dir(d) {
+		fi, err := r.client().Lstat(d)
+		if err == nil {
+			if !fi.IsDir() {
+				return errors.Errorf("%v is not a directory", d)
+			}
+			break
+		}
+		if !r.IsNotExist(err) {
+			return errors.Wrap(err, "Lstat")
+		}
+		missing = append(missing, d)
+		if path.Dir(d) == d {
+			break
+		}
+	}
+
+	for i := len(missing) - 1; i >= 0; i-- {
+		d := missing[i]
+		if err := r.client().Mkdir(d); err != nil {
+			// the directory may have been created concurrently
+			fi, statErr := r.client().Lstat(d)
+			if statErr != nil || !fi.IsDir() {
+				return errors.Wrap(err, "Mkdir")
+			}
+			continue
+		}
+		if err := r.chmodDir(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkSetgid checks that the setgid bit is set on dir. If the server does not
+// support it, it is removed from the directory mode.
+func (r *SFTP) checkSetgid(dir string) error {
+	if r.Modes.Dir&os.ModeSetgid == 0 {
+		return nil
+	}
+
+	fi, err := r.client().Lstat(dir)
+	if r.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "Lstat")
+	}
+
+	if fi.Mode()&os.ModeSetgid == 0 {
+		debug.Log("setgid bit is not set on %v, the server does not honor it", dir)
+		r.Modes.Dir &^= os.ModeSetgid
+	}
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package sftp
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestGroupShared(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.GroupShared = true
+	be := newTestBackend(t, cfg)
+
+	// the test server may not support setgid
+	setgid := be.Modes.Dir & os.ModeSetgid
+	wantDir := os.ModeDir | 0775 | setgid
+
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+
+	// saving to a missing subdirectory creates it with the shared mode
+	rtest.OK(t, be.client().RemoveDirectory(be.Dirname(h)))
+	saveFile(t, be, h, data)
+
+	for _, dir := range []string{be.Location(), be.Join(be.Location(), "data"), be.Join(be.Location(), "snapshots"), be.Dirname(h)} {
+		fi, err := be.client().Lstat(dir)
+		rtest.OK(t, err)
+		rtest.Equals(t, wantDir, fi.Mode())
+	}
+
+	fi, err := be.client().Lstat(be.Filename(h))
+	rtest.OK(t, err)
+	rtest.Equals(t, os.FileMode(0640), fi.Mode())
+
+	// the shared modes are also used after opening the repository
+	be2, err := Open(context.TODO(), cfg)
+	rtest.OK(t, err)
+	rtest.Equals(t, groupSharedModes.File, be2.Modes.File)
+	rtest.Equals(t, 0775|setgid, be2.Modes.Dir)
+	rtest.OK(t, be2.Close())
+}
+
+func TestConfiguredModes(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.FileMode = 0604
+	cfg.DirMode = 0705
+	// all missing parent directories are created with the mode
+	parent := filepath.Join(t.TempDir(), "a")
+	cfg.Path = filepath.Join(parent, "b", "repo")
+	be := newTestBackend(t, cfg)
+
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	rtest.OK(t, be.client().RemoveDirectory(be.Dirname(h)))
+	saveFile(t, be, h, data)
+
+	for _, dir := range []string{parent, filepath.Dir(cfg.Path), be.Location(), be.Join(be.Location(), "snapshots"), be.Dirname(h)} {
+		fi, err := be.client().Lstat(dir)
+		rtest.OK(t, err)
+		rtest.Equals(t, os.ModeDir|0705, fi.Mode())
+	}
+
+	fi, err := be.client().Lstat(be.Filename(h))
+	rtest.OK(t, err)
+	rtest.Equals(t, os.FileMode(0604), fi.Mode())
+
+	// the modes are also used after opening the repository
+	be2, err := Open(context.TODO(), cfg)
+	rtest.OK(t, err)
+	rtest.Equals(t, os.FileMode(0604), be2.Modes.File)
+	rtest.Equals(t, os.FileMode(0705), be2.Modes.Dir)
+	rtest.OK(t, be2.Close())
+
+	cfg = newTestConfig(t)
+	cfg.FileMode = 04755
+	_, err = Create(context.TODO(), cfg)
+	rtest.Assert(t, err != nil, "invalid file mode accepted")
+}
@@ -0,0 +1,94 @@
+package sftp
+
+import (
+	"io"
+	"os"
+	"strings"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+
+	"github.com/pkg/sftp"
+)
+
+// isCrossDevice returns true if err reports that a file could not be renamed
+// because the source and the target are on different file systems. Servers
+// which only report a generic failure are not detected.
+func isCrossDevice(err error) bool {
+	var se *sftp.StatusError
+	if !errors.As(err, &se) {
+		return false
+	}
+
+	msg := strings.ToLower(se.Error())
+	return strings.Contains(msg, "cross-device") || strings.Contains(msg, "exdev")
+}
+
+// moveFile renames src, which holds the data of h, to dst. When the server
+// cannot rename the file because dst is on a different file system, the file
+// is copied to a temporary file in the directory of dst, which is then
+// renamed to dst, so that dst is never incomplete. Finally, src is removed.
+func (r *SFTP) moveFile(h restic.Handle, src, dst string) error {
+	err := r.client().Rename(src, dst)
+	if !isCrossDevice(err) {
+		return err
+	}
+
+	debug.Log("rename %v to %v across file systems, copying: %v", src, dst, err)
+	tmpFilename := r.tempFilenameFor(h, dst)
+	r.journalAdd(tmpFilename)
+	defer r.journalRemove(tmpFilename)
+
+	err = r.copyFile(src, tmpFilename)
+	if err != nil {
+		return err
+	}
+
+	err = r.client().Rename(tmpFilename, dst)
+	if err != nil {
+		_ = r.client().Remove(tmpFilename)
+		return errors.Wrap(err, "Rename")
+	}
+
+	return errors.Wrap(r.client().Remove(src), "Remove")
+}
+
+// copyFile copies src to the new file dst, which has the same permissions.
+func (r *SFTP) copyFile(src, dst string) (err error) {
+	in, err := r.client().Open(src)
+	if err != nil {
+		return errors.Wrap(err, "Open")
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+
+	fi, err := in.Stat()
+	if err != nil {
+		return errors.Wrap(err, "Stat")
+	}
+
+	out, err := r.client().OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY)
+	if err != nil {
+		return errors.Wrap(err, "OpenFile")
+	}
+	defer func() {
+		if err != nil {
+			_ = out.Close()
+			_ = r.client().Remove(dst)
+		}
+	}()
+
+	_, err = io.Copy(out, in)
+	if err != nil {
+		return errors.Wrap(err, "Copy")
+	}
+
+	err = out.Chmod(fi.Mode().Perm())
+	if err != nil {
+		return errors.Wrap(err, "Chmod")
+	}
+
+	return errors.Wrap(out.Close(), "Close")
+}
@@ -0,0 +1,63 @@
+package sftp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestMoveFileCrossDevice(t *testing.T) {
+	var tempNames []string
+	cfg := fakeServerConfig(faultCrossDevice)
+	cfg.TrashDir = "trash"
+	cfg.TempJournal = true
+	cfg.TempNameFunc = func(h restic.Handle) string {
+		name := fmt.Sprintf("test%d", len(tempNames))
+		tempNames = append(tempNames, name)
+		return name
+	}
+	be, err := Create(context.TODO(), cfg)
+	rtest.OK(t, err)
+	defer func() {
+		rtest.OK(t, be.Close())
+	}()
+
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	saveFile(t, be, h, data)
+
+	// make sure that renaming to the trash directory fails
+	rtest.OK(t, be.client().MkdirAll(be.trashDir()))
+	err = be.client().Rename(be.Filename(h), be.Join(be.trashDir(), "test"))
+	rtest.Assert(t, isCrossDevice(err), "expected cross-device error, got %v", err)
+
+	rtest.OK(t, be.Remove(context.TODO(), h))
+	// the copy in the trash directory is written to a temp file, which is
+	// recorded in the journal until it has been renamed
+	rtest.Equals(t, []string{"test0", "test1"}, tempNames)
+	rtest.Equals(t, 0, len(dirEntries(t, be, be.Join(be.Location(), journalDir))))
+
+	_, err = be.Stat(context.TODO(), h)
+	rtest.Assert(t, be.IsNotExist(err), "file still exists after Remove: %v", err)
+
+	trash := dirEntries(t, be, be.trashDir())
+	rtest.Equals(t, 1, len(trash))
+	rtest.Assert(t, strings.HasSuffix(trash[0], "-data-"+h.Name), "unexpected name in trash: %v", trash[0])
+
+	f, err := be.client().Open(be.Join(be.trashDir(), trash[0]))
+	rtest.OK(t, err)
+	buf, err := io.ReadAll(f)
+	rtest.OK(t, err)
+	rtest.OK(t, f.Close())
+	rtest.Equals(t, data, buf)
+}
+
+func TestIsCrossDevice(t *testing.T) {
+	rtest.Assert(t, !isCrossDevice(nil), "nil is a cross-device error")
+	rtest.Assert(t, !isCrossDevice(io.EOF), "io.EOF is a cross-device error")
+}
@@ -0,0 +1,27 @@
+package sftp
+
+import (
+	"regexp"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+// strictNameRe matches the names restic uses for all files except the config
+// file: the lowercase hex encoding of a SHA-256 hash.
+var strictNameRe = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// checkName returns a permanent error if Config.StrictNames is set and the
+// name of h does not follow the naming rules of restic for its type.
+func (r *SFTP) checkName(h restic.Handle) error {
+	if !r.Config.StrictNames || h.Type == restic.ConfigFile {
+		return nil
+	}
+
+	if !strictNameRe.MatchString(h.Name) {
+		return backoff.Permanent(errors.Errorf("invalid name %q for %v file, expected 64 lowercase hex characters", h.Name, h.Type))
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package sftp
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestStrictNames(t *testing.T) {
+	valid := restic.Hash([]byte("foo")).String()
+	names := []string{
+		strings.ToUpper(valid),
+		valid[:63],
+		valid + "0",
+		"foobar",
+		valid[:60] + "xyz0",
+	}
+
+	for _, strict := range []bool{false, true} {
+		cfg := newTestConfig(t)
+		cfg.StrictNames = strict
+		be := newTestBackend(t, cfg)
+
+		// valid names are always accepted
+		saveFile(t, be, restic.Handle{Type: restic.SnapshotFile, Name: valid}, []byte("foo"))
+		saveFile(t, be, restic.Handle{Type: restic.ConfigFile}, []byte("config"))
+
+		for _, name := range names {
+			h := restic.Handle{Type: restic.IndexFile, Name: name}
+			errs := []error{
+				be.Save(context.TODO(), h, restic.NewByteReader([]byte("foo"), nil)),
+				be.Load(context.TODO(), h, 0, 0, func(rd io.Reader) error {
+					_, err := io.ReadAll(rd)
+					return err
+				}),
+			}
+			_, err := be.Stat(context.TODO(), h)
+			errs = append(errs, err)
+			_, err = be.Peek(context.TODO(), h, 1)
+			errs = append(errs, err, be.Remove(context.TODO(), h))
+
+			for _, err := range errs {
+				if strict {
+					rtest.Assert(t, err != nil && strings.Contains(err.Error(), "invalid name"), "expected invalid name error for %q, got %v", name, err)
+				} else {
+					rtest.OK(t, err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package sftp
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// useNativeSSH returns true if the connection is established without running
+// an ssh program.
+func useNativeSSH(cfg Config) bool {
+	return cfg.IdentityFile != ""
+}
+
+// startNativeClient connects to the server using the SSH implementation of
+// golang.org/x/crypto/ssh and authenticates with the private key in
+// cfg.IdentityFile.
+func startNativeClient(cfg Config) (_ *SFTP, err error) {
+	if cfg.Command != "" {
+		return nil, errors.New("an identity file cannot be used with a custom command")
+	}
+	if cfg.ProxyJump != "" {
+		return nil, errors.New("an identity file cannot be used with a jump host")
+	}
+	if len(cfg.ExtraArgs) > 0 {
+		return nil, errors.New("an identity file cannot be used with extra arguments for ssh")
+	}
+
+	signer, err := loadIdentity(cfg.IdentityFile, cfg.Passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	hostKeyCallback, err := nativeHostKeyCallback(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	username := cfg.User
+	if username == "" {
+		u, err := user.Current()
+		if err != nil {
+			return nil, errors.Wrap(err, "user.Current")
+		}
+		username = u.Username
+	}
+
+	host, port := splitHostPort(cfg.Host, cfg.Port)
+	if port == "" {
+		port = "22"
+	}
+	addr := net.JoinHostPort(host, port)
+
+	release := globalConnLimiter.acquire()
+	defer func() {
+		if err != nil {
+			release()
+		}
+	}()
+
+	debug.Log("connect to %v as %v with identity %v", addr, username, cfg.IdentityFile)
+	sshClient, err := dialSSH(addr, &ssh.ClientConfig{
+		User:            username,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeyCallback,
+	}, cfg.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	// The sftp packet size is left at the default of pkg/sftp, which all
+	// servers accept. The SSH channel splits larger writes into packets of
+	// the maximum size advertised by the server, but does not expose that
+	// size, and pkg/sftp cannot query the limits of the sftp server.
+	client, err := startSession(cfg.Timeout, func() (*sftp.Client, error) {
+		return sftp.NewClient(sshClient)
+	}, func() {
+		_ = sshClient.Close()
+	})
+	if err != nil {
+		_ = sshClient.Close()
+		if errors.Is(err, ErrTimeout) {
+			return nil, err
+		}
+		return nil, errors.Errorf("unable to start the sftp session, error: %v", err)
+	}
+
+	// wait in a different goroutine
+	ch := newExitResult()
+	done := make(chan struct{})
+	if interval := keepaliveInterval(cfg); interval > 0 {
+		go sendKeepalives(sshClient, clockFor(cfg), interval, done)
+	}
+	go func() {
+		err := sshClient.Wait()
+		close(done)
+		debug.Log("ssh connection closed, err %v", err)
+		release()
+		ch.exit(errors.Wrap(err, "ssh connection closed"))
+	}()
+
+	_, posixRename := client.HasExtension("posix-rename@openssh.com")
+	conn := &connection{c: client, ssh: sshClient, result: ch, posixRename: posixRename}
+	return &SFTP{conn: conn, results: &resultWriter{}, Config: cfg}, nil
+}
+
+// defaultKeepaliveInterval is used if Config.KeepaliveInterval is zero.
+const defaultKeepaliveInterval = 15 * time.Second
+
+// keepaliveInterval returns the interval for sending keepalive requests, zero
+// if they are disabled.
+func keepaliveInterval(cfg Config) time.Duration {
+	switch {
+	case cfg.KeepaliveInterval < 0:
+		return 0
+	case cfg.KeepaliveInterval == 0:
+		return defaultKeepaliveInterval
+	}
+	return cfg.KeepaliveInterval
+}
+
+// sendKeepalives sends a keepalive request to the server every interval until
+// done is closed. The requests are sent regardless of other traffic: while a
+// file is saved the connection is silent whenever reading the data stalls,
+// and some servers and firewalls drop connections which have been silent for
+// too long, which then fails the write with "broken pipe".
+func sendKeepalives(c ssh.Conn, clk clock, interval time.Duration, done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case <-clk.After(interval):
+		}
+
+		// servers reply with a failure for unknown requests, which is fine
+		_, _, err := c.SendRequest("keepalive@openssh.com", true, nil)
+		if err != nil {
+			debug.Log("sending keepalive failed: %v", err)
+			return
+		}
+	}
+}
+
+// dialSSH connects to the SSH server at addr. A positive timeout limits the
+// time for establishing the connection including the SSH handshake.
+func dialSSH(addr string, config *ssh.ClientConfig, timeout time.Duration) (*ssh.Client, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, errors.Wrap(dialTimeoutError(timeout, err), "ssh.Dial")
+	}
+
+	deadline := time.Now().Add(timeout)
+	if timeout > 0 {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		_ = conn.Close()
+		if timeout > 0 && !time.Now().Before(deadline) {
+			// the ssh package does not wrap the network error
+			return nil, fmt.Errorf("%w: the SSH handshake took longer than %v: %v", ErrTimeout, timeout, err)
+		}
+		return nil, errors.Wrap(err, "ssh.Dial")
+	}
+
+	// the deadline must not apply to the established connection
+	_ = conn.SetDeadline(time.Time{})
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// loadIdentity reads the private key in filename, which is decrypted with
+// passphrase if it is not empty.
+func loadIdentity(filename, passphrase string) (ssh.Signer, error) {
+	buf, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "ReadFile")
+	}
+
+	var signer ssh.Signer
+	if passphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(buf, []byte(passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(buf)
+	}
+
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		return nil, errors.Errorf("identity file %v is encrypted, a passphrase is required", filename)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to parse identity file %v", filename)
+	}
+
+	return signer, nil
+}
+
+// nativeHostKeyCallback returns a callback which only accepts the host key
+// with the fingerprint cfg.HostKeyFingerprint. If it is empty, the host key
+// must be listed in cfg.KnownHostsFile, which defaults to the known_hosts
+// file of the user. If cfg.InsecureAcceptUnknownHostKeys is set, host keys
+// which are not listed are accepted, but changed host keys are still
+// rejected.
+func nativeHostKeyCallback(cfg Config) (ssh.HostKeyCallback, error) {
+	if fingerprint := cfg.HostKeyFingerprint; fingerprint != "" {
+		if err := validHostKeyFingerprint(fingerprint); err != nil {
+			return nil, err
+		}
+
+		return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			if fp := ssh.FingerprintSHA256(key); fp != fingerprint {
+				return errors.Errorf("host key fingerprint %v for %v does not match the expected fingerprint %v", fp, hostname, fingerprint)
+			}
+			return nil
+		}, nil
+	}
+
+	filename := cfg.KnownHostsFile
+	if filename == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, errors.Wrap(err, "UserHomeDir")
+		}
+		filename = filepath.Join(home, ".ssh", "known_hosts")
+	}
+
+	callback, err := knownhosts.New(filename)
+	if err != nil {
+		if !cfg.InsecureAcceptUnknownHostKeys || !errors.Is(err, os.ErrNotExist) {
+			return nil, errors.Errorf("unable to read known_hosts file, use -o sftp.host-key-fingerprint=<fingerprint> to specify the host key: %v", err)
+		}
+		debug.Log("known_hosts file %v does not exist, all host keys are accepted", filename)
+		callback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			return &knownhosts.KeyError{}
+		}
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := callback(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if err == nil || !errors.As(err, &keyErr) {
+			return err
+		}
+
+		fp := ssh.FingerprintSHA256(key)
+		if len(keyErr.Want) == 0 {
+			if cfg.InsecureAcceptUnknownHostKeys {
+				debug.Log("accepting unknown host key %v for %v", fp, hostname)
+				return nil
+			}
+			return errors.Errorf("host key %v for %v is unknown, it is not listed in %v", fp, hostname, filename)
+		}
+		return errors.Errorf("host key for %v has changed: the server presented %v, which does not match the key in %v", hostname, fp, filename)
+	}, nil
+}
@@ -0,0 +1,241 @@
+package sftp
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// writeIdentity writes a new private key to a file in dir and returns the
+// filename. If passphrase is not empty, the key is encrypted.
+func writeIdentity(t testing.TB, dir, passphrase string) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rtest.OK(t, err)
+	der, err := x509.MarshalECPrivateKey(key)
+	rtest.OK(t, err)
+
+	block := &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+	if passphrase != "" {
+		// legacy PEM encryption is insecure, but still supported by ssh
+		block, err = x509.EncryptPEMBlock(rand.Reader, block.Type, der, []byte(passphrase), x509.PEMCipherAES256)
+		rtest.OK(t, err)
+	}
+
+	filename := filepath.Join(dir, "id_ecdsa")
+	rtest.OK(t, os.WriteFile(filename, pem.EncodeToMemory(block), 0600))
+	return filename
+}
+
+func nativeTestConfig(t testing.TB, srv *testSSHServer) Config {
+	cfg := NewConfig()
+	cfg.Host = srv.Host
+	cfg.Port = srv.Port
+	cfg.Path = filepath.Join(t.TempDir(), "repo")
+	cfg.HostKeyFingerprint = srv.Fingerprint
+	return cfg
+}
+
+func TestNativeSSH(t *testing.T) {
+	srv := newTestSSHServer(t)
+
+	for _, passphrase := range []string{"", "secret"} {
+		cfg := nativeTestConfig(t, srv)
+		cfg.IdentityFile = writeIdentity(t, t.TempDir(), passphrase)
+		cfg.Passphrase = passphrase
+
+		be, err := Create(context.TODO(), cfg)
+		rtest.OK(t, err)
+
+		data := []byte("foobar")
+		h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+		saveFile(t, be, h, data)
+		rtest.OK(t, be.Close())
+
+		be, err = Open(context.TODO(), cfg)
+		rtest.OK(t, err)
+		fi, err := be.Stat(context.TODO(), h)
+		rtest.OK(t, err)
+		rtest.Equals(t, int64(len(data)), fi.Size)
+		rtest.OK(t, be.Close())
+	}
+}
+
+func TestNativeSSHErrors(t *testing.T) {
+	srv := newTestSSHServer(t)
+	dir := t.TempDir()
+	encrypted := writeIdentity(t, dir, "secret")
+
+	for _, test := range []struct {
+		name string
+		cfg  func(cfg *Config)
+		err  string
+	}{
+		{"missing-passphrase", func(cfg *Config) {
+			cfg.IdentityFile = encrypted
+		}, "passphrase is required"},
+		{"wrong-passphrase", func(cfg *Config) {
+			cfg.IdentityFile = encrypted
+			cfg.Passphrase = "wrong"
+		}, "unable to parse identity file"},
+		{"missing-file", func(cfg *Config) {
+			cfg.IdentityFile = filepath.Join(dir, "missing")
+		}, "ReadFile"},
+		{"host-key-mismatch", func(cfg *Config) {
+			cfg.IdentityFile = writeIdentity(t, t.TempDir(), "")
+			cfg.HostKeyFingerprint = "SHA256:" + strings.Repeat("A", 43)
+		}, "does not match"},
+		{"command", func(cfg *Config) {
+			cfg.IdentityFile = writeIdentity(t, t.TempDir(), "")
+			cfg.Command = "ssh foo"
+		}, "custom command"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := nativeTestConfig(t, srv)
+			test.cfg(&cfg)
+
+			_, err := Create(context.TODO(), cfg)
+			rtest.Assert(t, err != nil && strings.Contains(err.Error(), test.err), "expected error containing %q, got %v", test.err, err)
+		})
+	}
+}
+
+// writeKnownHosts writes a known_hosts file which lists key for the server
+// and returns its filename. If key is nil, the file is empty.
+func writeKnownHosts(t testing.TB, srv *testSSHServer, key ssh.PublicKey) string {
+	var line string
+	if key != nil {
+		addr := knownhosts.Normalize(net.JoinHostPort(srv.Host, srv.Port))
+		line = knownhosts.Line([]string{addr}, key) + "\n"
+	}
+
+	filename := filepath.Join(t.TempDir(), "known_hosts")
+	rtest.OK(t, os.WriteFile(filename, []byte(line), 0600))
+	return filename
+}
+
+func TestNativeSSHKnownHosts(t *testing.T) {
+	srv := newTestSSHServer(t)
+
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	rtest.OK(t, err)
+	otherSigner, err := ssh.NewSignerFromKey(otherKey)
+	rtest.OK(t, err)
+
+	for _, test := range []struct {
+		name       string
+		knownHosts string
+		insecure   bool
+		err        string
+	}{
+		{"known", writeKnownHosts(t, srv, srv.HostKey.PublicKey()), false, ""},
+		{"unknown", writeKnownHosts(t, srv, nil), false, "host key " + srv.Fingerprint + " for " + srv.Host},
+		{"changed", writeKnownHosts(t, srv, otherSigner.PublicKey()), false, "has changed: the server presented " + srv.Fingerprint},
+		{"missing", filepath.Join(t.TempDir(), "missing"), false, "unable to read known_hosts file"},
+		{"unknown-insecure", writeKnownHosts(t, srv, nil), true, ""},
+		{"changed-insecure", writeKnownHosts(t, srv, otherSigner.PublicKey()), true, "has changed: the server presented " + srv.Fingerprint},
+		{"missing-insecure", filepath.Join(t.TempDir(), "missing"), true, ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := nativeTestConfig(t, srv)
+			cfg.HostKeyFingerprint = ""
+			cfg.IdentityFile = writeIdentity(t, t.TempDir(), "")
+			cfg.KnownHostsFile = test.knownHosts
+			cfg.InsecureAcceptUnknownHostKeys = test.insecure
+
+			be, err := Create(context.TODO(), cfg)
+			if test.err == "" {
+				rtest.OK(t, err)
+				rtest.OK(t, be.Close())
+				return
+			}
+			rtest.Assert(t, err != nil && strings.Contains(err.Error(), test.err), "expected error containing %q, got %v", test.err, err)
+		})
+	}
+}
+
+// stallingReader returns data in chunks and waits for stall before each one.
+type stallingReader struct {
+	data  []byte
+	pos   int
+	chunk int
+	stall time.Duration
+}
+
+func (rd *stallingReader) Read(p []byte) (int, error) {
+	if rd.pos >= len(rd.data) {
+		return 0, io.EOF
+	}
+	time.Sleep(rd.stall)
+	if len(p) > rd.chunk {
+		p = p[:rd.chunk]
+	}
+	n := copy(p, rd.data[rd.pos:])
+	rd.pos += n
+	return n, nil
+}
+
+func (rd *stallingReader) Rewind() error {
+	rd.pos = 0
+	return nil
+}
+
+func (rd *stallingReader) Length() int64 { return int64(len(rd.data)) }
+func (rd *stallingReader) Hash() []byte  { return nil }
+
+func TestNativeSSHKeepalive(t *testing.T) {
+	srv := newIdleTestSSHServer(t, 300*time.Millisecond)
+
+	for _, test := range []struct {
+		name     string
+		interval time.Duration
+		ok       bool
+	}{
+		{"keepalive", 50 * time.Millisecond, true},
+		{"disabled", -1, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := nativeTestConfig(t, srv)
+			cfg.IdentityFile = writeIdentity(t, t.TempDir(), "")
+			cfg.KeepaliveInterval = test.interval
+
+			be, err := Create(context.TODO(), cfg)
+			rtest.OK(t, err)
+			defer func() {
+				// ignore the error as the server may have dropped the connection
+				_ = be.Close()
+			}()
+
+			// the connection is silent while the reader stalls
+			data := rtest.Random(23, 3*1024)
+			h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+			rd := &stallingReader{data: data, chunk: 1024, stall: 600 * time.Millisecond}
+			err = be.Save(context.TODO(), h, rd)
+			if !test.ok {
+				rtest.Assert(t, err != nil, "slow write succeeded without keepalives")
+				return
+			}
+			rtest.OK(t, err)
+
+			fi, err := be.Stat(context.TODO(), h)
+			rtest.OK(t, err)
+			rtest.Equals(t, int64(len(data)), fi.Size)
+		})
+	}
+}
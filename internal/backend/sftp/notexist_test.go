@@ -0,0 +1,32 @@
+package sftp
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/restic/restic/internal/errors"
+	rtest "github.com/restic/restic/internal/test"
+
+	"github.com/pkg/sftp"
+)
+
+func TestIsNotExist(t *testing.T) {
+	be := &SFTP{}
+
+	for _, test := range []struct {
+		err      error
+		notExist bool
+	}{
+		{&sftp.StatusError{Code: uint32(sftp.ErrSSHFxNoSuchFile)}, true},
+		{errors.Wrap(&sftp.StatusError{Code: uint32(sftp.ErrSSHFxNoSuchFile)}, "Open"), true},
+		{fmt.Errorf("stat: %w", &sftp.StatusError{Code: uint32(sftp.ErrSSHFxNoSuchFile)}), true},
+		{&os.PathError{Op: "open", Path: "foo", Err: os.ErrNotExist}, true},
+		{errors.Wrap(os.ErrNotExist, "Lstat"), true},
+		{&sftp.StatusError{Code: uint32(sftp.ErrSSHFxPermissionDenied)}, false},
+		{errors.New(`sftp: "No such file" (SSH_FX_NO_SUCH_FILE)`), false},
+		{nil, false},
+	} {
+		rtest.Equals(t, test.notExist, be.IsNotExist(test.err))
+	}
+}
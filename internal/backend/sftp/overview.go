@@ -0,0 +1,63 @@
+package sftp
+
+import (
+	"context"
+	"sync"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/restic"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Overview returns the number of files of each type in the repository. The
+// types are listed concurrently. The count for restic.ConfigFile is 1 if the
+// config file exists and 0 otherwise.
+func (r *SFTP) Overview(ctx context.Context) (map[restic.FileType]int, error) {
+	debug.Log("Overview%v", r.tagInfo())
+
+	counts := make(map[restic.FileType]int)
+	var m sync.Mutex
+
+	wg, ctx := errgroup.WithContext(ctx)
+	for _, t := range []restic.FileType{restic.PackFile, restic.SnapshotFile, restic.IndexFile, restic.LockFile, restic.KeyFile} {
+		t := t
+		wg.Go(func() error {
+			n := 0
+			err := r.List(ctx, t, func(restic.FileInfo) error {
+				n++
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			m.Lock()
+			counts[t] = n
+			m.Unlock()
+			return nil
+		})
+	}
+
+	wg.Go(func() error {
+		exists := 0
+		_, err := r.Stat(ctx, restic.Handle{Type: restic.ConfigFile})
+		if err == nil {
+			exists = 1
+		} else if !r.IsNotExist(err) {
+			return err
+		}
+
+		m.Lock()
+		counts[restic.ConfigFile] = exists
+		m.Unlock()
+		return nil
+	})
+
+	err := wg.Wait()
+	if err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
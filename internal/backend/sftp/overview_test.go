@@ -0,0 +1,45 @@
+package sftp
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestOverview(t *testing.T) {
+	be := newTestBackend(t, newTestConfig(t))
+
+	counts, err := be.Overview(context.TODO())
+	rtest.OK(t, err)
+	rtest.Equals(t, map[restic.FileType]int{
+		restic.PackFile:     0,
+		restic.SnapshotFile: 0,
+		restic.IndexFile:    0,
+		restic.LockFile:     0,
+		restic.KeyFile:      0,
+		restic.ConfigFile:   0,
+	}, counts)
+
+	want := map[restic.FileType]int{
+		restic.PackFile:     7,
+		restic.SnapshotFile: 3,
+		restic.IndexFile:    2,
+		restic.LockFile:     1,
+		restic.KeyFile:      1,
+	}
+	for tpe, n := range want {
+		for i := 0; i < n; i++ {
+			data := []byte(fmt.Sprintf("%v file %d", tpe, i))
+			saveFile(t, be, restic.Handle{Type: tpe, Name: restic.Hash(data).String()}, data)
+		}
+	}
+	saveFile(t, be, restic.Handle{Type: restic.ConfigFile}, []byte("config"))
+	want[restic.ConfigFile] = 1
+
+	counts, err = be.Overview(context.TODO())
+	rtest.OK(t, err)
+	rtest.Equals(t, want, counts)
+}
@@ -0,0 +1,116 @@
+package sftp
+
+import (
+	"context"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+// Policies for saving a file which already exists, see
+// Config.OverwritePolicy.
+const (
+	// OverwritePolicyError makes Save fail if the file exists.
+	OverwritePolicyError = "error"
+	// OverwritePolicySkip makes Save return without writing the file if it
+	// exists.
+	OverwritePolicySkip = "skip"
+	// OverwritePolicyOverwrite makes Save replace the file atomically.
+	OverwritePolicyOverwrite = "overwrite"
+)
+
+// ErrFileExists is returned by Save if the file already exists and the
+// overwrite policy is OverwritePolicyError.
+var ErrFileExists = errors.New("file already exists")
+
+func validOverwritePolicy(policy string) error {
+	switch policy {
+	case "", OverwritePolicyError, OverwritePolicySkip, OverwritePolicyOverwrite:
+		return nil
+	}
+	return errors.Errorf("invalid overwrite policy %q, may be one of: %v, %v, %v",
+		policy, OverwritePolicyError, OverwritePolicySkip, OverwritePolicyOverwrite)
+}
+
+type overwritePolicyKey struct{}
+
+// WithOverwritePolicy returns a context which makes Save use policy instead of
+// Config.OverwritePolicy.
+func WithOverwritePolicy(ctx context.Context, policy string) context.Context {
+	return context.WithValue(ctx, overwritePolicyKey{}, policy)
+}
+
+// overwritePolicy returns the overwrite policy for a call to Save with ctx.
+func (r *SFTP) overwritePolicy(ctx context.Context) (string, error) {
+	policy := r.Config.OverwritePolicy
+	if p, ok := ctx.Value(overwritePolicyKey{}).(string); ok {
+		policy = p
+	}
+
+	if err := validOverwritePolicy(policy); err != nil {
+		return "", err
+	}
+
+	if policy == OverwritePolicyOverwrite && !r.HasAtomicReplace() {
+		return "", errors.New("server does not support atomic replace, which is required for overwriting files")
+	}
+
+	return policy, nil
+}
+
+// exists returns true if filename exists on the server.
+func (r *SFTP) exists(filename string) (bool, error) {
+	_, err := r.client().Lstat(filename)
+	if r.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// checkOverwrite checks whether filename may be saved according to policy. If
+// the file exists, skip is true for OverwritePolicySkip and an error wrapping
+// ErrFileExists is returned for OverwritePolicyError.
+func (r *SFTP) checkOverwrite(policy, filename string) (skip bool, err error) {
+	if policy != OverwritePolicyError && policy != OverwritePolicySkip {
+		return false, nil
+	}
+
+	exists, err := r.exists(filename)
+	if err != nil {
+		return false, errors.Wrap(err, "Lstat")
+	}
+	if exists && policy == OverwritePolicySkip {
+		debug.Log("%v already exists, skipping", filename)
+		return true, nil
+	}
+	if exists {
+		return false, backoff.Permanent(errors.Wrap(ErrFileExists, filename))
+	}
+	return false, nil
+}
+
+// rename moves tmpFilename to filename according to policy. For
+// OverwritePolicyError and OverwritePolicySkip, the server refuses to replace
+// an existing file.
+func (r *SFTP) rename(policy, tmpFilename, filename string) error {
+	switch policy {
+	case OverwritePolicyError, OverwritePolicySkip:
+		err := r.client().Rename(tmpFilename, filename)
+		if err != nil {
+			if exists, _ := r.exists(filename); exists {
+				return backoff.Permanent(errors.Wrap(ErrFileExists, filename))
+			}
+		}
+		return err
+	case OverwritePolicyOverwrite:
+		return r.client().PosixRename(tmpFilename, filename)
+	}
+
+	// Prefer POSIX atomic rename if available.
+	if r.HasAtomicReplace() {
+		return r.client().PosixRename(tmpFilename, filename)
+	}
+	return r.client().Rename(tmpFilename, filename)
+}
@@ -0,0 +1,73 @@
+package sftp
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestOverwritePolicy(t *testing.T) {
+	for _, test := range []struct {
+		policy string
+		want   string
+		err    error
+	}{
+		{"", "new", nil},
+		{OverwritePolicyError, "old", ErrFileExists},
+		{OverwritePolicySkip, "old", nil},
+		{OverwritePolicyOverwrite, "new", nil},
+	} {
+		t.Run(test.policy, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			cfg.OverwritePolicy = test.policy
+			be := newTestBackend(t, cfg)
+			if !be.HasAtomicReplace() && (test.policy == "" || test.policy == OverwritePolicyOverwrite) {
+				t.Skip("server does not support atomic replace")
+			}
+
+			h := restic.Handle{Type: restic.ConfigFile}
+			saveFile(t, be, h, []byte("old"))
+
+			err := be.Save(context.TODO(), h, restic.NewByteReader([]byte("new"), nil))
+			rtest.Assert(t, errors.Is(err, test.err), "unexpected error %v, want %v", err, test.err)
+
+			buf, err := backend.LoadAll(context.TODO(), nil, be, h)
+			rtest.OK(t, err)
+			rtest.Equals(t, test.want, string(buf))
+
+			// no temporary files are left behind
+			rtest.Equals(t, 6, len(dirEntries(t, be, be.Location())))
+		})
+	}
+}
+
+func TestOverwritePolicyPerCall(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.OverwritePolicy = OverwritePolicyError
+	be := newTestBackend(t, cfg)
+
+	h := restic.Handle{Type: restic.ConfigFile}
+	saveFile(t, be, h, []byte("old"))
+
+	ctx := WithOverwritePolicy(context.TODO(), OverwritePolicySkip)
+	rtest.OK(t, be.Save(ctx, h, restic.NewByteReader([]byte("new"), nil)))
+
+	ctx = WithOverwritePolicy(context.TODO(), "invalid")
+	err := be.Save(ctx, h, restic.NewByteReader([]byte("new"), nil))
+	rtest.Assert(t, err != nil, "expected error for invalid policy")
+
+	buf, err := backend.LoadAll(context.TODO(), nil, be, h)
+	rtest.OK(t, err)
+	rtest.Equals(t, "old", string(buf))
+}
+
+func TestOverwritePolicyInvalid(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.OverwritePolicy = "sometimes"
+	_, err := Create(context.TODO(), cfg)
+	rtest.Assert(t, err != nil, "expected error for invalid policy")
+}
@@ -0,0 +1,88 @@
+package sftp
+
+import (
+	"context"
+	"io"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// Peek returns the first n bytes of the file. If the file is shorter, the
+// returned slice contains the whole file and no error is returned.
+func (r *SFTP) Peek(ctx context.Context, h restic.Handle, n int) ([]byte, error) {
+	debug.Log("Peek %v, %v bytes%v", h, n, r.tagInfo())
+	if err := r.clientError(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := h.Valid(); err != nil {
+		return nil, backoff.Permanent(err)
+	}
+	if err := r.checkName(h); err != nil {
+		return nil, err
+	}
+
+	if n < 0 {
+		return nil, errors.New("length is negative")
+	}
+
+	if r.readTransform(h) != nil {
+		// transformed data cannot be read at an arbitrary position
+		return r.peekTransformed(ctx, h, n)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.sem.GetToken()
+	defer r.sem.ReleaseToken()
+
+	f, err := r.client().Open(r.Filename(h))
+	if err != nil {
+		return nil, err
+	}
+
+	// the read is aborted by closing the file when ctx is cancelled
+	stop := closeOnCancel(ctx, f)
+	buf := make([]byte, n)
+	m, err := f.ReadAt(buf, 0)
+	if stop() {
+		return nil, ctx.Err()
+	}
+	if err != nil && err != io.EOF {
+		_ = f.Close()
+		return nil, errors.Wrap(err, "ReadAt")
+	}
+
+	err = f.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "Close")
+	}
+
+	return buf[:m], nil
+}
+
+func (r *SFTP) peekTransformed(ctx context.Context, h restic.Handle, n int) ([]byte, error) {
+	rd, err := r.openReader(ctx, h, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err := io.ReadAll(backend.LimitReadCloser(rd, int64(n)))
+	if err != nil {
+		_ = rd.Close()
+		return nil, errors.Wrap(err, "Read")
+	}
+
+	err = rd.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "Close")
+	}
+
+	return buf, nil
+}
@@ -0,0 +1,60 @@
+package sftp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestPeek(t *testing.T) {
+	be := newTestBackend(t, newTestConfig(t))
+
+	for _, size := range []int{0, 1, 7, 8, 100, 5000} {
+		data := rtest.Random(size, size)
+		h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+		saveFile(t, be, h, data)
+
+		for _, n := range []int{0, 1, 8, 4096} {
+			t.Run(fmt.Sprintf("size-%d-peek-%d", size, n), func(t *testing.T) {
+				buf, err := be.Peek(context.TODO(), h, n)
+				rtest.OK(t, err)
+
+				want := data
+				if n < len(want) {
+					want = want[:n]
+				}
+				rtest.Assert(t, bytes.Equal(want, buf), "wrong data returned, want %d bytes, got %d", len(want), len(buf))
+			})
+		}
+	}
+
+	_, err := be.Peek(context.TODO(), restic.Handle{Type: restic.PackFile, Name: "missing"}, 8)
+	rtest.Assert(t, be.IsNotExist(err), "expected not-exist error, got %v", err)
+
+	_, err = be.Peek(context.TODO(), restic.Handle{Type: restic.PackFile}, 8)
+	rtest.Assert(t, err != nil, "expected an error for an invalid handle")
+}
+
+func TestPeekTransform(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.WriteTransform = func(wr io.Writer) io.Writer { return xorWriter{wr} }
+	cfg.ReadTransform = func(rd io.Reader) io.Reader { return xorReader{rd} }
+	be := newTestBackend(t, cfg)
+
+	data := rtest.Random(5, 100)
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	saveFile(t, be, h, data)
+
+	buf, err := be.Peek(context.TODO(), h, 8)
+	rtest.OK(t, err)
+	rtest.Equals(t, data[:8], buf)
+
+	buf, err = be.Peek(context.TODO(), h, 200)
+	rtest.OK(t, err)
+	rtest.Equals(t, data, buf)
+}
@@ -0,0 +1,186 @@
+package sftp
+
+import (
+	"context"
+	"io"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/pkg/sftp"
+)
+
+// Pipe copies the file src to dst on the server. If transform is nil, both
+// files are stored with the same transformation, see CompressTypes, and the
+// server supports the hardlink@openssh.com extension, the server creates dst
+// as a hard link to src, so that no data is transferred. Files in the
+// repository are never modified in place, so this is equivalent to a copy.
+// Otherwise, the data is loaded like Load, passed through transform if it is
+// set and saved like Save. As the size of the transformed data is not known
+// in advance, it is kept in memory. In both cases, the new file is moved into
+// place from a temporary file according to the overwrite policy and is made
+// read-only, for a hard link this applies to src as well.
+func (r *SFTP) Pipe(ctx context.Context, src, dst restic.Handle, transform func(io.Reader) io.Reader) error {
+	debug.Log("Pipe %v -> %v%v", src, dst, r.tagInfo())
+	if err := r.checkReadOnly("save"); err != nil {
+		return err
+	}
+
+	if err := r.clientError(ctx); err != nil {
+		return err
+	}
+
+	for _, h := range []restic.Handle{src, dst} {
+		if err := h.Valid(); err != nil {
+			return backoff.Permanent(err)
+		}
+		if err := r.checkName(h); err != nil {
+			return err
+		}
+	}
+
+	_, hasLink := r.client().HasExtension("hardlink@openssh.com")
+	if transform != nil || !hasLink || r.compressed(src.Type) != r.compressed(dst.Type) {
+		return classifyError(r.pipeData(ctx, src, dst, transform))
+	}
+
+	err := r.retryOnConnectionLoss(ctx, "save", objectName(dst), func() error {
+		return r.runWithTimeout(ctx, "save", func(ctx context.Context) error {
+			return r.linkFile(ctx, src, dst)
+		})
+	})
+	return classifyError(err)
+}
+
+// maxMkdirRetries is the number of times withDir creates the directory for a
+// file again.
+const maxMkdirRetries = 3
+
+// withDir runs fn, which creates a file in the directory for h. If the
+// directory does not exist, it is created and fn is run again. As Remove of
+// another process may remove the empty directory again before fn has created
+// the file, see Config.RemoveEmptyDirs, this is repeated a few times.
+func (r *SFTP) withDir(h restic.Handle, fn func() error) error {
+	err := fn()
+	for i := 0; r.IsNotExist(err) && i < maxMkdirRetries; i++ {
+		mkdirErr := r.mkdirAll(r.Dirname(h))
+		if mkdirErr != nil {
+			debug.Log("error creating dir %v: %v", r.Dirname(h), mkdirErr)
+			return err
+		}
+		err = fn()
+	}
+	return err
+}
+
+// pipeData loads src, passes it through transform and saves it as dst. The
+// transformations configured for src and dst are applied as for Load and
+// Save.
+func (r *SFTP) pipeData(ctx context.Context, src, dst restic.Handle, transform func(io.Reader) io.Reader) error {
+	var buf []byte
+	err := r.Load(ctx, src, 0, 0, func(rd io.Reader) (err error) {
+		if transform != nil {
+			rd = transform(rd)
+		}
+		buf, err = io.ReadAll(rd)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	rd := restic.NewByteReader(buf, nil)
+	return r.saveWithRetry(ctx, dst, rd, func(f *sftp.File, tmpFilename string) error {
+		if err := f.Chmod(r.Modes.File &^ 0222); err != nil {
+			return errors.Wrap(err, "Chmod")
+		}
+		if dst.Type == restic.ConfigFile {
+			return r.verifyContent(dst, tmpFilename, rd)
+		}
+		return nil
+	}, nil)
+}
+
+// linkFile creates a temporary file as a hard link to src and renames it to
+// the file for dst, like save does for the data it has written.
+func (r *SFTP) linkFile(ctx context.Context, src, dst restic.Handle) (err error) {
+	if err := r.clientError(ctx); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	policy, err := r.overwritePolicy(ctx)
+	if err != nil {
+		return backoff.Permanent(err)
+	}
+
+	filename := r.Filename(dst)
+	skip, err := r.checkOverwrite(policy, filename)
+	if err != nil || skip {
+		return err
+	}
+
+	tmpFilename := r.tempFilename(dst)
+
+	r.sem.GetToken()
+	defer r.sem.ReleaseToken()
+
+	leaked := false
+	r.journalAdd(tmpFilename)
+	defer func() {
+		if !leaked {
+			r.journalRemove(tmpFilename)
+		}
+	}()
+
+	c := r.transferClient(ctx)
+	err = r.withDir(dst, func() error {
+		return c.Link(r.Filename(src), tmpFilename)
+	})
+	err = r.checkTempCollision(tmpFilename, err)
+	if err != nil {
+		return errors.Wrap(err, "Link")
+	}
+
+	defer func() {
+		if err == nil {
+			return
+		}
+
+		// Try not to leave the link behind.
+		rmErr := r.client().Remove(tmpFilename)
+		if rmErr != nil {
+			debug.Log("sftp: failed to remove temp file %v: %v", tmpFilename, rmErr)
+			leaked = true
+		}
+	}()
+
+	err = r.client().Chmod(tmpFilename, r.Modes.File&^0222)
+	if err != nil {
+		return errors.Wrap(err, "Chmod")
+	}
+
+	err = r.rename(policy, tmpFilename, filename)
+	if errors.Is(err, ErrFileExists) && policy == OverwritePolicySkip {
+		// the file has been created concurrently, remove the temporary file
+		debug.Log("%v already exists, skipping", filename)
+		rmErr := r.client().Remove(tmpFilename)
+		if rmErr != nil {
+			debug.Log("sftp: failed to remove temp file %v: %v", tmpFilename, rmErr)
+			leaked = true
+		}
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "Rename")
+	}
+
+	if r.Config.StoreChecksums {
+		return r.copyChecksum(src, dst)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package sftp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func upperTransform(rd io.Reader) io.Reader {
+	buf, err := io.ReadAll(rd)
+	if err != nil {
+		panic(err)
+	}
+	return bytes.NewReader(bytes.ToUpper(buf))
+}
+
+func TestPipe(t *testing.T) {
+	be := newTestBackend(t, newTestConfig(t))
+
+	data := []byte("foobar")
+	src := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	saveFile(t, be, src, data)
+
+	for _, test := range []struct {
+		transform func(io.Reader) io.Reader
+		want      []byte
+	}{
+		{nil, data},
+		{upperTransform, []byte("FOOBAR")},
+	} {
+		dst := restic.Handle{Type: restic.PackFile, Name: restic.Hash(test.want).String()}
+		// use a different directory than src, which is checked below
+		for test.transform == nil && be.Dirname(dst) == be.Dirname(src) {
+			dst.Name = restic.NewRandomID().String()
+		}
+
+		// the directory of dst is created
+		_ = be.client().RemoveDirectory(be.Dirname(dst))
+
+		rtest.OK(t, be.Pipe(context.TODO(), src, dst, test.transform))
+
+		rd, err := be.client().Open(be.Filename(dst))
+		rtest.OK(t, err)
+		buf, err := io.ReadAll(rd)
+		rtest.OK(t, err)
+		rtest.OK(t, rd.Close())
+		rtest.Equals(t, test.want, buf)
+
+		fi, err := be.client().Lstat(be.Filename(dst))
+		rtest.OK(t, err)
+		rtest.Equals(t, os.FileMode(0), fi.Mode()&0222)
+
+		// without a transform, the server creates a link instead of a copy
+		srcFi, err := os.Stat(be.Filename(src))
+		rtest.OK(t, err)
+		dstFi, err := os.Stat(be.Filename(dst))
+		rtest.OK(t, err)
+		rtest.Equals(t, test.transform == nil, os.SameFile(srcFi, dstFi))
+	}
+
+	// no temporary files are left behind
+	rtest.Equals(t, 1, len(dirEntries(t, be, be.Dirname(src))))
+}
+
+func TestPipeReadWriteTransform(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.WriteTransform = func(wr io.Writer) io.Writer { return xorWriter{wr} }
+	cfg.ReadTransform = func(rd io.Reader) io.Reader { return xorReader{rd} }
+	be := newTestBackend(t, cfg)
+
+	data := []byte("foobar")
+	src := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	saveFile(t, be, src, data)
+
+	want := []byte("FOOBAR")
+	dst := restic.Handle{Type: restic.PackFile, Name: restic.Hash(want).String()}
+	rtest.OK(t, be.Pipe(context.TODO(), src, dst, upperTransform))
+
+	buf, err := backend.LoadAll(context.TODO(), nil, be, dst)
+	rtest.OK(t, err)
+	rtest.Equals(t, want, buf)
+}
+
+func TestPipeCompressTypes(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.CompressTypes = []restic.FileType{restic.IndexFile}
+	be := newTestBackend(t, cfg)
+
+	data := []byte(strings.Repeat("index data ", 100))
+	src := restic.Handle{Type: restic.IndexFile, Name: restic.Hash(data).String()}
+	saveFile(t, be, src, data)
+
+	// the data is decompressed instead of linking the compressed file
+	dst := restic.Handle{Type: restic.PackFile, Name: restic.NewRandomID().String()}
+	rtest.OK(t, be.Pipe(context.TODO(), src, dst, nil))
+
+	stored, err := os.ReadFile(be.Filename(dst))
+	rtest.OK(t, err)
+	rtest.Equals(t, data, stored)
+
+	fi, err := os.Stat(be.Filename(dst))
+	rtest.OK(t, err)
+	rtest.Equals(t, os.FileMode(0), fi.Mode()&0222)
+}
+
+func TestPipeOverwritePolicy(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.OverwritePolicy = OverwritePolicyError
+	be := newTestBackend(t, cfg)
+
+	data := []byte("foobar")
+	src := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	saveFile(t, be, src, data)
+	other := []byte("other data")
+	dst := restic.Handle{Type: restic.PackFile, Name: restic.Hash(other).String()}
+	saveFile(t, be, dst, other)
+
+	for _, transform := range []func(io.Reader) io.Reader{nil, upperTransform} {
+		err := be.Pipe(context.TODO(), src, dst, transform)
+		rtest.Assert(t, errors.Is(err, ErrFileExists), "expected ErrFileExists, got %v", err)
+	}
+
+	buf, err := backend.LoadAll(context.TODO(), nil, be, dst)
+	rtest.OK(t, err)
+	rtest.Equals(t, other, buf)
+
+	// no temporary files are left behind
+	rtest.Equals(t, 1, len(dirEntries(t, be, be.Dirname(dst))))
+}
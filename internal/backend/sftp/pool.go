@@ -0,0 +1,218 @@
+package sftp
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+
+	"github.com/pkg/sftp"
+)
+
+// connPool holds the additional connections which are opened if
+// Config.NumConnections is larger than one. The contents of files saved and
+// loaded are transferred over them and the main connection in turn, all other
+// requests only use the main connection. A pooled connection which has exited
+// is re-established in the background if Reconnect is enabled, and dropped
+// from the pool otherwise.
+type connPool struct {
+	m          sync.Mutex
+	conns      []*connection
+	next       int
+	restarting map[*connection]bool
+	closed     chan struct{}
+	wg         sync.WaitGroup
+}
+
+// startPool opens NumConnections-1 additional connections. It returns nil if
+// no additional connections are configured.
+func startPool(cfg Config) (*connPool, error) {
+	if cfg.NumConnections <= 1 {
+		return nil, nil
+	}
+
+	pool := &connPool{
+		restarting: make(map[*connection]bool),
+		closed:     make(chan struct{}),
+	}
+	for i := uint(1); i < cfg.NumConnections; i++ {
+		be, err := startClient(cfg)
+		if err != nil {
+			pool.close(clockFor(cfg), closeTimeout(cfg))
+			return nil, err
+		}
+		pool.conns = append(pool.conns, be.conn)
+	}
+	debug.Log("opened %d additional connections", len(pool.conns))
+	return pool, nil
+}
+
+// transferClient returns the client used to transfer the contents of a file.
+// It distributes the transfers across the main connection and the pooled
+// connections. While a pooled connection is re-established, the main
+// connection is used in its place. If ctx belongs to an operation with a
+// timeout, the pooled connection used is recorded in its watchdog.
+func (r *SFTP) transferClient(ctx context.Context) *sftp.Client {
+	if r.pool == nil {
+		return r.client()
+	}
+
+	conn := r.pool.pick()
+	if conn == nil {
+		return r.client()
+	}
+
+	conn.m.RLock()
+	c, result := conn.c, conn.result
+	conn.m.RUnlock()
+
+	select {
+	case <-result.Done():
+		debug.Log("pooled connection has exited, err %v", result.Err())
+		if r.Config.Reconnect {
+			r.pool.restart(conn, r.Config)
+		} else {
+			r.pool.drop(conn)
+		}
+		return r.client()
+	default:
+	}
+
+	watchdogFrom(ctx).use(conn)
+	return c
+}
+
+// pick returns the pooled connection to use for the next transfer, or nil if
+// it is the turn of the main connection.
+func (p *connPool) pick() *connection {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.next = (p.next + 1) % (len(p.conns) + 1)
+	if p.next == 0 {
+		return nil
+	}
+	return p.conns[p.next-1]
+}
+
+// drop removes conn from the pool.
+func (p *connPool) drop(conn *connection) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	for i, c := range p.conns {
+		if c == conn {
+			p.conns = append(p.conns[:i], p.conns[i+1:]...)
+			return
+		}
+	}
+}
+
+// restart re-establishes the pooled connection conn in the background, like
+// the main connection is re-established. If this fails, it is tried again
+// the next time conn is picked for a transfer.
+func (p *connPool) restart(conn *connection, cfg Config) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	select {
+	case <-p.closed:
+		return
+	default:
+	}
+	if p.restarting[conn] {
+		return
+	}
+	p.restarting[conn] = true
+	p.wg.Add(1)
+
+	go func() {
+		defer p.wg.Done()
+		defer func() {
+			p.m.Lock()
+			delete(p.restarting, conn)
+			p.m.Unlock()
+		}()
+
+		delay := cfg.ReconnectInitialDelay
+		if delay == 0 {
+			delay = defaultReconnectInitialDelay
+		}
+		if delay > 0 {
+			select {
+			case <-clockFor(cfg).After(delay):
+			case <-p.closed:
+				return
+			}
+		}
+
+		be, err := startClient(cfg)
+		if err != nil {
+			debug.Log("re-establishing pooled connection failed: %v", err)
+			return
+		}
+
+		// the old connection has already exited, only release the client.
+		// close waits for this goroutine, so the new connection is closed
+		// together with the pool.
+		conn.m.Lock()
+		_ = conn.c.Close()
+		conn.replace(be.conn)
+		conn.m.Unlock()
+		debug.Log("pooled connection re-established")
+	}()
+}
+
+// exited returns true if one of the pooled connections has exited.
+func (p *connPool) exited() bool {
+	if p == nil {
+		return false
+	}
+
+	p.m.Lock()
+	conns := append([]*connection(nil), p.conns...)
+	p.m.Unlock()
+
+	for _, conn := range conns {
+		conn.m.RLock()
+		result := conn.result
+		conn.m.RUnlock()
+
+		select {
+		case <-result.Done():
+			return true
+		default:
+		}
+	}
+	return false
+}
+
+// close closes all pooled connections and waits until they have exited.
+func (p *connPool) close(clk clock, timeout time.Duration) {
+	if p == nil {
+		return
+	}
+
+	p.m.Lock()
+	select {
+	case <-p.closed:
+	default:
+		close(p.closed)
+	}
+	p.m.Unlock()
+
+	// wait for connections which are being re-established
+	p.wg.Wait()
+
+	p.m.Lock()
+	conns := p.conns
+	p.conns = nil
+	p.m.Unlock()
+
+	for _, conn := range conns {
+		if err := conn.close(clk, timeout); err != nil {
+			debug.Log("closing pooled connection failed: %v", err)
+		}
+	}
+}
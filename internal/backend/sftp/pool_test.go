@@ -0,0 +1,89 @@
+package sftp
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestNumConnections(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.NumConnections = 3
+	be, err := Create(context.TODO(), cfg)
+	rtest.OK(t, err)
+
+	conns := append([]*connection(nil), be.pool.conns...)
+	rtest.Equals(t, 2, len(conns))
+
+	// transfers use all connections in turn
+	seen := make(map[interface{}]bool)
+	for i := 0; i < 3; i++ {
+		seen[be.transferClient(context.TODO())] = true
+	}
+	rtest.Equals(t, 3, len(seen))
+	rtest.Assert(t, seen[be.client()], "main connection is not used for transfers")
+
+	names := saveDataFiles(t, be, 10)
+	for _, name := range names {
+		h := restic.Handle{Type: restic.PackFile, Name: name}
+		err := be.Load(context.TODO(), h, 0, 0, func(rd io.Reader) error {
+			_, err := io.Copy(io.Discard, rd)
+			return err
+		})
+		rtest.OK(t, err)
+	}
+
+	// a pooled connection which has exited is no longer used
+	rtest.OK(t, conns[0].cmd.Process.Kill())
+	<-conns[0].result.Done()
+	for i := 0; i < 3; i++ {
+		rtest.Assert(t, be.transferClient(context.TODO()) != conns[0].c, "exited connection used for a transfer")
+	}
+	rtest.Equals(t, []*connection{conns[1]}, be.pool.conns)
+	saveDataFiles(t, be, 10)
+
+	// closing the backend waits for all connections to exit
+	rtest.OK(t, be.Close())
+	for _, conn := range conns {
+		select {
+		case <-conn.result.Done():
+		default:
+			t.Fatal("pooled connection still running after Close")
+		}
+	}
+}
+
+func TestNumConnectionsReconnect(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.NumConnections = 2
+	cfg.Reconnect = true
+	cfg.ReconnectInitialDelay = -1
+	be := newTestBackend(t, cfg)
+
+	conn := be.pool.conns[0]
+	conn.m.RLock()
+	c, cmd, result := conn.c, conn.cmd, conn.result
+	conn.m.RUnlock()
+
+	rtest.OK(t, cmd.Process.Kill())
+	<-result.Done()
+
+	// the main connection is used while the pooled connection is
+	// re-established
+	for i := 0; i < 2; i++ {
+		rtest.Equals(t, be.client(), be.transferClient(context.TODO()))
+	}
+	be.pool.wg.Wait()
+
+	rtest.Equals(t, []*connection{conn}, be.pool.conns)
+	seen := make(map[interface{}]bool)
+	for i := 0; i < 2; i++ {
+		seen[be.transferClient(context.TODO())] = true
+	}
+	rtest.Equals(t, 2, len(seen))
+	rtest.Assert(t, !seen[c], "exited connection used for a transfer")
+	saveDataFiles(t, be, 10)
+}
@@ -0,0 +1,110 @@
+package sftp
+
+import (
+	"io"
+
+	"github.com/restic/restic/internal/restic"
+)
+
+// progressInterval is the number of bytes transferred between two calls of
+// Config.Progress.
+const progressInterval = 256 * 1024
+
+// progressReader calls fn each time another progressInterval bytes have been
+// read from rd, and once more when rd is exhausted or closed.
+type progressReader struct {
+	rd    io.Reader
+	fn    func(h restic.Handle, done, total int64)
+	h     restic.Handle
+	total int64
+
+	done, reported int64
+	finished       bool
+}
+
+// progress returns a reader which reports the progress of reading rd, which
+// has total bytes or -1 if the size is unknown, to Config.Progress. It
+// returns rd if no progress function is configured.
+func (r *SFTP) progress(h restic.Handle, total int64, rd io.Reader) io.Reader {
+	if r.Config.Progress == nil {
+		return rd
+	}
+	return &progressReader{rd: rd, fn: r.Config.Progress, h: h, total: total}
+}
+
+// progressCloser is like progress, closing the returned reader closes rd.
+func (r *SFTP) progressCloser(h restic.Handle, total int64, rd io.ReadCloser) io.ReadCloser {
+	if r.Config.Progress == nil {
+		return rd
+	}
+	return &progressReadCloser{
+		progressReader: &progressReader{rd: rd, fn: r.Config.Progress, h: h, total: total},
+		closer:         rd,
+	}
+}
+
+func (p *progressReader) Read(buf []byte) (int, error) {
+	n, err := p.rd.Read(buf)
+	p.done += int64(n)
+	if err == io.EOF {
+		p.finish()
+	} else if p.done-p.reported >= progressInterval {
+		p.report()
+	}
+	return n, err
+}
+
+// WriteTo writes the remaining data to w. It uses the WriteTo method of the
+// underlying reader if available, so that files are still downloaded with
+// concurrent requests, and counts the bytes passed to w.
+func (p *progressReader) WriteTo(w io.Writer) (int64, error) {
+	wt, ok := p.rd.(io.WriterTo)
+	if !ok {
+		return io.Copy(w, struct{ io.Reader }{p})
+	}
+
+	n, err := wt.WriteTo(&progressWriter{w: w, p: p})
+	if err == nil {
+		p.finish()
+	}
+	return n, err
+}
+
+// progressWriter reports the bytes written to w as progress of p.
+type progressWriter struct {
+	w io.Writer
+	p *progressReader
+}
+
+func (pw *progressWriter) Write(buf []byte) (int, error) {
+	n, err := pw.w.Write(buf)
+	pw.p.done += int64(n)
+	if pw.p.done-pw.p.reported >= progressInterval {
+		pw.p.report()
+	}
+	return n, err
+}
+
+func (p *progressReader) report() {
+	p.reported = p.done
+	p.fn(p.h, p.done, p.total)
+}
+
+// finish reports the final progress once.
+func (p *progressReader) finish() {
+	if p.finished {
+		return
+	}
+	p.finished = true
+	p.report()
+}
+
+type progressReadCloser struct {
+	*progressReader
+	closer io.Closer
+}
+
+func (p *progressReadCloser) Close() error {
+	p.finish()
+	return p.closer.Close()
+}
@@ -0,0 +1,71 @@
+package sftp
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+type progressCall struct {
+	done, total int64
+}
+
+func TestProgress(t *testing.T) {
+	var (
+		m     sync.Mutex
+		calls []progressCall
+	)
+	cfg := newTestConfig(t)
+	cfg.Progress = func(h restic.Handle, done, total int64) {
+		m.Lock()
+		defer m.Unlock()
+		calls = append(calls, progressCall{done, total})
+	}
+	be := newTestBackend(t, cfg)
+
+	// takeCalls returns the calls since the last invocation and checks
+	// that the progress increases
+	takeCalls := func() []progressCall {
+		m.Lock()
+		defer m.Unlock()
+		c := calls
+		calls = nil
+		for i := 1; i < len(c); i++ {
+			rtest.Assert(t, c[i].done > c[i-1].done, "progress decreased: %v", c)
+		}
+		return c
+	}
+
+	data := rtest.Random(23, 4*progressInterval+10)
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	saveFile(t, be, h, data)
+
+	c := takeCalls()
+	rtest.Assert(t, len(c) >= 2 && len(c) <= 5, "unexpected number of progress calls: %v", c)
+	rtest.Equals(t, progressCall{int64(len(data)), int64(len(data))}, c[len(c)-1])
+
+	load := func(length int, offset int64) {
+		err := be.Load(context.TODO(), h, length, offset, func(rd io.Reader) error {
+			if length == 0 {
+				// the file is downloaded with concurrent requests
+				_, ok := rd.(io.WriterTo)
+				rtest.Assert(t, ok, "reader %T does not implement WriteTo", rd)
+			}
+			_, err := io.Copy(io.Discard, rd)
+			return err
+		})
+		rtest.OK(t, err)
+	}
+
+	load(0, 0)
+	c = takeCalls()
+	rtest.Assert(t, len(c) >= 2 && len(c) <= 5, "unexpected number of progress calls: %v", c)
+	rtest.Equals(t, progressCall{int64(len(data)), -1}, c[len(c)-1])
+
+	load(100, 10)
+	rtest.Equals(t, []progressCall{{100, 100}}, takeCalls())
+}
@@ -0,0 +1,78 @@
+package sftp
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+// TestMany returns the handles from hs for which no file exists.
+func (r *SFTP) TestMany(ctx context.Context, hs []restic.Handle) ([]restic.Handle, error) {
+	if err := r.clientError(ctx); err != nil {
+		return nil, err
+	}
+
+	var missing []restic.Handle
+	for _, h := range hs {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		if err := h.Valid(); err != nil {
+			return nil, backoff.Permanent(err)
+		}
+
+		r.sem.GetToken()
+		_, err := r.client().Lstat(r.Filename(h))
+		r.sem.ReleaseToken()
+		if r.IsNotExist(err) {
+			missing = append(missing, h)
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "Lstat")
+		}
+	}
+
+	return missing, nil
+}
+
+// PublishSnapshot saves the snapshot file h with the contents of rd, but only
+// if the files of all requiredIndexes exist. Otherwise, an error listing the
+// missing files is returned and nothing is written.
+func (r *SFTP) PublishSnapshot(ctx context.Context, h restic.Handle, rd io.Reader, requiredIndexes []restic.Handle) error {
+	debug.Log("PublishSnapshot %v, %d dependencies%v", h, len(requiredIndexes), r.tagInfo())
+	if err := r.checkReadOnly("save"); err != nil {
+		return err
+	}
+
+	if h.Type != restic.SnapshotFile {
+		return errors.Errorf("invalid type %v, must be a snapshot", h.Type)
+	}
+
+	missing, err := r.TestMany(ctx, requiredIndexes)
+	if err != nil {
+		return err
+	}
+
+	if len(missing) > 0 {
+		names := make([]string, 0, len(missing))
+		for _, m := range missing {
+			names = append(names, objectName(m))
+		}
+		return backoff.Permanent(errors.Errorf("snapshot %v references missing files: %v", h.Name, strings.Join(names, ", ")))
+	}
+
+	buf, err := io.ReadAll(rd)
+	if err != nil {
+		return errors.Wrap(err, "ReadAll")
+	}
+
+	return r.Save(ctx, h, restic.NewByteReader(buf, nil))
+}
@@ -0,0 +1,62 @@
+package sftp
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestPublishSnapshot(t *testing.T) {
+	be := newTestBackend(t, newTestConfig(t))
+
+	var indexes []restic.Handle
+	for _, data := range []string{"index 1", "index 2"} {
+		h := restic.Handle{Type: restic.IndexFile, Name: restic.Hash([]byte(data)).String()}
+		saveFile(t, be, h, []byte(data))
+		indexes = append(indexes, h)
+	}
+
+	data := []byte("snapshot")
+	h := restic.Handle{Type: restic.SnapshotFile, Name: restic.Hash(data).String()}
+
+	// a missing index is rejected before anything is written
+	missing := restic.Handle{Type: restic.IndexFile, Name: restic.NewRandomID().String()}
+	err := be.PublishSnapshot(context.TODO(), h, bytes.NewReader(data), append(indexes, missing))
+	rtest.Assert(t, err != nil, "expected an error for a missing index")
+	rtest.Assert(t, strings.Contains(err.Error(), "index/"+missing.Name), "missing index not named in error %v", err)
+	rtest.Assert(t, !strings.Contains(err.Error(), indexes[0].Name), "existing index named in error %v", err)
+
+	_, err = be.Stat(context.TODO(), h)
+	rtest.Assert(t, be.IsNotExist(err), "snapshot was saved: %v", err)
+
+	// with all dependencies present the snapshot is saved
+	rtest.OK(t, be.PublishSnapshot(context.TODO(), h, bytes.NewReader(data), indexes))
+	fi, err := be.Stat(context.TODO(), h)
+	rtest.OK(t, err)
+	rtest.Equals(t, int64(len(data)), fi.Size)
+
+	// only snapshots can be published
+	err = be.PublishSnapshot(context.TODO(), restic.Handle{Type: restic.IndexFile, Name: h.Name}, bytes.NewReader(data), nil)
+	rtest.Assert(t, err != nil, "expected an error for an index file")
+}
+
+func TestTestMany(t *testing.T) {
+	be := newTestBackend(t, newTestConfig(t))
+
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	saveFile(t, be, h, data)
+
+	other := restic.Handle{Type: restic.PackFile, Name: restic.NewRandomID().String()}
+	missing, err := be.TestMany(context.TODO(), []restic.Handle{h, other})
+	rtest.OK(t, err)
+	rtest.Equals(t, []restic.Handle{other}, missing)
+
+	missing, err = be.TestMany(context.TODO(), nil)
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, len(missing))
+}
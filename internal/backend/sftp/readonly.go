@@ -0,0 +1,22 @@
+package sftp
+
+import (
+	"fmt"
+
+	"github.com/restic/restic/internal/errors"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+// ErrReadOnly is returned by all methods which modify the repository if
+// Config.ReadOnly is set.
+var ErrReadOnly = errors.New("sftp: repository is opened read-only")
+
+// checkReadOnly returns a permanent error wrapping ErrReadOnly for the
+// operation op if the repository is opened read-only.
+func (r *SFTP) checkReadOnly(op string) error {
+	if !r.Config.ReadOnly {
+		return nil
+	}
+	return backoff.Permanent(fmt.Errorf("%w: %v is not allowed", ErrReadOnly, op))
+}
@@ -0,0 +1,93 @@
+package sftp
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestReadOnly(t *testing.T) {
+	cfg := newTestConfig(t)
+	be := newTestBackend(t, cfg)
+
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	saveFile(t, be, h, data)
+	saveFile(t, be, restic.Handle{Type: restic.ConfigFile}, data)
+	before := dirEntries(t, be, be.Location())
+	sort.Strings(before)
+
+	cfg.ReadOnly = true
+	_, err := Create(context.TODO(), cfg)
+	rtest.Assert(t, errors.Is(err, ErrReadOnly), "expected ErrReadOnly, got %v", err)
+
+	missing := cfg
+	missing.Path = be.Join(cfg.Path, "missing")
+	_, _, err = EnsureInitialized(context.TODO(), missing)
+	rtest.Assert(t, errors.Is(err, ErrReadOnly), "expected ErrReadOnly, got %v", err)
+
+	ro, created, err := EnsureInitialized(context.TODO(), cfg)
+	rtest.OK(t, err)
+	rtest.Assert(t, !created, "existing repository was created again")
+	rtest.OK(t, ro.Close())
+
+	ro, err = Open(context.TODO(), cfg)
+	rtest.OK(t, err)
+	defer func() {
+		rtest.OK(t, ro.Close())
+	}()
+
+	// reading works
+	buf, err := ro.Peek(context.TODO(), h, len(data))
+	rtest.OK(t, err)
+	rtest.Equals(t, data, buf)
+	names, err := ro.ListSorted(context.TODO(), restic.PackFile)
+	rtest.OK(t, err)
+	rtest.Equals(t, []string{h.Name}, names)
+
+	other := restic.Handle{Type: restic.PackFile, Name: restic.NewRandomID().String()}
+	for name, fn := range map[string]func() error{
+		"Save": func() error {
+			return ro.Save(context.TODO(), other, restic.NewByteReader(data, nil))
+		},
+		"SaveMany": func() error {
+			return ro.SaveMany(context.TODO(), []SaveItem{{Handle: other, Reader: restic.NewByteReader(data, nil)}})
+		},
+		"Remove": func() error {
+			return ro.Remove(context.TODO(), h)
+		},
+		"Pipe": func() error {
+			return ro.Pipe(context.TODO(), h, other, nil)
+		},
+		"Delete": func() error {
+			return ro.Delete(context.TODO())
+		},
+		"MaintenanceLock": func() error {
+			_, err := ro.MaintenanceLock(context.TODO())
+			return err
+		},
+		"LockAge": func() error {
+			_, err := ro.LockAge(context.TODO(), h)
+			return err
+		},
+		"EmptyTrash": func() error {
+			return ro.EmptyTrash(context.TODO(), 0)
+		},
+		"CheckWritable": func() error {
+			return ro.CheckWritable(context.TODO())
+		},
+	} {
+		err := fn()
+		rtest.Assert(t, errors.Is(err, ErrReadOnly), "%v: expected ErrReadOnly, got %v", name, err)
+	}
+
+	after := dirEntries(t, be, be.Location())
+	sort.Strings(after)
+	rtest.Equals(t, before, after)
+	_, err = be.Stat(context.TODO(), h)
+	rtest.OK(t, err)
+}
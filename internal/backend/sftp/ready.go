@@ -0,0 +1,38 @@
+package sftp
+
+import (
+	"context"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+// Ready blocks until the connection to the server is established and the
+// repository directory exists, or until ctx is cancelled. If the connection
+// has been lost and Reconnect is enabled, Ready waits for the new connection.
+// When the backend is connected, it returns after a single round trip.
+func (r *SFTP) Ready(ctx context.Context) error {
+	debug.Log("Ready()%v", r.tagInfo())
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := r.clientError(ctx); err != nil {
+		return err
+	}
+
+	err := r.retryOnConnectionLoss(ctx, "ready", r.p, func() error {
+		return r.runWithTimeout(ctx, "ready", func(ctx context.Context) error {
+			fi, err := r.client().Stat(r.p)
+			if err != nil {
+				return err
+			}
+			if !fi.IsDir() {
+				return backoff.Permanent(errors.Errorf("%v is not a directory", r.p))
+			}
+			return nil
+		})
+	})
+	return errors.Wrap(err, "Stat")
+}
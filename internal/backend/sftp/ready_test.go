@@ -0,0 +1,57 @@
+package sftp
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestReady(t *testing.T) {
+	be := newTestBackend(t, newTestConfig(t))
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	rtest.OK(t, be.Ready(ctx))
+	rtest.Assert(t, time.Since(start) < time.Second, "Ready took %v while connected", time.Since(start))
+}
+
+func TestReadyReconnect(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.Reconnect = true
+	be := newTestBackend(t, cfg)
+
+	killServer(t, be)
+	rtest.OK(t, be.Ready(context.TODO()))
+	rtest.Equals(t, 1, be.ReconnectCount())
+}
+
+func TestReadyCancel(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.Reconnect = true
+	cfg.ReconnectInitialDelay = time.Hour
+	be, err := Create(context.TODO(), cfg)
+	rtest.OK(t, err)
+	defer func() {
+		// ignore the error as the server has been killed
+		_ = be.Close()
+	}()
+
+	killServer(t, be)
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = be.Ready(ctx)
+	rtest.Assert(t, errors.Is(err, context.DeadlineExceeded), "expected context.DeadlineExceeded, got %v", err)
+	rtest.Assert(t, time.Since(start) < 5*time.Second, "Ready did not respect the context")
+	rtest.Equals(t, 0, be.ReconnectCount())
+
+	// a cancelled context is reported before doing anything
+	rtest.Assert(t, errors.Is(be.Ready(ctx), context.DeadlineExceeded), "cancelled context was ignored")
+}
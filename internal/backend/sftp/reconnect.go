@@ -0,0 +1,284 @@
+package sftp
+
+import (
+	"context"
+	"net"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// connection is the connection to the server. It is shared by all copies of
+// the backend returned by WithTag and replaced when reconnecting. Either cmd
+// is the ssh process, ssh is the native SSH connection or local is the
+// server side of the pipe to the local sftp server.
+type connection struct {
+	m           sync.RWMutex
+	c           *sftp.Client
+	cmd         *exec.Cmd
+	ssh         *ssh.Client
+	local       net.Conn
+	result      *exitResult
+	posixRename bool
+	reconnects  int
+
+	// reconnecting is closed when the reconnect in progress has finished,
+	// it is nil if there is none.
+	reconnecting chan struct{}
+	// closed is set by Close, a reconnect in progress then closes the new
+	// connection instead of using it.
+	closed bool
+
+	// p is the current path of the repository, which is changed by Rename.
+	// It is shared by all copies of the backend, so that copies still using
+	// the old path can detect that the repository was moved.
+	p string
+}
+
+// exitResult reports the exit of a connection to any number of receivers.
+type exitResult struct {
+	done chan struct{}
+	err  error
+}
+
+func newExitResult() *exitResult {
+	return &exitResult{done: make(chan struct{})}
+}
+
+// exit records err as the reason why the connection exited and closes the
+// channel returned by Done. It must be called exactly once.
+func (e *exitResult) exit(err error) {
+	e.err = err
+	close(e.done)
+}
+
+// Done returns a channel which is closed when the connection has exited.
+func (e *exitResult) Done() <-chan struct{} {
+	return e.done
+}
+
+// Err returns the error the connection exited with. It must only be called
+// after the channel returned by Done has been closed.
+func (e *exitResult) Err() error {
+	return e.err
+}
+
+// client returns the sftp client for the current connection.
+func (r *SFTP) client() *sftp.Client {
+	r.conn.m.RLock()
+	defer r.conn.m.RUnlock()
+	return r.conn.c
+}
+
+// replace takes over the client and the underlying command or connection of
+// n. The caller must hold conn.m for writing.
+func (conn *connection) replace(n *connection) {
+	conn.c, conn.cmd, conn.ssh, conn.local, conn.result, conn.posixRename = n.c, n.cmd, n.ssh, n.local, n.result, n.posixRename
+}
+
+// defaultReconnectInitialDelay is the default time to wait before starting a
+// new connection, so that a briefly overloaded server can recover.
+const defaultReconnectInitialDelay = 100 * time.Millisecond
+
+// reconnect replaces the connection which reported its exit on result by a
+// new one. If another goroutine has already replaced the connection, nil is
+// returned immediately. Only one reconnect is in flight at a time, other
+// callers wait for it and then use the new connection, or try again if it
+// failed. When the connection cannot be re-established, cause is returned as
+// a permanent error. The new connection is started after
+// ReconnectInitialDelay unless ctx is cancelled first. The connection lock is
+// not held while waiting and starting the new connection, so that other
+// goroutines and Close are not blocked.
+func (r *SFTP) reconnect(ctx context.Context, result *exitResult, cause error) error {
+	r.conn.m.Lock()
+	for r.conn.reconnecting != nil {
+		wait := r.conn.reconnecting
+		r.conn.m.Unlock()
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		r.conn.m.Lock()
+	}
+	if r.conn.result != result {
+		r.conn.m.Unlock()
+		return nil
+	}
+	if r.conn.closed {
+		r.conn.m.Unlock()
+		return backoff.Permanent(cause)
+	}
+	done := make(chan struct{})
+	r.conn.reconnecting = done
+	r.conn.m.Unlock()
+
+	defer func() {
+		r.conn.m.Lock()
+		r.conn.reconnecting = nil
+		r.conn.m.Unlock()
+		close(done)
+	}()
+
+	delay := r.Config.ReconnectInitialDelay
+	if delay == 0 {
+		delay = defaultReconnectInitialDelay
+	}
+	if delay > 0 {
+		debug.Log("waiting %v before reconnecting", delay)
+		select {
+		case <-clockFor(r.Config).After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	debug.Log("reconnecting after error %v", cause)
+	conn, err := startClient(r.Config)
+	if err != nil {
+		debug.Log("reconnect failed: %v", err)
+		r.emit(BackendEvent{Type: EventError, Operation: "reconnect", Err: err})
+		return backoff.Permanent(errors.Wrapf(cause, "reconnect failed: %v", err))
+	}
+
+	r.conn.m.Lock()
+	if r.conn.closed {
+		r.conn.m.Unlock()
+		debug.Log("backend was closed during reconnect")
+		_ = conn.conn.close(clockFor(r.Config), closeTimeout(r.Config))
+		return backoff.Permanent(cause)
+	}
+
+	// the old ssh process has already exited, only release the client
+	_ = r.conn.c.Close()
+
+	r.conn.replace(conn.conn)
+	r.conn.reconnects++
+	attempt := r.conn.reconnects
+	r.conn.m.Unlock()
+
+	debug.Log("reconnect %d successful", attempt)
+	r.emit(BackendEvent{Type: EventReconnect, Attempt: attempt, Err: cause})
+	if r.Config.OnReconnect != nil {
+		r.Config.OnReconnect(attempt, cause)
+	}
+
+	return nil
+}
+
+// ReconnectCount returns how often the connection to the server has been
+// re-established.
+func (r *SFTP) ReconnectCount() int {
+	r.conn.m.RLock()
+	defer r.conn.m.RUnlock()
+	return r.conn.reconnects
+}
+
+// Defaults for Config.MaxRetries and Config.RetryBackoff.
+const (
+	defaultMaxRetries   = 3
+	defaultRetryBackoff = 100 * time.Millisecond
+)
+
+// retryOnConnectionLoss runs op. If reconnecting is enabled and op fails
+// because the connection to the server was lost, the connection is
+// re-established if necessary and op is run again, up to MaxRetries times.
+// An error only counts as a lost connection if one of the connections has
+// exited, errors such as an unexpected EOF while the connection is still
+// alive are returned unchanged. If reconnecting fails, the error returned by
+// op is returned together with the reason.
+func (r *SFTP) retryOnConnectionLoss(ctx context.Context, operation, object string, op func() error) error {
+	maxRetries := int(r.Config.MaxRetries)
+	if maxRetries == 0 {
+		maxRetries = defaultMaxRetries
+	}
+	delay := r.Config.RetryBackoff
+	if delay <= 0 {
+		delay = defaultRetryBackoff
+	}
+
+	result := r.connResult()
+	err := op()
+	for attempt := 1; err != nil && r.Config.Reconnect && isConnectionLost(err) && attempt <= maxRetries; attempt++ {
+		lost, rerr := r.awaitConnectionLoss(ctx, result, err, delay)
+		if rerr != nil {
+			return rerr
+		}
+		if !lost {
+			break
+		}
+
+		if berr := r.takeRetry(operation, err); berr != nil {
+			return berr
+		}
+
+		debug.Log("%v %v failed with %v, retry %d", operation, object, err, attempt)
+		r.emit(BackendEvent{Type: EventRetry, Operation: operation, Object: object, Attempt: attempt})
+
+		delay *= 2
+		result = r.connResult()
+		err = op()
+	}
+
+	return err
+}
+
+// connResult returns the exit result of the current main connection.
+func (r *SFTP) connResult() *exitResult {
+	r.conn.m.RLock()
+	defer r.conn.m.RUnlock()
+	return r.conn.result
+}
+
+// awaitConnectionLoss determines whether an operation which failed with cause
+// was interrupted because the connection to the server was lost. result is
+// the exit result of the main connection when the operation was started.
+// The server process may only be reaped after the client has seen the closed
+// connection, so it waits up to delay for the main connection to exit. If it
+// does, the connection is re-established. Otherwise lost reports whether one
+// of the pooled connections has exited, which is re-established or dropped
+// the next time it is picked for a transfer.
+func (r *SFTP) awaitConnectionLoss(ctx context.Context, result *exitResult, cause error, delay time.Duration) (lost bool, err error) {
+	exited := false
+	select {
+	case <-result.Done():
+		exited = true
+	case <-clockFor(r.Config).After(delay):
+		// the connection may have exited at the same time
+		select {
+		case <-result.Done():
+			exited = true
+		default:
+		}
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+
+	if !exited {
+		if r.pool.exited() {
+			return true, nil
+		}
+		debug.Log("connection is still alive, %v is not caused by a lost connection", cause)
+		return false, nil
+	}
+
+	exitErr := result.Err()
+	debug.Log("client has exited with err %v", exitErr)
+	r.emit(BackendEvent{Type: EventError, Err: exitErr})
+	err = r.reconnect(ctx, result, exitErr)
+	if err != nil {
+		var permanent *backoff.PermanentError
+		if errors.As(err, &permanent) {
+			err = permanent.Err
+		}
+		return false, backoff.Permanent(errors.Wrapf(cause, "%v", err))
+	}
+	return true, nil
+}
@@ -0,0 +1,297 @@
+package sftp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// killServer terminates the server process of the current connection and
+// waits until the backend has noticed that it exited.
+func killServer(t testing.TB, be *SFTP) {
+	be.conn.m.RLock()
+	cmd, result := be.conn.cmd, be.conn.result
+	be.conn.m.RUnlock()
+
+	rtest.OK(t, cmd.Process.Kill())
+	<-result.Done()
+}
+
+func TestReconnect(t *testing.T) {
+	var (
+		m        sync.Mutex
+		attempts []int
+	)
+
+	cfg := newTestConfig(t)
+	cfg.Reconnect = true
+	cfg.OnReconnect = func(attempt int, err error) {
+		m.Lock()
+		defer m.Unlock()
+		rtest.Assert(t, err != nil, "OnReconnect called without an error")
+		attempts = append(attempts, attempt)
+	}
+	be := newTestBackend(t, cfg)
+
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	saveFile(t, be, h, data)
+
+	rtest.Equals(t, 0, be.ReconnectCount())
+
+	for i := 1; i <= 3; i++ {
+		killServer(t, be)
+
+		fi, err := be.Stat(context.TODO(), h)
+		rtest.OK(t, err)
+		rtest.Equals(t, int64(len(data)), fi.Size)
+		rtest.Equals(t, i, be.ReconnectCount())
+	}
+
+	m.Lock()
+	rtest.Equals(t, []int{1, 2, 3}, attempts)
+	m.Unlock()
+}
+
+func TestReconnectConcurrent(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.Reconnect = true
+	cfg.Connections = 50
+	be := newTestBackend(t, cfg)
+
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	saveFile(t, be, h, data)
+
+	killServer(t, be)
+
+	// all operations notice the lost connection at the same time, only one
+	// of them reconnects and the others use the new connection
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			fi, err := be.Stat(context.TODO(), h)
+			if err != nil {
+				t.Errorf("Stat failed: %v", err)
+				return
+			}
+			if fi.Size != int64(len(data)) {
+				t.Errorf("wrong size %v", fi.Size)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	rtest.Equals(t, 1, be.ReconnectCount())
+}
+
+func TestNoReconnect(t *testing.T) {
+	be, err := Create(context.TODO(), newTestConfig(t))
+	rtest.OK(t, err)
+	defer func() {
+		// ignore the error as the server has been killed
+		_ = be.Close()
+	}()
+
+	killServer(t, be)
+
+	_, err = be.Stat(context.TODO(), restic.Handle{Type: restic.PackFile, Name: restic.NewRandomID().String()})
+	rtest.Assert(t, err != nil, "expected an error after the server exited")
+	rtest.Equals(t, 0, be.ReconnectCount())
+}
+
+func TestReconnectInitialDelay(t *testing.T) {
+	var reconnected time.Time
+
+	cfg := newTestConfig(t)
+	cfg.Reconnect = true
+	cfg.ReconnectInitialDelay = 300 * time.Millisecond
+	cfg.OnReconnect = func(attempt int, err error) {
+		reconnected = time.Now()
+	}
+	be := newTestBackend(t, cfg)
+
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	saveFile(t, be, h, data)
+
+	killServer(t, be)
+	start := time.Now()
+	_, err := be.Stat(context.TODO(), h)
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, be.ReconnectCount())
+	rtest.Assert(t, reconnected.Sub(start) >= cfg.ReconnectInitialDelay, "reconnected after %v", reconnected.Sub(start))
+}
+
+func TestReconnectInitialDelayCancel(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.Reconnect = true
+	cfg.ReconnectInitialDelay = time.Hour
+	be := newTestBackend(t, cfg)
+
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	saveFile(t, be, h, data)
+
+	killServer(t, be)
+
+	// the deadline of the operation bounds the delay
+	ctx, cancel := context.WithTimeout(context.TODO(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := be.Stat(ctx, h)
+	rtest.Assert(t, errors.Is(err, context.DeadlineExceeded), "expected deadline error, got %v", err)
+	rtest.Assert(t, time.Since(start) < time.Minute, "delay was not cancelled")
+	rtest.Equals(t, 0, be.ReconnectCount())
+
+	be.Config.ReconnectInitialDelay = -1
+	_, err = be.Stat(context.TODO(), h)
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, be.ReconnectCount())
+}
+
+func TestReconnectCloseDuringDelay(t *testing.T) {
+	clock := newFakeClock()
+	cfg := newTestConfig(t)
+	cfg.Reconnect = true
+	cfg.ReconnectInitialDelay = time.Hour
+	setClock(&cfg, clock)
+	be, err := Create(context.TODO(), cfg)
+	rtest.OK(t, err)
+
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	saveFile(t, be, h, data)
+
+	killServer(t, be)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := be.Stat(context.TODO(), h)
+		done <- err
+	}()
+	clock.waitTimers(t, 1)
+
+	// the connection is not locked while waiting for the delay
+	rtest.Equals(t, 0, be.ReconnectCount())
+	// ignore the error as the server was killed
+	_ = be.Close()
+
+	// the new connection is not used after Close
+	clock.Advance(time.Hour)
+	err = <-done
+	rtest.Assert(t, err != nil, "Stat succeeded after Close")
+	rtest.Equals(t, 0, be.ReconnectCount())
+}
+
+// killingReader kills the server of be before the first read.
+type killingReader struct {
+	*restic.ByteReader
+	be     *SFTP
+	t      testing.TB
+	before func()
+}
+
+func (rd *killingReader) Read(p []byte) (int, error) {
+	if rd.be != nil {
+		if rd.before != nil {
+			rd.before()
+		}
+		killServer(rd.t, rd.be)
+		rd.be = nil
+	}
+	return rd.ByteReader.Read(p)
+}
+
+func TestRetryOnConnectionLoss(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.Reconnect = true
+	cfg.EmitEvents = true
+	be, err := Create(context.TODO(), cfg)
+	rtest.OK(t, err)
+
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+
+	// the connection is lost while saving the file
+	rd := &killingReader{ByteReader: restic.NewByteReader(data, nil), be: be, t: t}
+	rtest.OK(t, be.Save(context.TODO(), h, rd))
+	rtest.Equals(t, 1, be.ReconnectCount())
+
+	// the connection is lost while loading the file, reading is resumed
+	// without calling fn again
+	calls := 0
+	err = be.Load(context.TODO(), h, 0, 0, func(rd io.Reader) error {
+		calls++
+		if calls == 1 {
+			killServer(t, be)
+		}
+		buf, err := io.ReadAll(rd)
+		if err != nil {
+			return err
+		}
+		rtest.Equals(t, data, buf)
+		return nil
+	})
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, calls)
+	rtest.Equals(t, 2, be.ReconnectCount())
+
+	rtest.Equals(t, []EventType{EventConnect, EventError, EventReconnect, EventRetry, EventError, EventReconnect, EventRetry}, collectEvents(t, be))
+}
+
+func TestRetryOnConnectionLossReconnectFails(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.Reconnect = true
+	cfg.ReconnectInitialDelay = -1
+	be, err := Create(context.TODO(), cfg)
+	rtest.OK(t, err)
+	defer func() {
+		_ = be.Close()
+	}()
+
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+
+	rd := &killingReader{ByteReader: restic.NewByteReader(data, nil), be: be, t: t, before: func() {
+		be.Config.Command = "/does/not/exist"
+	}}
+	err = be.Save(context.TODO(), h, rd)
+	rtest.Assert(t, err != nil, "expected an error")
+	rtest.Assert(t, isConnectionLost(err), "original error is lost: %v", err)
+	rtest.Assert(t, strings.Contains(err.Error(), "reconnect failed"), "reconnect error is missing: %v", err)
+}
+
+func TestRetryConnectionAlive(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.Reconnect = true
+	be := newTestBackend(t, cfg)
+
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	saveFile(t, be, h, data)
+
+	// errors which look like a lost connection are not retried while the
+	// connection is alive
+	calls := 0
+	err := be.Load(context.TODO(), h, 0, 0, func(rd io.Reader) error {
+		calls++
+		return io.ErrUnexpectedEOF
+	})
+	rtest.Assert(t, errors.Is(err, io.ErrUnexpectedEOF), "unexpected error %v", err)
+	rtest.Equals(t, 1, calls)
+
+	rtest.Equals(t, 0, be.ReconnectCount())
+}
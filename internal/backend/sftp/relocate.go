@@ -0,0 +1,158 @@
+package sftp
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+// ErrRepositoryMoved is returned by copies of a backend after the repository
+// was moved with Rename.
+var ErrRepositoryMoved = errors.New("repository was moved")
+
+// Rename moves the repository to newPath on the same server and makes the
+// backend use the new location. newPath is interpreted like Config.Path. If
+// the server cannot rename the directory because newPath is on a different
+// file system, the files are copied and the old directory is removed
+// afterwards. Rename fails with an error wrapping ErrFileExists if newPath
+// already exists.
+//
+// No other operations may run during Rename. Afterwards, all operations of
+// copies of the backend returned by WithTag, including those used by a
+// Mirror, fail with an error wrapping ErrRepositoryMoved.
+func (r *SFTP) Rename(ctx context.Context, newPath string) error {
+	debug.Log("Rename %v -> %v%v", r.p, newPath, r.tagInfo())
+	if err := r.checkReadOnly("rename"); err != nil {
+		return err
+	}
+
+	if err := r.clientError(ctx); err != nil {
+		return err
+	}
+	if err := r.checkMaintenance(); err != nil {
+		return err
+	}
+
+	newPath = path.Clean(newPath)
+	if newPath == "." || newPath == "/" {
+		return backoff.Permanent(errors.Errorf("invalid repository path %q", newPath))
+	}
+	if newPath == r.p || strings.HasPrefix(newPath, r.p+"/") {
+		return backoff.Permanent(errors.Errorf("cannot move the repository %v into itself", r.p))
+	}
+
+	r.sem.GetToken()
+	defer r.sem.ReleaseToken()
+
+	exists, err := r.exists(newPath)
+	if err != nil {
+		return errors.Wrap(err, "Lstat")
+	}
+	if exists {
+		return backoff.Permanent(errors.Wrap(ErrFileExists, newPath))
+	}
+
+	if dir := path.Dir(newPath); dir != "." {
+		if err := r.client().MkdirAll(dir); err != nil {
+			return errors.Wrap(err, "MkdirAll")
+		}
+	}
+
+	err = r.client().Rename(r.p, newPath)
+	if isCrossDevice(err) {
+		debug.Log("rename %v to %v across file systems, copying: %v", r.p, newPath, err)
+		err = r.copyTree(ctx, r.p, newPath)
+		if err != nil {
+			if rmErr := r.removeTree(ctx, newPath); rmErr != nil {
+				debug.Log("unable to remove partial copy %v: %v", newPath, rmErr)
+			}
+			return err
+		}
+		err = r.removeTree(ctx, r.p)
+	}
+	if err != nil {
+		return errors.Wrap(err, "Rename")
+	}
+
+	return r.switchPath(ctx, newPath)
+}
+
+// switchPath makes the backend use the repository at newPath.
+func (r *SFTP) switchPath(ctx context.Context, newPath string) error {
+	cfg := r.Config
+	cfg.Path = newPath
+	l, err := r.parseLayout(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	r.conn.m.Lock()
+	defer r.conn.m.Unlock()
+	r.Config = cfg
+	r.p = newPath
+	r.conn.p = newPath
+	r.Layout = l
+	if r.journal != nil {
+		r.journal = newTempJournal(r.Join(newPath, journalDir))
+	}
+	return nil
+}
+
+// setPath sets the path of the repository for the backend and all its
+// copies.
+func (r *SFTP) setPath(p string) {
+	r.conn.m.Lock()
+	defer r.conn.m.Unlock()
+	r.p = p
+	r.conn.p = p
+}
+
+// copyTree copies the directory src with all files and subdirectories to the
+// new directory dst.
+func (r *SFTP) copyTree(ctx context.Context, src, dst string) error {
+	if err := r.client().Mkdir(dst); err != nil {
+		return errors.Wrap(err, "Mkdir")
+	}
+	if err := r.chmodDir(dst); err != nil {
+		return err
+	}
+
+	entries, err := r.ReadDir(ctx, src)
+	if err != nil {
+		return errors.Wrap(err, "ReadDir")
+	}
+
+	for _, fi := range entries {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		from, to := r.Join(src, fi.Name()), r.Join(dst, fi.Name())
+		if fi.IsDir() {
+			err = r.copyTree(ctx, from, to)
+		} else {
+			err = r.copyFile(from, to)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeTree removes the directory dir with all its contents.
+func (r *SFTP) removeTree(ctx context.Context, dir string) error {
+	if err := r.deleteRecursive(ctx, dir); err != nil {
+		return err
+	}
+	err := r.client().RemoveDirectory(dir)
+	if err != nil && !r.IsNotExist(err) {
+		return errors.Wrap(err, "RemoveDirectory")
+	}
+	return nil
+}
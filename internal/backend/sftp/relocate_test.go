@@ -0,0 +1,105 @@
+package sftp
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// listNames returns the sorted names of all files of type tpe.
+func listNames(t testing.TB, be *SFTP, tpe restic.FileType) []string {
+	var names []string
+	rtest.OK(t, be.List(context.TODO(), tpe, func(fi restic.FileInfo) error {
+		names = append(names, fi.Name)
+		return nil
+	}))
+	sort.Strings(names)
+	return names
+}
+
+func TestRename(t *testing.T) {
+	cfg := newTestConfig(t)
+	be := newTestBackend(t, cfg)
+
+	want := saveDataFiles(t, be, 10)
+	config := restic.Handle{Type: restic.ConfigFile}
+	saveFile(t, be, config, []byte("config"))
+
+	newPath := filepath.Join(t.TempDir(), "moved", "repo")
+	rtest.OK(t, be.Rename(context.TODO(), newPath))
+	rtest.Equals(t, newPath, be.Location())
+
+	_, err := os.Stat(cfg.Path)
+	rtest.Assert(t, errors.Is(err, os.ErrNotExist), "old repository still exists: %v", err)
+
+	rtest.Equals(t, want, listNames(t, be, restic.PackFile))
+	buf, err := backend.LoadAll(context.TODO(), nil, be, config)
+	rtest.OK(t, err)
+	rtest.Equals(t, []byte("config"), buf)
+
+	// the repository can be opened at the new location
+	cfg.Path = newPath
+	other := openTestBackend(t, cfg)
+	rtest.Equals(t, want, listNames(t, other, restic.PackFile))
+}
+
+func TestRenameStaleCopy(t *testing.T) {
+	cfg := newTestConfig(t)
+	be := newTestBackend(t, cfg)
+	tagged := be.WithTag("tagged")
+
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	saveFile(t, be, h, data)
+
+	rtest.OK(t, be.Rename(context.TODO(), filepath.Join(t.TempDir(), "repo")))
+
+	_, err := tagged.Stat(context.TODO(), h)
+	rtest.Assert(t, errors.Is(err, ErrRepositoryMoved), "expected ErrRepositoryMoved, got %v", err)
+	err = tagged.Save(context.TODO(), h, restic.NewByteReader(data, nil))
+	rtest.Assert(t, errors.Is(err, ErrRepositoryMoved), "expected ErrRepositoryMoved, got %v", err)
+
+	_, err = be.Stat(context.TODO(), h)
+	rtest.OK(t, err)
+}
+
+func TestRenameInvalid(t *testing.T) {
+	be := newTestBackend(t, newTestConfig(t))
+
+	existing := t.TempDir()
+	err := be.Rename(context.TODO(), existing)
+	rtest.Assert(t, errors.Is(err, ErrFileExists), "expected ErrFileExists, got %v", err)
+
+	for _, p := range []string{be.Location(), filepath.Join(be.Location(), "sub"), "/"} {
+		err = be.Rename(context.TODO(), p)
+		rtest.Assert(t, err != nil, "Rename to %v succeeded", p)
+	}
+
+	_, err = os.Stat(be.Location())
+	rtest.OK(t, err)
+}
+
+func TestRenameCrossDevice(t *testing.T) {
+	cfg := fakeServerConfig(faultCrossDevice)
+	cfg.Path = "/repo/a"
+	be, err := Create(context.TODO(), cfg)
+	rtest.OK(t, err)
+	defer func() {
+		rtest.OK(t, be.Close())
+	}()
+
+	want := saveDataFiles(t, be, 5)
+
+	rtest.OK(t, be.Rename(context.TODO(), "/repo/b"))
+	rtest.Equals(t, want, listNames(t, be, restic.PackFile))
+
+	_, err = be.client().Lstat("/repo/a")
+	rtest.Assert(t, be.IsNotExist(err), "old repository still exists: %v", err)
+}
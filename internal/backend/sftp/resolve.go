@@ -0,0 +1,47 @@
+package sftp
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// ErrNoSnapshotMatch is returned by ResolveSnapshot if no snapshot name
+// starts with the prefix.
+var ErrNoSnapshotMatch = errors.New("no snapshot found")
+
+// ErrAmbiguousSnapshot is returned by ResolveSnapshot if more than one
+// snapshot name starts with the prefix.
+var ErrAmbiguousSnapshot = errors.New("prefix matches multiple snapshots")
+
+// ResolveSnapshot returns the handle of the snapshot whose name starts with
+// prefix. If several snapshots match, the error lists their names.
+func (r *SFTP) ResolveSnapshot(ctx context.Context, prefix string) (restic.Handle, error) {
+	debug.Log("ResolveSnapshot %q%v", prefix, r.tagInfo())
+
+	var matches []string
+	err := r.List(ctx, restic.SnapshotFile, func(fi restic.FileInfo) error {
+		if strings.HasPrefix(fi.Name, prefix) {
+			matches = append(matches, fi.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		return restic.Handle{}, err
+	}
+
+	switch len(matches) {
+	case 0:
+		return restic.Handle{}, fmt.Errorf("%w with prefix %q", ErrNoSnapshotMatch, prefix)
+	case 1:
+		return restic.Handle{Type: restic.SnapshotFile, Name: matches[0]}, nil
+	default:
+		sort.Strings(matches)
+		return restic.Handle{}, fmt.Errorf("%w: prefix %q matches %v", ErrAmbiguousSnapshot, prefix, strings.Join(matches, ", "))
+	}
+}
@@ -0,0 +1,41 @@
+package sftp
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestResolveSnapshot(t *testing.T) {
+	be := newTestBackend(t, newTestConfig(t))
+
+	names := []string{
+		"1234567890abcdef1234567890abcdef1234567890abcdef1234567890abcdef",
+		"1234aaaa90abcdef1234567890abcdef1234567890abcdef1234567890abcdef",
+		"fedcba0987654321fedcba0987654321fedcba0987654321fedcba0987654321",
+	}
+	for _, name := range names {
+		saveFile(t, be, restic.Handle{Type: restic.SnapshotFile, Name: name}, []byte(name))
+	}
+
+	for _, prefix := range []string{"12345", "1234a", "f", names[2]} {
+		h, err := be.ResolveSnapshot(context.TODO(), prefix)
+		rtest.OK(t, err)
+		rtest.Equals(t, restic.SnapshotFile, h.Type)
+		rtest.Assert(t, strings.HasPrefix(h.Name, prefix), "%v does not match prefix %v", h.Name, prefix)
+	}
+
+	_, err := be.ResolveSnapshot(context.TODO(), "1234")
+	rtest.Assert(t, errors.Is(err, ErrAmbiguousSnapshot), "expected ErrAmbiguousSnapshot, got %v", err)
+	rtest.Assert(t, strings.Contains(err.Error(), names[0]) && strings.Contains(err.Error(), names[1]), "candidates missing in %v", err)
+
+	_, err = be.ResolveSnapshot(context.TODO(), "")
+	rtest.Assert(t, errors.Is(err, ErrAmbiguousSnapshot), "expected ErrAmbiguousSnapshot, got %v", err)
+
+	_, err = be.ResolveSnapshot(context.TODO(), "abc")
+	rtest.Assert(t, errors.Is(err, ErrNoSnapshotMatch), "expected ErrNoSnapshotMatch, got %v", err)
+}
@@ -0,0 +1,111 @@
+package sftp
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// Result describes the outcome of processing a single object during a bulk
+// operation such as SaveMany. Results are written as newline-delimited JSON
+// to Config.ResultStream.
+type Result struct {
+	Operation string `json:"operation"`
+	Object    string `json:"object"`
+	Bytes     int64  `json:"bytes"`
+	Outcome   string `json:"outcome"`
+	Error     string `json:"error,omitempty"`
+	Tag       string `json:"tag,omitempty"`
+}
+
+// resultWriter serializes writing results to the configured stream.
+type resultWriter struct {
+	m      sync.Mutex
+	closed bool
+}
+
+// flusher is implemented by writers which buffer data, such as
+// *bufio.Writer.
+type flusher interface {
+	Flush() error
+}
+
+// objectName returns the name used for h in results, events and metrics. The
+// prefix directories removed by CompactPrefixDirs are named like a data file.
+func objectName(h restic.Handle) string {
+	if h.Type == restic.ConfigFile {
+		return "config"
+	}
+	return h.Type.String() + "/" + h.Name
+}
+
+// reportResult writes a result record to the configured result stream, if
+// there is one.
+func (r *SFTP) reportResult(operation, object string, bytes int64, err error) {
+	if r.Config.ResultStream == nil {
+		return
+	}
+
+	res := Result{
+		Operation: operation,
+		Object:    object,
+		Bytes:     bytes,
+		Outcome:   "ok",
+		Tag:       r.tag,
+	}
+	if err != nil {
+		res.Outcome = "error"
+		res.Error = err.Error()
+	}
+
+	buf, err := json.Marshal(res)
+	if err != nil {
+		debug.Log("unable to encode result: %v", err)
+		return
+	}
+	buf = append(buf, '\n')
+
+	r.results.m.Lock()
+	defer r.results.m.Unlock()
+
+	if r.results.closed {
+		debug.Log("result stream already closed, dropping result for %v", object)
+		return
+	}
+
+	if _, err := r.Config.ResultStream.Write(buf); err != nil {
+		debug.Log("unable to write result: %v", err)
+	}
+}
+
+// closeResults flushes the result stream if it buffers data, waiting at most
+// timeout. Afterwards, no more results are written.
+func (r *SFTP) closeResults(timeout time.Duration) error {
+	if r.Config.ResultStream == nil {
+		return nil
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		r.results.m.Lock()
+		defer r.results.m.Unlock()
+
+		r.results.closed = true
+		if f, ok := r.Config.ResultStream.(flusher); ok {
+			done <- f.Flush()
+			return
+		}
+		done <- nil
+	}()
+
+	select {
+	case err := <-done:
+		return errors.Wrap(err, "Flush")
+	case <-clockFor(r.Config).After(timeout):
+		return errors.Errorf("flushing the result stream timed out after %v", timeout)
+	}
+}
@@ -0,0 +1,143 @@
+package sftp
+
+import (
+	"context"
+	"io"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/restic"
+)
+
+// maxLoadResumes is the number of times reading a file in Load is resumed
+// after the connection was lost.
+const maxLoadResumes = 3
+
+// loadReader opens the file for h like openReader. If Reconnect is set, the
+// returned reader resumes reading after the connection was lost. Otherwise the
+// connection is never re-established, so the reader is returned unchanged.
+func (r *SFTP) loadReader(ctx context.Context, h restic.Handle, length int, offset int64) (io.ReadCloser, error) {
+	result := r.connResult()
+	rd, err := r.openReader(ctx, h, length, offset)
+	if err != nil || !r.Config.Reconnect {
+		return rd, err
+	}
+	return &resumingReader{r: r, ctx: ctx, h: h, length: length, offset: offset, rd: rd, result: result}, nil
+}
+
+// resumingReader reads the file for h starting at offset. If reading fails
+// because the connection was lost, the file is opened again after
+// reconnecting, and reading continues after the data which has already been
+// returned. This is done at most maxLoadResumes times. Errors while the
+// connection is still alive, such as an unexpected EOF because the file is
+// shorter than requested, are returned unchanged.
+type resumingReader struct {
+	r      *SFTP
+	ctx    context.Context
+	h      restic.Handle
+	length int
+	offset int64
+
+	rd      io.ReadCloser
+	result  *exitResult
+	read    int64
+	resumes int
+}
+
+func (rr *resumingReader) Read(p []byte) (int, error) {
+	for {
+		n, err := rr.rd.Read(p)
+		rr.read += int64(n)
+		if err == nil || err == io.EOF || !isConnectionLost(err) {
+			return n, err
+		}
+		if n > 0 {
+			// the error is returned again by the next read
+			return n, nil
+		}
+
+		if err := rr.resume(err); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// WriteTo writes the remaining data to w. It uses the WriteTo method of the
+// underlying reader, so that the file is still read with concurrent requests.
+func (rr *resumingReader) WriteTo(w io.Writer) (int64, error) {
+	var total int64
+	for {
+		var n int64
+		var err error
+		if wt, ok := rr.rd.(io.WriterTo); ok {
+			n, err = wt.WriteTo(w)
+		} else {
+			n, err = io.Copy(w, struct{ io.Reader }{rr.rd})
+		}
+		rr.read += n
+		total += n
+		if err == nil || !isConnectionLost(err) {
+			return total, err
+		}
+
+		if err := rr.resume(err); err != nil {
+			return total, err
+		}
+	}
+}
+
+// resume opens the file again if reading failed with cause because the
+// connection was lost. If reading cannot be resumed, cause is returned.
+func (rr *resumingReader) resume(cause error) error {
+	if rr.resumes >= maxLoadResumes {
+		return cause
+	}
+
+	delay := rr.r.Config.RetryBackoff
+	if delay <= 0 {
+		delay = defaultRetryBackoff
+	}
+	lost, err := rr.r.awaitConnectionLoss(rr.ctx, rr.result, cause, delay)
+	if err != nil {
+		return err
+	}
+	if !lost {
+		return cause
+	}
+	if err := rr.r.takeRetry("load", cause); err != nil {
+		return err
+	}
+
+	rr.resumes++
+	debug.Log("reading %v interrupted after %d bytes: %v", rr.h, rr.read, cause)
+
+	// this releases the semaphore token, openReader takes a new one
+	_ = rr.rd.Close()
+	rr.rd = eofReader{}
+
+	length := rr.length
+	if length > 0 {
+		length -= int(rr.read)
+		if length == 0 {
+			return nil
+		}
+	}
+
+	rr.result = rr.r.connResult()
+	rd, err := rr.r.openReader(rr.ctx, rr.h, length, rr.offset+rr.read)
+	if err != nil {
+		return err
+	}
+	rr.rd = rd
+	rr.r.emit(BackendEvent{Type: EventRetry, Operation: "load", Object: objectName(rr.h), Attempt: rr.resumes})
+	return nil
+}
+
+func (rr *resumingReader) Close() error {
+	return rr.rd.Close()
+}
+
+// eofReader is used by resumingReader while no file is open.
+type eofReader struct{}
+
+func (eofReader) Read([]byte) (int, error) { return 0, io.EOF }
+func (eofReader) Close() error             { return nil }
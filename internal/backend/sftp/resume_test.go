@@ -0,0 +1,129 @@
+package sftp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestLoadResume(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.Reconnect = true
+	be := newTestBackend(t, cfg)
+
+	data := rtest.Random(42, 4*1024*1024)
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	saveFile(t, be, h, data)
+
+	for _, test := range []struct {
+		length int
+		offset int64
+	}{
+		{0, 0},
+		{3 * 1024 * 1024, 1000},
+	} {
+		calls := 0
+		var buf []byte
+		err := be.Load(context.TODO(), h, test.length, test.offset, func(rd io.Reader) error {
+			calls++
+			first := make([]byte, 100*1024)
+			_, err := io.ReadFull(rd, first)
+			if err != nil {
+				return err
+			}
+
+			killServer(t, be)
+			rest, err := io.ReadAll(rd)
+			buf = append(first, rest...)
+			return err
+		})
+		rtest.OK(t, err)
+
+		want := data[test.offset:]
+		if test.length > 0 {
+			want = want[:test.length]
+		}
+		rtest.Equals(t, 1, calls)
+		rtest.Assert(t, bytes.Equal(want, buf), "wrong data returned, got %d bytes, want %d", len(buf), len(want))
+	}
+	rtest.Equals(t, 2, be.ReconnectCount())
+}
+
+func TestLoadResumeLimit(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.Reconnect = true
+	be, err := Create(context.TODO(), cfg)
+	rtest.OK(t, err)
+	defer func() {
+		// ignore the error as the server has been killed
+		_ = be.Close()
+	}()
+
+	data := rtest.Random(23, 1024*1024)
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	saveFile(t, be, h, data)
+
+	rd, err := be.loadReader(context.TODO(), h, 0, 0)
+	rtest.OK(t, err)
+	defer func() {
+		_ = rd.Close()
+	}()
+
+	buf := make([]byte, 1000)
+	for i := 0; i < maxLoadResumes; i++ {
+		killServer(t, be)
+		_, err = rd.Read(buf)
+		rtest.OK(t, err)
+	}
+
+	// reading fails once the connection was lost more often than the limit
+	killServer(t, be)
+	_, err = rd.Read(buf)
+	rtest.Assert(t, isConnectionLost(err), "expected connection lost error, got %v", err)
+}
+
+// killingWriter kills the server once more than after bytes have been
+// written to it.
+type killingWriter struct {
+	bytes.Buffer
+	t      testing.TB
+	be     *SFTP
+	after  int
+	killed bool
+}
+
+func (w *killingWriter) Write(p []byte) (int, error) {
+	n, err := w.Buffer.Write(p)
+	if !w.killed && w.Len() > w.after {
+		w.killed = true
+		killServer(w.t, w.be)
+	}
+	return n, err
+}
+
+func TestLoadResumeWriteTo(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.Reconnect = true
+	be := newTestBackend(t, cfg)
+
+	data := rtest.Random(23, 4*1024*1024)
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	saveFile(t, be, h, data)
+
+	w := &killingWriter{t: t, be: be, after: 100 * 1024}
+	err := be.Load(context.TODO(), h, 0, 0, func(rd io.Reader) error {
+		wt, ok := rd.(io.WriterTo)
+		rtest.Assert(t, ok, "reader %T does not implement WriteTo", rd)
+		_, err := wt.WriteTo(w)
+		return err
+	})
+	rtest.OK(t, err)
+
+	rtest.Assert(t, w.killed, "server was not killed")
+	rtest.Assert(t, bytes.Equal(data, w.Bytes()), "wrong data returned, got %d bytes, want %d", w.Len(), len(data))
+	rtest.Equals(t, 1, be.ReconnectCount())
+}
@@ -0,0 +1,79 @@
+package sftp
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestRemoveEmptyDirs(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		cfg := newTestConfig(t)
+		cfg.RemoveEmptyDirs = enabled
+		be := newTestBackend(t, cfg)
+
+		handle := func(name string) restic.Handle {
+			return restic.Handle{Type: restic.PackFile, Name: name + strings.Repeat("0", 64-len(name))}
+		}
+		for _, name := range []string{"aa1", "aa2", "bb1"} {
+			saveFile(t, be, handle(name), []byte("foobar"))
+		}
+
+		exists := func(dir string) bool {
+			_, err := be.client().Lstat(be.Join(be.Location(), "data", dir))
+			if be.IsNotExist(err) {
+				return false
+			}
+			rtest.OK(t, err)
+			return true
+		}
+
+		// the prefix dir with a remaining file is kept
+		rtest.OK(t, be.Remove(context.TODO(), handle("aa1")))
+		rtest.Assert(t, exists("aa"), "prefix dir with remaining file was removed")
+
+		// removing the last file removes the prefix dir if enabled
+		rtest.OK(t, be.Remove(context.TODO(), handle("aa2")))
+		rtest.OK(t, be.Remove(context.TODO(), handle("bb1")))
+		rtest.Equals(t, !enabled, exists("aa"))
+		rtest.Equals(t, !enabled, exists("bb"))
+
+		// other prefix dirs and the data dir itself are untouched
+		rtest.Assert(t, exists("cc"), "unrelated prefix dir was removed")
+		rtest.Assert(t, exists(""), "data dir was removed")
+
+		// files can still be saved to a removed prefix dir
+		saveFile(t, be, handle("aa3"), []byte("foobar"))
+	}
+}
+
+func TestRemoveEmptyDirsNoSubdirs(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.RemoveEmptyDirs = true
+	be := newTestBackend(t, cfg)
+
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.SnapshotFile, Name: restic.Hash(data).String()}
+	saveFile(t, be, h, data)
+	rtest.OK(t, be.Remove(context.TODO(), h))
+
+	_, err := be.client().Lstat(be.Join(be.Location(), "snapshots"))
+	rtest.OK(t, err)
+}
+
+func TestSaveDirRemovedConcurrently(t *testing.T) {
+	be := newTestBackend(t, fakeServerConfig(faultRemoveDir))
+
+	// the prefix dir is removed after creating the repository, and once more
+	// after Save has created it again
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	saveFile(t, be, h, data)
+
+	fi, err := be.Stat(context.TODO(), h)
+	rtest.OK(t, err)
+	rtest.Equals(t, int64(len(data)), fi.Size)
+}
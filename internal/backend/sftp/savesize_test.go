@@ -0,0 +1,33 @@
+package sftp
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestSaveTruncated(t *testing.T) {
+	cfg := fakeServerConfig(faultTruncate)
+
+	be, err := Create(context.TODO(), cfg)
+	rtest.OK(t, err)
+	defer func() {
+		rtest.OK(t, be.Close())
+	}()
+
+	// the server drops data without reporting an error
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	err = be.Save(context.TODO(), h, restic.NewByteReader(data, nil))
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "has size 5 on the server, but 6 bytes were written"), "expected size mismatch, got %v", err)
+
+	_, err = be.Stat(context.TODO(), h)
+	rtest.Assert(t, be.IsNotExist(err), "truncated file was saved")
+	rtest.Equals(t, []string(nil), dirEntries(t, be, be.Dirname(h)))
+
+	// files outside of the data directory are not affected
+	saveFile(t, be, restic.Handle{Type: restic.ConfigFile}, []byte("config"))
+}
@@ -1,16 +1,22 @@
 package sftp
 
 import (
-	"bufio"
+	"bytes"
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"hash"
 	"io"
+	"net"
 	"os"
 	"os/exec"
 	"path"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/restic/restic/internal/backend"
@@ -27,15 +33,33 @@ import (
 
 // SFTP is a backend in a directory accessed via SFTP.
 type SFTP struct {
-	c *sftp.Client
-	p string
+	conn *connection
 
-	cmd    *exec.Cmd
-	result <-chan error
-
-	posixRename bool
+	p   string
+	tag string
 
 	sem sema.Semaphore
+	// listSem limits the number of concurrent List calls, it is nil if
+	// there is no limit.
+	listSem chan struct{}
+
+	results *resultWriter
+	journal *tempJournal
+	budget  *retryBudget
+	events  *eventStream
+
+	pool *connPool
+
+	// caseCheck records whether the file system of the repository does not
+	// distinguish names which differ only in case.
+	caseCheck *caseCheck
+
+	maintenance *maintenanceState
+	meta        *metaState
+	// creating is set while the repository created by EnsureInitialized
+	// has no config file yet.
+	creating *initLock
+
 	layout.Layout
 	Config
 	backend.Modes
@@ -45,30 +69,71 @@ var _ restic.Backend = &SFTP{}
 
 const defaultLayout = "default"
 
-func startClient(cfg Config) (*SFTP, error) {
+// ErrSSHNotFound is returned if the program to connect to the server could
+// not be found.
+var ErrSSHNotFound = errors.New("ssh binary not found")
+
+func startClient(cfg Config) (_ *SFTP, err error) {
+	if cfg.LocalFallback {
+		return startLocalClient(cfg)
+	}
+	if useNativeSSH(cfg) {
+		if !cfg.UseSSHConfig {
+			return startNativeClient(cfg)
+		}
+
+		// ssh applies its config file itself, so it is only read for
+		// native connections
+		resolved, err := resolveSSHConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		be, err := startNativeClient(resolved)
+		if err != nil {
+			return nil, err
+		}
+		// keep the alias, so that it is resolved again when reconnecting
+		be.Config = cfg
+		return be, nil
+	}
+
 	program, args, err := buildSSHCommand(cfg)
 	if err != nil {
 		return nil, err
 	}
 
+	var hostKeyScript string
+	if cfg.HostKeyFingerprint != "" {
+		hostKeyScript, err = writeHostKeyScript(cfg.HostKeyFingerprint)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			if err != nil {
+				_ = os.Remove(hostKeyScript)
+			}
+		}()
+		args = append(hostKeyArgs(hostKeyScript), args...)
+	}
+
+	release := globalConnLimiter.acquire()
+	defer func() {
+		if err != nil {
+			release()
+		}
+	}()
+
 	debug.Log("start client %v %v", program, args)
 	// Connect to a remote host and request the sftp subsystem via the 'ssh'
 	// command.  This assumes that passwordless login is correctly configured.
 	cmd := exec.Command(program, args...)
+	cmd.Env = buildSSHEnv(cfg)
 
-	// prefix the errors with the program name
 	stderr, err := cmd.StderrPipe()
 	if err != nil {
 		return nil, errors.Wrap(err, "cmd.StderrPipe")
 	}
 
-	go func() {
-		sc := bufio.NewScanner(stderr)
-		for sc.Scan() {
-			fmt.Fprintf(os.Stderr, "subprocess %v: %v\n", program, sc.Text())
-		}
-	}()
-
 	// get stdin and stdout
 	wr, err := cmd.StdinPipe()
 	if err != nil {
@@ -84,21 +149,43 @@ func startClient(cfg Config) (*SFTP, error) {
 		if backend.IsErrDot(err) {
 			return nil, errors.Errorf("cannot implicitly run relative executable %v found in current directory, use -o sftp.command=./<command> to override", cmd.Path)
 		}
+		if errors.Is(err, exec.ErrNotFound) || errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %v, install ssh or use -o sftp.ssh-binary=<path> or -o sftp.command=<command> to override", ErrSSHNotFound, program)
+		}
 		return nil, err
 	}
 
+	fatal := make(chan string, 1)
+	go handleStderr(stderr, program, cfg.StderrPolicy, fatal, func() {
+		_ = cmd.Process.Kill()
+	})
+
 	// wait in a different goroutine
-	ch := make(chan error, 1)
+	ch := newExitResult()
 	go func() {
 		err := cmd.Wait()
 		debug.Log("ssh command exited, err %v", err)
-		for {
-			ch <- errors.Wrap(err, "ssh command exited")
+		release()
+		select {
+		case line := <-fatal:
+			err = fmt.Errorf("%w: %v", ErrFatalStderr, line)
+		default:
 		}
+		if hostKeyScript != "" {
+			_ = os.Remove(hostKeyScript)
+		}
+		ch.exit(errors.Wrap(err, "ssh command exited"))
 	}()
 
 	// open the SFTP session
-	client, err := sftp.NewClientPipe(rd, wr)
+	client, err := startSession(cfg.Timeout, func() (*sftp.Client, error) {
+		return sftp.NewClientPipe(rd, wr)
+	}, func() {
+		_ = cmd.Process.Kill()
+	})
+	if errors.Is(err, ErrTimeout) {
+		return nil, err
+	}
 	if err != nil {
 		return nil, errors.Errorf("unable to start the sftp session, error: %v", err)
 	}
@@ -109,15 +196,29 @@ func startClient(cfg Config) (*SFTP, error) {
 	}
 
 	_, posixRename := client.HasExtension("posix-rename@openssh.com")
-	return &SFTP{c: client, cmd: cmd, result: ch, posixRename: posixRename}, nil
+	conn := &connection{c: client, cmd: cmd, result: ch, posixRename: posixRename}
+	return &SFTP{conn: conn, results: &resultWriter{}, Config: cfg}, nil
 }
 
 // clientError returns an error if the client has exited. Otherwise, nil is
 // returned immediately.
-func (r *SFTP) clientError() error {
+func (r *SFTP) clientError(ctx context.Context) error {
+	r.conn.m.RLock()
+	result, moved := r.conn.result, r.conn.p != r.p
+	r.conn.m.RUnlock()
+
+	if moved {
+		return backoff.Permanent(fmt.Errorf("%w: %v", ErrRepositoryMoved, r.p))
+	}
+
 	select {
-	case err := <-r.result:
+	case <-result.Done():
+		err := result.Err()
 		debug.Log("client has exited with err %v", err)
+		r.emit(BackendEvent{Type: EventError, Err: err})
+		if r.Config.Reconnect {
+			return r.reconnect(ctx, result, err)
+		}
 		return backoff.Permanent(err)
 	default:
 	}
@@ -128,15 +229,102 @@ func (r *SFTP) clientError() error {
 // Open opens an sftp backend as described by the config by running
 // "ssh" with the appropriate arguments (or cfg.Command, if set).
 func Open(ctx context.Context, cfg Config) (*SFTP, error) {
-	debug.Log("open backend with config %#v", cfg)
+	debug.Log("open backend with config %#v", cfg.RedactedConfig())
 
 	sftp, err := startClient(cfg)
 	if err != nil {
 		debug.Log("unable to start program: %v", err)
 		return nil, err
 	}
+	sftp.events = newEventStream(cfg)
 
-	return open(ctx, sftp, cfg)
+	return openClient(ctx, sftp, cfg)
+}
+
+// openClient opens the existing repository described by cfg using the
+// connection of sftp, which is closed if opening fails.
+func openClient(ctx context.Context, sftp *SFTP, cfg Config) (_ *SFTP, err error) {
+	defer func() {
+		if err != nil {
+			_ = sftp.Close()
+		}
+	}()
+
+	cfg.Path, err = sftp.canonicalPath(cfg)
+	if err == nil && cfg.OpenRetries > 0 {
+		err = sftp.waitForPath(ctx, cfg.Path, cfg.OpenRetries)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	be, err := open(ctx, sftp, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	err = be.applyStartupTempPolicy(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return be, nil
+}
+
+// waitForPath checks that the directory p exists. Some servers do not
+// immediately report newly created directories, so the check is retried up to
+// retries times with an exponential backoff.
+func (r *SFTP) waitForPath(ctx context.Context, p string, retries uint) error {
+	bo := backoff.NewExponentialBackOff()
+	bo.InitialInterval = 50 * time.Millisecond
+
+	attempt := 0
+
+	return backoff.RetryNotify(func() error {
+		fi, err := r.client().Stat(p)
+		if err != nil {
+			if r.IsNotExist(err) {
+				return errors.Wrapf(err, "repository directory %v", p)
+			}
+			return backoff.Permanent(errors.Wrap(err, "Stat"))
+		}
+		if !fi.IsDir() {
+			return backoff.Permanent(errors.Errorf("repository path %v is not a directory", p))
+		}
+		return nil
+	}, backoff.WithContext(backoff.WithMaxRetries(bo, uint64(retries)), ctx),
+		func(err error, d time.Duration) {
+			debug.Log("repository directory not found, retrying in %v: %v", d, err)
+			attempt++
+			r.emit(BackendEvent{Type: EventRetry, Operation: "open", Object: p, Attempt: attempt, Err: err})
+		})
+}
+
+// canonicalPath returns the repository path from cfg. If CanonicalizePath is
+// set, the path is resolved by the server to an absolute path first.
+func (r *SFTP) canonicalPath(cfg Config) (string, error) {
+	if !cfg.CanonicalizePath {
+		return cfg.Path, nil
+	}
+
+	p, err := r.client().RealPath(cfg.Path)
+	if err != nil {
+		return "", errors.Wrap(err, "RealPath")
+	}
+
+	debug.Log("canonical path for %v is %v", cfg.Path, p)
+	return p, nil
+}
+
+// parseLayout returns the layout for the repository, including the custom
+// directories from cfg.TypePaths.
+func (r *SFTP) parseLayout(ctx context.Context, cfg Config) (layout.Layout, error) {
+	l, err := layout.ParseLayout(ctx, r, cfg.Layout, defaultLayout, cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	return newTypePathLayout(l, r.Join, cfg.Path, cfg.TypePaths)
 }
 
 func open(ctx context.Context, sftp *SFTP, cfg Config) (*SFTP, error) {
@@ -145,21 +333,57 @@ func open(ctx context.Context, sftp *SFTP, cfg Config) (*SFTP, error) {
 		return nil, err
 	}
 
-	sftp.Layout, err = layout.ParseLayout(ctx, sftp, cfg.Layout, defaultLayout, cfg.Path)
+	sftp.Layout, err = sftp.parseLayout(ctx, cfg)
 	if err != nil {
 		return nil, err
 	}
 
 	debug.Log("layout: %v\n", sftp.Layout)
 
-	fi, err := sftp.c.Stat(sftp.Layout.Filename(restic.Handle{Type: restic.ConfigFile}))
+	fi, err := sftp.client().Stat(sftp.Layout.Filename(restic.Handle{Type: restic.ConfigFile}))
 	m := backend.DeriveModesFromFileInfo(fi, err)
+	if cfg.GroupShared {
+		m = groupSharedModes
+	}
+	m = configuredModes(cfg, m)
 	debug.Log("using (%03O file, %03O dir) permissions", m.File, m.Dir)
 
 	sftp.Config = cfg
-	sftp.p = cfg.Path
+	sftp.setPath(cfg.Path)
 	sftp.sem = sem
 	sftp.Modes = m
+	if err := validModes(cfg); err != nil {
+		return nil, err
+	}
+	if err := sftp.checkSetgid(cfg.Path); err != nil {
+		return nil, err
+	}
+	if err := validOverwritePolicy(cfg.OverwritePolicy); err != nil {
+		return nil, err
+	}
+	if err := validSymlinkPolicy(cfg.SymlinkPolicy); err != nil {
+		return nil, err
+	}
+	if err := validStartupTempPolicy(cfg); err != nil {
+		return nil, err
+	}
+	if cfg.MaxConcurrentLists > 0 {
+		sftp.listSem = make(chan struct{}, cfg.MaxConcurrentLists)
+	}
+	sftp.budget = newRetryBudget(cfg)
+	sftp.maintenance = &maintenanceState{}
+	sftp.meta = &metaState{}
+	if cfg.TempJournal {
+		sftp.journal = newTempJournal(sftp.Join(cfg.Path, journalDir))
+	}
+	sftp.caseCheck = &caseCheck{}
+
+	sftp.pool, err = startPool(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	sftp.emit(BackendEvent{Type: EventConnect, Object: cfg.Path})
 	return sftp, nil
 }
 
@@ -178,10 +402,10 @@ func (r *SFTP) mkdirAllDataSubdirs(ctx context.Context, nconn uint) error {
 			// round trip, not counting duplicate parent creations causes by
 			// concurrency. MkdirAll first does Stat, then recursive MkdirAll
 			// on the parent, so calls typically take three round trips.
-			if err := r.c.Mkdir(d); err == nil {
-				return nil
+			if err := r.client().Mkdir(d); err == nil {
+				return r.chmodDir(d)
 			}
-			return r.c.MkdirAll(d)
+			return r.mkdirAll(d)
 		})
 	}
 
@@ -189,13 +413,17 @@ func (r *SFTP) mkdirAllDataSubdirs(ctx context.Context, nconn uint) error {
 }
 
 // Join combines path components with slashes (according to the sftp spec).
+// If the config specifies a PathJoiner, it is used instead.
 func (r *SFTP) Join(p ...string) string {
+	if r.Config.PathJoiner != nil {
+		return r.Config.PathJoiner(p...)
+	}
 	return path.Join(p...)
 }
 
 // ReadDir returns the entries for a directory.
 func (r *SFTP) ReadDir(ctx context.Context, dir string) ([]os.FileInfo, error) {
-	fi, err := r.c.ReadDir(dir)
+	fi, err := r.client().ReadDir(dir)
 
 	// sftp client does not specify dir name on error, so add it here
 	err = errors.Wrapf(err, "(%v)", dir)
@@ -203,12 +431,30 @@ func (r *SFTP) ReadDir(ctx context.Context, dir string) ([]os.FileInfo, error) {
 	return fi, err
 }
 
-// IsNotExist returns true if the error is caused by a not existing file.
+// IsNotExist returns true if the error is caused by a not existing file. The
+// status code of errors returned by the server is checked instead of the
+// message, which differs between server implementations.
 func (r *SFTP) IsNotExist(err error) bool {
-	return errors.Is(err, os.ErrNotExist)
+	if errors.Is(err, os.ErrNotExist) {
+		return true
+	}
+
+	var statusErr *sftp.StatusError
+	return errors.As(err, &statusErr) && statusErr.FxCode() == sftp.ErrSSHFxNoSuchFile
 }
 
 func buildSSHCommand(cfg Config) (cmd string, args []string, err error) {
+	if cfg.Command != "" && cfg.HostKeyFingerprint != "" {
+		return "", nil, errors.New("the host key fingerprint cannot be checked with a custom command")
+	}
+
+	if cfg.Command != "" && cfg.ProxyJump != "" {
+		return "", nil, errors.New("a jump host cannot be used with a custom command")
+	}
+	if cfg.Command != "" && len(cfg.ExtraArgs) > 0 {
+		return "", nil, errors.New("extra arguments cannot be used with a custom command")
+	}
+
 	if cfg.Command != "" {
 		args, err := backend.SplitShellStrings(cfg.Command)
 		if err != nil {
@@ -219,8 +465,11 @@ func buildSSHCommand(cfg Config) (cmd string, args []string, err error) {
 	}
 
 	cmd = "ssh"
+	if cfg.SSHBinary != "" {
+		cmd = cfg.SSHBinary
+	}
 
-	host, port := cfg.Host, cfg.Port
+	host, port := splitHostPort(cfg.Host, cfg.Port)
 
 	args = []string{host}
 	if port != "" {
@@ -230,35 +479,165 @@ func buildSSHCommand(cfg Config) (cmd string, args []string, err error) {
 		args = append(args, "-l")
 		args = append(args, cfg.User)
 	}
+	if cfg.ProxyJump != "" {
+		jump, err := proxyJumpArg(cfg.ProxyJump)
+		if err != nil {
+			return "", nil, err
+		}
+		args = append(args, "-J", jump)
+	}
+	if err := validExtraArgs(cfg.ExtraArgs); err != nil {
+		return "", nil, err
+	}
+	args = append(args, cfg.ExtraArgs...)
 	args = append(args, "-s")
 	args = append(args, "sftp")
 	return cmd, args, nil
 }
 
+// splitHostPort separates the port from host, which may be given as
+// host:port or [host]:port, and removes the brackets around IPv6 addresses.
+// Bare IPv6 addresses are returned unchanged. An explicit port takes
+// precedence over the one in host.
+func splitHostPort(host, port string) (string, string) {
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		return host[1 : len(host)-1], port
+	}
+	if !strings.HasPrefix(host, "[") && strings.Count(host, ":") != 1 {
+		return host, port
+	}
+
+	h, p, err := net.SplitHostPort(host)
+	if err != nil {
+		return host, port
+	}
+	if port == "" {
+		port = p
+	}
+	return h, port
+}
+
+// proxyJumpArg returns the argument for the -J option of ssh for the
+// comma-separated list of jump hosts in s.
+func proxyJumpArg(s string) (string, error) {
+	hops := strings.Split(s, ",")
+	for i, hop := range hops {
+		hop = strings.TrimSpace(hop)
+		if hop == "" || strings.HasPrefix(hop, "-") || strings.ContainsAny(hop, " \t") {
+			return "", errors.Errorf("invalid jump host %q", hop)
+		}
+		hops[i] = hop
+	}
+	return strings.Join(hops, ","), nil
+}
+
+// sshOptionsWithValue contains the options of ssh which take a value.
+const sshOptionsWithValue = "BbcDEeFIiJLlmOopQRSWw"
+
+// validExtraArgs checks that args only contains options for ssh and their
+// values. Any other argument would be interpreted as the remote command.
+func validExtraArgs(args []string) error {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" || !strings.HasPrefix(arg, "-") || len(arg) < 2 {
+			return errors.Errorf("invalid extra argument %q, only options are allowed", arg)
+		}
+
+		// the value of the last option in a group of flags is either
+		// appended or the next argument
+		for j := 1; j < len(arg); j++ {
+			if !strings.ContainsRune(sshOptionsWithValue, rune(arg[j])) {
+				continue
+			}
+			if j == len(arg)-1 {
+				if i == len(args)-1 {
+					return errors.Errorf("extra argument %q requires a value", arg)
+				}
+				i++
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// buildSSHEnv returns the environment for the ssh process. If nil is
+// returned, the environment of the current process is inherited.
+func buildSSHEnv(cfg Config) []string {
+	if cfg.Env == nil {
+		return nil
+	}
+
+	var env []string
+	if cfg.InheritEnv {
+		for _, kv := range os.Environ() {
+			k, _, _ := strings.Cut(kv, "=")
+			if _, ok := cfg.Env[k]; !ok {
+				env = append(env, kv)
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(cfg.Env))
+	for k := range cfg.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		env = append(env, k+"="+cfg.Env[k])
+	}
+
+	// an empty (but non-nil) slice clears the environment
+	if env == nil {
+		env = []string{}
+	}
+	return env
+}
+
 // Create creates an sftp backend as described by the config by running "ssh"
 // with the appropriate arguments (or cfg.Command, if set).
 func Create(ctx context.Context, cfg Config) (*SFTP, error) {
+	if cfg.ReadOnly {
+		return nil, fmt.Errorf("%w: cannot create a repository", ErrReadOnly)
+	}
+
 	sftp, err := startClient(cfg)
 	if err != nil {
 		debug.Log("unable to start program: %v", err)
 		return nil, err
 	}
+	sftp.events = newEventStream(cfg)
+
+	return createClient(ctx, sftp, cfg)
+}
+
+// createClient creates the repository described by cfg using the connection
+// of sftp, which is closed if creating the repository fails.
+func createClient(ctx context.Context, sftp *SFTP, cfg Config) (_ *SFTP, err error) {
+	defer func() {
+		if err != nil {
+			_ = sftp.Close()
+		}
+	}()
 
-	sftp.Layout, err = layout.ParseLayout(ctx, sftp, cfg.Layout, defaultLayout, cfg.Path)
+	cfg.Path, err = sftp.canonicalPath(cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	sftp.Modes = backend.DefaultModes
+	sftp.Layout, err = sftp.parseLayout(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
 
 	// test if config file already exists
-	_, err = sftp.c.Lstat(sftp.Layout.Filename(restic.Handle{Type: restic.ConfigFile}))
+	_, err = sftp.client().Lstat(sftp.Layout.Filename(restic.Handle{Type: restic.ConfigFile}))
 	if err == nil {
 		return nil, errors.New("config file already exists")
 	}
 
-	// create paths for data and refs
-	if err = sftp.mkdirAllDataSubdirs(ctx, cfg.Connections); err != nil {
+	if err = sftp.createDirs(ctx, cfg); err != nil {
 		return nil, err
 	}
 
@@ -266,6 +645,35 @@ func Create(ctx context.Context, cfg Config) (*SFTP, error) {
 	return open(ctx, sftp, cfg)
 }
 
+// createDirs creates the directories of a new repository.
+func (r *SFTP) createDirs(ctx context.Context, cfg Config) error {
+	if err := validModes(cfg); err != nil {
+		return err
+	}
+
+	r.Modes = backend.DefaultModes
+	if cfg.GroupShared {
+		r.Modes = groupSharedModes
+	}
+	r.Modes = configuredModes(cfg, r.Modes)
+
+	if cfg.GroupShared || cfg.DirMode != 0 {
+		// create the repository directory first, so that its permissions
+		// can be set and it can be checked whether the server supports the
+		// setgid bit
+		err := r.mkdirAll(cfg.Path)
+		if err == nil {
+			err = r.checkSetgid(cfg.Path)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// create paths for data and refs
+	return r.mkdirAllDataSubdirs(ctx, cfg.Connections)
+}
+
 func (r *SFTP) Connections() uint {
 	return r.Config.Connections
 }
@@ -282,7 +690,9 @@ func (r *SFTP) Hasher() hash.Hash {
 
 // HasAtomicReplace returns whether Save() can atomically replace files
 func (r *SFTP) HasAtomicReplace() bool {
-	return r.posixRename
+	r.conn.m.RLock()
+	defer r.conn.m.RUnlock()
+	return r.conn.posixRename
 }
 
 // Join joins the given paths and cleans them afterwards. This always uses
@@ -302,72 +712,101 @@ func tempSuffix() string {
 	return hex.EncodeToString(nonce[:])
 }
 
-// Save stores data in the backend at the handle.
+// Save stores data in the backend at the handle. The config file is read back
+// and compared to the data before it is moved into place. If
+// Config.VerifyUploads is set, the size of the saved file is checked. If
+// Config.VerifyListAfterSave is set, Save waits until the server reports that
+// the file exists.
 func (r *SFTP) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
-	debug.Log("Save %v", h)
-	if err := r.clientError(); err != nil {
+	debug.Log("Save %v%v", h, r.tagInfo())
+	if err := r.checkReadOnly("save"); err != nil {
 		return err
 	}
 
-	if err := h.Valid(); err != nil {
-		return backoff.Permanent(err)
+	defer r.trackOp("save", objectName(h), clockFor(r.Config).Now())
+	start := clockFor(r.Config).Now()
+	var transferred int64
+	err := r.saveWithRetry(ctx, h, rd, nil, &transferred)
+	if err == nil && r.Config.VerifyListAfterSave {
+		err = r.waitVisible(ctx, h)
+	}
+	if err == nil {
+		// Save leaves flushing the file and its directory to the server,
+		// which can only be forced if it supports fsync, see SaveVerified
+		r.warnNotDurable(h)
+	}
+	if err == nil && h.Type == restic.ConfigFile {
+		// the repository created by EnsureInitialized is complete now
+		r.releaseInitLock()
 	}
 
-	filename := r.Filename(h)
-	tmpFilename := filename + "-restic-temp-" + tempSuffix()
-	dirname := r.Dirname(h)
+	if r.Config.Metrics != nil {
+		r.reportTransfer(Upload, h, atomic.LoadInt64(&transferred), start, err)
+	}
+	return classifyError(err)
+}
 
-	r.sem.GetToken()
-	defer r.sem.ReleaseToken()
+// saveWithRetry runs save with the timeout of the operation. If the
+// connection is lost, rd is rewound and save is run again.
+func (r *SFTP) saveWithRetry(ctx context.Context, h restic.Handle, rd restic.RewindReader, verify func(f *sftp.File, tmpFilename string) error, transferred *int64) error {
+	retry := false
+	return r.retryOnConnectionLoss(ctx, "save", objectName(h), func() error {
+		if retry {
+			if err := rd.Rewind(); err != nil {
+				return backoff.Permanent(errors.Wrap(err, "Rewind"))
+			}
+		}
+		retry = true
+		return r.runWithTimeout(ctx, "save", func(ctx context.Context) error {
+			return r.save(ctx, h, rd, verify, transferred)
+		})
+	})
+}
 
-	// create new file
-	f, err := r.c.OpenFile(tmpFilename, os.O_CREATE|os.O_EXCL|os.O_WRONLY)
+// SaveVerified stores data in the backend at the handle like Save, but only
+// moves the file into place after it has been synced to disk on the server
+// and its contents read back from the server match the SHA-256 hash
+// expected. Afterwards, the directory containing the file is synced as well.
+// Syncing is skipped if the server does not support the fsync extension.
+func (r *SFTP) SaveVerified(ctx context.Context, h restic.Handle, rd restic.RewindReader, expected restic.ID) error {
+	debug.Log("SaveVerified %v%v", h, r.tagInfo())
+	if err := r.checkReadOnly("save"); err != nil {
+		return err
+	}
 
-	if r.IsNotExist(err) {
-		// error is caused by a missing directory, try to create it
-		mkdirErr := r.c.MkdirAll(r.Dirname(h))
-		if mkdirErr != nil {
-			debug.Log("error creating dir %v: %v", r.Dirname(h), mkdirErr)
-		} else {
-			// try again
-			f, err = r.c.OpenFile(tmpFilename, os.O_CREATE|os.O_EXCL|os.O_WRONLY)
+	err := r.save(ctx, h, rd, func(f *sftp.File, tmpFilename string) error {
+		if err := r.syncFile(f); err != nil {
+			return errors.Wrap(err, "Sync")
 		}
-	}
 
-	// pkg/sftp doesn't allow creating with a mode.
-	// Chmod while the file is still empty.
-	if err == nil {
-		err = f.Chmod(r.Modes.File)
-	}
+		return r.verifyFile(h, tmpFilename, expected)
+	}, nil)
 	if err != nil {
-		return errors.Wrap(err, "OpenFile")
+		return err
 	}
 
-	defer func() {
-		if err == nil {
-			return
-		}
-
-		// Try not to leave a partial file behind.
-		rmErr := r.c.Remove(f.Name())
-		if rmErr != nil {
-			debug.Log("sftp: failed to remove broken file %v: %v",
-				f.Name(), rmErr)
-		}
-	}()
+	r.warnNotDurable(h)
+	return r.syncDir(r.Dirname(h))
+}
 
-	// save data, make sure to use the optimized sftp upload method
-	wbytes, err := f.ReadFrom(rd)
+// verifyFile reads back the file at filename, which is saved for h, and
+// checks that its content has the expected hash.
+func (r *SFTP) verifyFile(h restic.Handle, filename string, expected restic.ID) error {
+	f, err := r.client().Open(filename)
 	if err != nil {
-		_ = f.Close()
-		err = r.checkNoSpace(dirname, rd.Length(), err)
-		return errors.Wrap(err, "Write")
+		return errors.Wrap(err, "Open")
 	}
 
-	// sanity check
-	if wbytes != rd.Length() {
+	var rd io.Reader = f
+	if transform := r.readTransform(h); transform != nil {
+		rd = transform(f)
+	}
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, rd)
+	if err != nil {
 		_ = f.Close()
-		return errors.Errorf("wrote %d bytes instead of the expected %d bytes", wbytes, rd.Length())
+		return errors.Wrap(err, "Read")
 	}
 
 	err = f.Close()
@@ -375,50 +814,355 @@ func (r *SFTP) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader
 		return errors.Wrap(err, "Close")
 	}
 
-	// Prefer POSIX atomic rename if available.
-	if r.posixRename {
-		err = r.c.PosixRename(tmpFilename, filename)
-	} else {
-		err = r.c.Rename(tmpFilename, filename)
+	var id restic.ID
+	copy(id[:], hash.Sum(nil))
+	if !id.Equal(expected) {
+		return backoff.Permanent(errors.Errorf("hash mismatch for %v: expected %v, got %v", filename, expected.Str(), id.Str()))
 	}
-	return errors.Wrap(err, "Rename")
+
+	return nil
 }
 
-// checkNoSpace checks if err was likely caused by lack of available space
-// on the remote, and if so, makes it permanent.
-func (r *SFTP) checkNoSpace(dir string, size int64, origErr error) error {
-	// The SFTP protocol has a message for ENOSPC,
-	// but pkg/sftp doesn't export it and OpenSSH's sftp-server
-	// sends FX_FAILURE instead.
+// verifyContent reads back the file at filename, which is saved for h, and
+// checks that its content matches the data from rd, which is rewound first.
+func (r *SFTP) verifyContent(h restic.Handle, filename string, rd restic.RewindReader) error {
+	err := rd.Rewind()
+	if err != nil {
+		return errors.Wrap(err, "Rewind")
+	}
+	expected, err := io.ReadAll(rd)
+	if err != nil {
+		return errors.Wrap(err, "ReadAll")
+	}
+
+	f, err := r.client().Open(filename)
+	if err != nil {
+		return errors.Wrap(err, "Open")
+	}
+
+	var frd io.Reader = f
+	if transform := r.readTransform(h); transform != nil {
+		frd = transform(f)
+	}
 
-	e, ok := origErr.(*sftp.StatusError)
-	_, hasExt := r.c.HasExtension("statvfs@openssh.com")
-	if !ok || e.FxCode() != sftp.ErrSSHFxFailure || !hasExt {
-		return origErr
+	// read one more byte to detect files which are too long
+	buf, err := io.ReadAll(io.LimitReader(frd, int64(len(expected))+1))
+	if err != nil {
+		_ = f.Close()
+		return errors.Wrap(err, "Read")
 	}
 
-	fsinfo, err := r.c.StatVFS(dir)
+	err = f.Close()
 	if err != nil {
-		debug.Log("sftp: StatVFS returned %v", err)
-		return origErr
+		return errors.Wrap(err, "Close")
 	}
-	if fsinfo.Favail == 0 || fsinfo.Frsize*fsinfo.Bavail < uint64(size) {
-		err := errors.New("sftp: no space left on device")
-		return backoff.Permanent(err)
+
+	if !bytes.Equal(buf, expected) {
+		return backoff.Permanent(errors.Errorf("content mismatch for %v: data read back differs from the data written", filename))
 	}
-	return origErr
-}
 
-// Load runs fn with a reader that yields the contents of the file at h at the
-// given offset.
-func (r *SFTP) Load(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
-	return backend.DefaultLoad(ctx, h, length, offset, r.openReader, fn)
+	return nil
 }
 
-// wrapReader wraps an io.ReadCloser to run an additional function on Close.
-type wrapReader struct {
-	io.ReadCloser
-	io.WriterTo
+// closeOnCancel closes c if ctx is cancelled before the returned function is
+// called. The function reports whether c has been closed.
+func closeOnCancel(ctx context.Context, c io.Closer) (stop func() bool) {
+	done := make(chan struct{})
+	closed := make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			debug.Log("context cancelled, closing file")
+			_ = c.Close()
+			closed <- true
+		case <-done:
+			closed <- false
+		}
+	}()
+
+	return func() bool {
+		close(done)
+		return <-closed
+	}
+}
+
+// syncFile flushes f to stable storage on the server, if the server supports
+// that.
+func (r *SFTP) syncFile(f *sftp.File) error {
+	if _, ok := r.client().HasExtension("fsync@openssh.com"); !ok {
+		debug.Log("server does not support fsync, not syncing %v", f.Name())
+		return nil
+	}
+
+	return f.Sync()
+}
+
+// syncDir flushes the directory entries of dir to stable storage on the
+// server, if the server supports that.
+func (r *SFTP) syncDir(dir string) error {
+	if _, ok := r.client().HasExtension("fsync@openssh.com"); !ok {
+		debug.Log("server does not support fsync, not syncing %v", dir)
+		return nil
+	}
+
+	r.sem.GetToken()
+	defer r.sem.ReleaseToken()
+
+	d, err := r.client().Open(dir)
+	if err != nil {
+		return errors.Wrap(err, "Open dir")
+	}
+
+	err = d.Sync()
+	if err != nil {
+		_ = d.Close()
+		return errors.Wrap(err, "Sync dir")
+	}
+
+	return errors.Wrap(d.Close(), "Close dir")
+}
+
+// save writes rd to a temporary file which is then renamed to the filename
+// for h. If verify is not nil, it is called with the still open temporary
+// file after all data has been written and the file is only renamed if it
+// returns nil. If transferred is not nil, the number of bytes written to the
+// server is added to it.
+func (r *SFTP) save(ctx context.Context, h restic.Handle, rd restic.RewindReader, verify func(f *sftp.File, tmpFilename string) error, transferred *int64) error {
+	if err := r.clientError(ctx); err != nil {
+		return err
+	}
+
+	if err := h.Valid(); err != nil {
+		return backoff.Permanent(err)
+	}
+	if err := r.checkName(h); err != nil {
+		return err
+	}
+
+	if verify == nil && h.Type == restic.ConfigFile {
+		// a broken config file makes the repository unusable, so always
+		// check it before it is moved into place
+		verify = func(f *sftp.File, tmpFilename string) error {
+			return r.verifyContent(h, tmpFilename, rd)
+		}
+	}
+
+	policy, err := r.overwritePolicy(ctx)
+	if err != nil {
+		return backoff.Permanent(err)
+	}
+
+	filename := r.Filename(h)
+	skip, err := r.checkOverwrite(policy, filename)
+	if err != nil || skip {
+		return err
+	}
+
+	src, err := uploadSource(rd)
+	if err != nil {
+		return backoff.Permanent(err)
+	}
+
+	var checksum hash.Hash
+	if r.Config.StoreChecksums {
+		checksum = sha256.New()
+		src = io.TeeReader(src, checksum)
+	}
+	src = r.progress(h, rd.Length(), src)
+	if w := watchdogFrom(ctx); w != nil {
+		src = &pausingReader{Reader: src, w: w}
+	}
+	// the wrappers hide the size of rd, ReadFrom only uploads concurrently if
+	// it knows how much data there is
+	src = &sizedReader{Reader: src, size: rd.Length()}
+
+	tmpFilename := r.tempFilename(h)
+	dirname := r.Dirname(h)
+
+	r.sem.GetToken()
+	defer r.sem.ReleaseToken()
+
+	// record the temporary file before creating it, and only forget about
+	// it once it has been renamed or removed
+	leaked := false
+	r.journalAdd(tmpFilename)
+	defer func() {
+		if !leaked {
+			r.journalRemove(tmpFilename)
+		}
+	}()
+
+	// create new file, and the directory if it is missing
+	c := r.transferClient(ctx)
+	var f *sftp.File
+	err = r.withDir(h, func() (err error) {
+		f, err = c.OpenFile(tmpFilename, os.O_CREATE|os.O_EXCL|os.O_WRONLY)
+		return err
+	})
+
+	err = r.checkTempCollision(tmpFilename, err)
+
+	// pkg/sftp doesn't allow creating with a mode.
+	// Chmod while the file is still empty.
+	if err == nil {
+		err = f.Chmod(r.Modes.File)
+		leaked = err != nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "OpenFile")
+	}
+
+	defer func() {
+		if err == nil {
+			return
+		}
+
+		// Try not to leave a partial file behind.
+		rmErr := r.client().Remove(f.Name())
+		if rmErr != nil {
+			debug.Log("sftp: failed to remove broken file %v: %v",
+				f.Name(), rmErr)
+			leaked = true
+		}
+	}()
+
+	// save data, the upload is aborted by closing the file when ctx is
+	// cancelled
+	stop := closeOnCancel(ctx, f)
+	written := &countingWriter{f: f}
+	wbytes, err := r.writeData(h, written, src)
+	if transferred != nil {
+		atomic.AddInt64(transferred, written.n)
+	}
+	if stop() {
+		err = ctx.Err()
+		return err
+	}
+	if err != nil {
+		_ = f.Close()
+		err = checkNoSpace(r.client(), dirname, rd.Length(), err)
+		return errors.Wrap(err, "Write")
+	}
+
+	// sanity check
+	if wbytes != rd.Length() {
+		_ = f.Close()
+		err = errors.Errorf("wrote %d bytes instead of the expected %d bytes", wbytes, rd.Length())
+		return err
+	}
+
+	if verify != nil {
+		err = verify(f, tmpFilename)
+		if err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+
+	err = f.Close()
+	if err != nil {
+		return errors.Wrap(err, "Close")
+	}
+
+	// the server may have lost data without reporting an error
+	fi, err := r.client().Lstat(tmpFilename)
+	if err != nil {
+		return errors.Wrap(err, "Lstat")
+	}
+	if fi.Size() != written.n {
+		err = errors.Errorf("file %v has size %d on the server, but %d bytes were written", tmpFilename, fi.Size(), written.n)
+		return err
+	}
+
+	err = r.rename(policy, tmpFilename, filename)
+	if errors.Is(err, ErrFileExists) && policy == OverwritePolicySkip {
+		// the file has been created concurrently, remove the temporary file
+		debug.Log("%v already exists, skipping", filename)
+		err = nil
+		rmErr := r.client().Remove(tmpFilename)
+		if rmErr != nil {
+			debug.Log("sftp: failed to remove temp file %v: %v", tmpFilename, rmErr)
+			leaked = true
+		}
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "Rename")
+	}
+
+	if r.Config.VerifyUploads {
+		if verr := r.verifyUpload(filename, written.n); verr != nil {
+			return verr
+		}
+	}
+
+	// the checksum is only stored for files which have been saved
+	if checksum != nil {
+		var sum restic.ID
+		copy(sum[:], checksum.Sum(nil))
+		return r.writeChecksum(h, sum)
+	}
+	return nil
+}
+
+// countingWriter counts the bytes written to f. It implements io.ReaderFrom,
+// so that the optimized upload method of f is still used.
+type countingWriter struct {
+	f *sftp.File
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.f.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+func (w *countingWriter) ReadFrom(rd io.Reader) (int64, error) {
+	n, err := w.f.ReadFrom(rd)
+	w.n += n
+	return n, err
+}
+
+// sizedReader reports the number of bytes which can be read from it to
+// ReadFrom of sftp.File, which then uploads the data with concurrent requests.
+type sizedReader struct {
+	io.Reader
+	size int64
+}
+
+func (rd *sizedReader) Size() int64 {
+	return rd.size
+}
+
+// Load runs fn with a reader that yields the contents of the file at h at the
+// given offset.
+func (r *SFTP) Load(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+	if r.Config.Metrics == nil {
+		err := r.retryOnConnectionLoss(ctx, "load", objectName(h), func() error {
+			return r.runWithTimeout(ctx, "load", func(ctx context.Context) error {
+				return backend.DefaultLoad(ctx, h, length, offset, r.loadReader, watchLoad(ctx, fn))
+			})
+		})
+		return classifyError(err)
+	}
+
+	start := clockFor(r.Config).Now()
+	var n int64
+	err := r.retryOnConnectionLoss(ctx, "load", objectName(h), func() error {
+		atomic.StoreInt64(&n, 0)
+		return r.runWithTimeout(ctx, "load", func(ctx context.Context) error {
+			return backend.DefaultLoad(ctx, h, length, offset, r.countingOpenReader(&n), watchLoad(ctx, fn))
+		})
+	})
+	r.reportTransfer(Download, h, atomic.LoadInt64(&n), start, err)
+	return classifyError(err)
+}
+
+// wrapReader wraps an io.ReadCloser to run an additional function on Close.
+type wrapReader struct {
+	io.ReadCloser
+	io.WriterTo
 	f func()
 }
 
@@ -428,25 +1172,57 @@ func (wr *wrapReader) Close() error {
 	return err
 }
 
+// ErrOffsetBeyondEOF is returned by Load if the offset is larger than the
+// size of the file.
+var ErrOffsetBeyondEOF = errors.New("sftp: offset beyond end of file")
+
+// checkOffset returns an error wrapping ErrOffsetBeyondEOF if offset is
+// larger than the size of f. Reading at the end of the file returns no data,
+// reads which extend past the end return the available data.
+func checkOffset(f *sftp.File, offset int64) error {
+	fi, err := f.Stat()
+	if err != nil {
+		return errors.Wrap(err, "Stat")
+	}
+	if offset > fi.Size() {
+		return backoff.Permanent(fmt.Errorf("%w: offset %d, size %d", ErrOffsetBeyondEOF, offset, fi.Size()))
+	}
+	return nil
+}
+
 func (r *SFTP) openReader(ctx context.Context, h restic.Handle, length int, offset int64) (io.ReadCloser, error) {
-	debug.Log("Load %v, length %v, offset %v", h, length, offset)
+	debug.Log("Load %v, length %v, offset %v%v", h, length, offset, r.tagInfo())
+	if err := r.clientError(ctx); err != nil {
+		return nil, err
+	}
+
 	if err := h.Valid(); err != nil {
 		return nil, backoff.Permanent(err)
 	}
+	if err := r.checkName(h); err != nil {
+		return nil, err
+	}
 
 	if offset < 0 {
 		return nil, errors.New("offset is negative")
 	}
 
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	r.sem.GetToken()
-	f, err := r.c.Open(r.Filename(h))
+	f, err := r.transferClient(ctx).Open(r.Filename(h))
 	if err != nil {
 		r.sem.ReleaseToken()
 		return nil, err
 	}
 
-	if offset > 0 {
-		_, err = f.Seek(offset, 0)
+	if offset > 0 && r.readTransform(h) == nil {
+		err = checkOffset(f, offset)
+		if err == nil {
+			_, err = f.Seek(offset, 0)
+		}
 		if err != nil {
 			r.sem.ReleaseToken()
 			_ = f.Close()
@@ -455,7 +1231,7 @@ func (r *SFTP) openReader(ctx context.Context, h restic.Handle, length int, offs
 	}
 
 	// use custom close wrapper to also provide WriteTo() on the wrapper
-	rd := &wrapReader{
+	var rd io.ReadCloser = &wrapReader{
 		ReadCloser: f,
 		WriterTo:   f,
 		f: func() {
@@ -463,6 +1239,19 @@ func (r *SFTP) openReader(ctx context.Context, h restic.Handle, length int, offs
 		},
 	}
 
+	if r.readTransform(h) != nil {
+		rd, err = r.transformReader(h, rd, offset)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	total := int64(-1)
+	if length > 0 {
+		total = int64(length)
+	}
+	rd = r.progressCloser(h, total, rd)
+
 	if length > 0 {
 		// unlimited reads usually use io.Copy which needs WriteTo support at the underlying reader
 		// limited reads are usually combined with io.ReadFull which reads all required bytes into a buffer in one go
@@ -473,20 +1262,33 @@ func (r *SFTP) openReader(ctx context.Context, h restic.Handle, length int, offs
 }
 
 // Stat returns information about a blob.
-func (r *SFTP) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
-	debug.Log("Stat(%v)", h)
-	if err := r.clientError(); err != nil {
+func (r *SFTP) Stat(ctx context.Context, h restic.Handle) (_ restic.FileInfo, err error) {
+	debug.Log("Stat(%v)%v", h, r.tagInfo())
+	defer r.trackOp("stat", objectName(h), clockFor(r.Config).Now())
+	defer func() {
+		err = classifyError(err)
+	}()
+	if err := r.clientError(ctx); err != nil {
 		return restic.FileInfo{}, err
 	}
 
 	if err := h.Valid(); err != nil {
 		return restic.FileInfo{}, backoff.Permanent(err)
 	}
+	if err := r.checkName(h); err != nil {
+		return restic.FileInfo{}, err
+	}
 
 	r.sem.GetToken()
 	defer r.sem.ReleaseToken()
 
-	fi, err := r.c.Lstat(r.Filename(h))
+	var fi os.FileInfo
+	err = r.retryOnConnectionLoss(ctx, "stat", objectName(h), func() error {
+		return r.runWithTimeout(ctx, "stat", func(ctx context.Context) (err error) {
+			fi, err = r.client().Lstat(r.Filename(h))
+			return err
+		})
+	})
 	if err != nil {
 		return restic.FileInfo{}, errors.Wrap(err, "Lstat")
 	}
@@ -494,27 +1296,323 @@ func (r *SFTP) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, erro
 	return restic.FileInfo{Size: fi.Size(), Name: h.Name}, nil
 }
 
-// Remove removes the content stored at name.
-func (r *SFTP) Remove(ctx context.Context, h restic.Handle) error {
-	debug.Log("Remove(%v)", h)
-	if err := r.clientError(); err != nil {
+// Remove removes the content stored at name. If a trash directory is
+// configured, the file is moved there instead. The stored checksum and
+// metadata are only removed together with the file, they are kept for files
+// in the trash.
+func (r *SFTP) Remove(ctx context.Context, h restic.Handle) (err error) {
+	debug.Log("Remove(%v)%v", h, r.tagInfo())
+	if err := r.checkReadOnly("remove"); err != nil {
+		return err
+	}
+
+	defer r.trackOp("remove", objectName(h), clockFor(r.Config).Now())
+	defer func() {
+		err = classifyError(err)
+	}()
+	if err := r.clientError(ctx); err != nil {
+		return err
+	}
+
+	if err := r.checkName(h); err != nil {
 		return err
 	}
 
 	r.sem.GetToken()
 	defer r.sem.ReleaseToken()
 
-	return r.c.Remove(r.Filename(h))
+	// a retried request may fail because the file was already removed by the
+	// request interrupted by the lost connection
+	retry := false
+	if r.Config.TrashDir != "" {
+		return r.retryOnConnectionLoss(ctx, "remove", objectName(h), func() error {
+			err := r.runWithTimeout(ctx, "remove", func(ctx context.Context) error {
+				return r.moveToTrash(h)
+			})
+			if retry && r.IsNotExist(err) {
+				return nil
+			}
+			retry = true
+			return err
+		})
+	}
+
+	err = r.retryOnConnectionLoss(ctx, "remove", objectName(h), func() error {
+		err := r.runWithTimeout(ctx, "remove", func(ctx context.Context) error {
+			return r.client().Remove(r.Filename(h))
+		})
+		if retry && r.IsNotExist(err) {
+			return nil
+		}
+		retry = true
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if r.Config.StoreChecksums {
+		r.removeChecksum(h)
+	}
+	if r.metaInUse() {
+		r.removeMeta(h)
+	}
+	if r.Config.RemoveEmptyDirs {
+		r.removeEmptyDir(h)
+	}
+	return nil
+}
+
+// removeEmptyDir removes the subdirectory which contained the file for h if
+// it is empty now. The base directory of the file type is never removed.
+// Errors are only logged, another process may have saved a file into the
+// directory in the meantime. A concurrent Save which loses the directory
+// after creating it creates it again, see withDir.
+func (r *SFTP) removeEmptyDir(h restic.Handle) {
+	basedir, subdirs := r.Basedir(h.Type)
+	dir := r.Join(r.Dirname(h))
+	if !subdirs || dir == r.Join(basedir) {
+		return
+	}
+
+	entries, err := r.client().ReadDir(dir)
+	if err != nil || len(entries) > 0 {
+		return
+	}
+
+	// RemoveDirectory fails if a file has been added concurrently
+	err = r.client().RemoveDirectory(dir)
+	if err != nil {
+		debug.Log("unable to remove empty dir %v: %v", dir, err)
+		return
+	}
+	debug.Log("removed empty dir %v", dir)
+}
+
+// defaultMaxListDepth is the default for the maximum depth of directories
+// below the base directory of a file type that List descends into. The
+// layouts only use a single level of subdirectories, so this is only a safety
+// bound against misbehaving servers.
+const defaultMaxListDepth = 8
+
+// listDepth returns the number of path components of p below basedir.
+func listDepth(basedir, p string) uint {
+	rel := strings.TrimPrefix(strings.TrimPrefix(p, basedir), "/")
+	return uint(strings.Count(rel, "/") + 1)
 }
 
 // List runs fn for each file in the backend which has the type t. When an
 // error occurs (or fn returns an error), List stops and returns it.
 func (r *SFTP) List(ctx context.Context, t restic.FileType, fn func(restic.FileInfo) error) error {
-	debug.Log("List %v", t)
+	debug.Log("List %v%v", t, r.tagInfo())
+	defer r.trackOp("list", t.String(), clockFor(r.Config).Now())
+	if err := r.clientError(ctx); err != nil {
+		return err
+	}
+
+	if r.listSem != nil {
+		select {
+		case r.listSem <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		defer func() {
+			<-r.listSem
+		}()
+	}
 
 	basedir, subdirs := r.Basedir(t)
-	walker := r.c.Walk(basedir)
+
+	// names which have already been passed to fn, so that they are not
+	// reported twice when the listing is resumed after a reconnect
+	var sent map[string]struct{}
+	if r.Config.Reconnect {
+		sent = make(map[string]struct{})
+	}
+
+	for resumes := 0; ; resumes++ {
+		r.conn.m.RLock()
+		c, result := r.conn.c, r.conn.result
+		r.conn.m.RUnlock()
+
+		var lost bool
+		err := r.runWithTimeout(ctx, "list", func(ctx context.Context) (err error) {
+			lost, err = r.walk(ctx, c, basedir, subdirs, sent, watchList(ctx, fn))
+			return err
+		})
+		// a listing which timed out is not resumed
+		if errors.Is(err, ErrTimeout) || !lost {
+			return err
+		}
+
+		debug.Log("listing %v interrupted: %v", basedir, err)
+		interrupted := backoff.Permanent(fmt.Errorf("%w: %v", ErrListInterrupted, err))
+		if !r.Config.Reconnect || resumes >= maxListResumes {
+			r.emit(BackendEvent{Type: EventError, Operation: "list", Object: t.String(), Err: err})
+			return interrupted
+		}
+
+		delay := r.Config.RetryBackoff
+		if delay <= 0 {
+			delay = defaultRetryBackoff
+		}
+		lost, rerr := r.awaitConnectionLoss(ctx, result, err, delay)
+		if rerr != nil {
+			return rerr
+		}
+		if !lost {
+			// the connection is still alive
+			return err
+		}
+		if berr := r.takeRetry("list", interrupted); berr != nil {
+			return berr
+		}
+		r.emit(BackendEvent{Type: EventRetry, Operation: "list", Object: t.String(), Attempt: resumes + 1})
+	}
+}
+
+// ErrListInterrupted is returned by List when the server closed the
+// connection before all files were listed.
+var ErrListInterrupted = errors.New("listing interrupted by server")
+
+// maxListResumes is the number of times List resumes an interrupted listing
+// after reconnecting.
+const maxListResumes = 3
+
+// isConnectionLost returns true if err was caused by the server closing the
+// connection. Once the client has noticed that, it closes its end of the
+// pipe, so that requests sent afterwards fail with os.ErrClosed.
+func isConnectionLost(err error) bool {
+	return errors.Is(err, sftp.ErrSSHFxConnectionLost) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, os.ErrClosed) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.EPIPE)
+}
+
+// listWorkers is the number of subdirectories of a base directory which are
+// listed concurrently.
+const listWorkers = 8
+
+// walk runs fn for each file below basedir using the client c, skipping the
+// names in sent. If sent is not nil, all names passed to fn are added to it.
+// If the walk failed because the connection was lost, lost is true. The
+// subdirectories of basedir are listed concurrently, but fn is only called
+// from the goroutine running walk.
+func (r *SFTP) walk(ctx context.Context, c *sftp.Client, basedir string, subdirs bool, sent map[string]struct{}, fn func(restic.FileInfo) error) (lost bool, err error) {
+	// files directly in basedir
+	lost, err = r.walkDir(ctx, c, basedir, basedir, false, sent, fn)
+	if err != nil || !subdirs {
+		return lost, err
+	}
+
+	r.sem.GetToken()
+	entries, err := c.ReadDir(basedir)
+	r.sem.ReleaseToken()
+	if r.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return isConnectionLost(err), err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	wg, wctx := errgroup.WithContext(ctx)
+	dirs := make(chan string)
+	files := make(chan restic.FileInfo)
+
+	wg.Go(func() error {
+		defer close(dirs)
+		for _, fi := range entries {
+			if !fi.IsDir() {
+				continue
+			}
+			select {
+			case dirs <- r.Join(basedir, fi.Name()):
+			case <-wctx.Done():
+				return wctx.Err()
+			}
+		}
+		return nil
+	})
+
+	var lostConn int32
+	for i := 0; i < listWorkers; i++ {
+		wg.Go(func() error {
+			for dir := range dirs {
+				lost, err := r.walkDir(wctx, c, basedir, dir, true, nil, func(fi restic.FileInfo) error {
+					select {
+					case files <- fi:
+						return nil
+					case <-wctx.Done():
+						return wctx.Err()
+					}
+				})
+				if err != nil {
+					if lost {
+						atomic.StoreInt32(&lostConn, 1)
+					}
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- wg.Wait()
+		close(files)
+	}()
+
+	for fi := range files {
+		if _, ok := sent[fi.Name]; ok {
+			continue
+		}
+
+		err = ctx.Err()
+		if err == nil {
+			err = fn(fi)
+		}
+		if err != nil {
+			// stop the workers and wait for them to finish
+			cancel()
+			for range files {
+			}
+			<-errCh
+			return false, err
+		}
+
+		if sent != nil {
+			sent[fi.Name] = struct{}{}
+		}
+	}
+
+	err = <-errCh
+	if err != nil {
+		return atomic.LoadInt32(&lostConn) == 1, err
+	}
+	return false, ctx.Err()
+}
+
+// walkDir runs fn for each file below dir using the client c like walk, but
+// without listing subdirectories concurrently. The depth of files is
+// measured relative to basedir.
+func (r *SFTP) walkDir(ctx context.Context, c *sftp.Client, basedir, dir string, subdirs bool, sent map[string]struct{}, fn func(restic.FileInfo) error) (lost bool, err error) {
+	maxDepth := r.Config.MaxListDepth
+	if maxDepth == 0 {
+		maxDepth = defaultMaxListDepth
+	}
+
+	walker := c.Walk(dir)
 	for {
+		// stop walking directories as soon as the caller gave up
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+
 		r.sem.GetToken()
 		ok := walker.Step()
 		r.sem.ReleaseToken()
@@ -525,12 +1623,12 @@ func (r *SFTP) List(ctx context.Context, t restic.FileType, fn func(restic.FileI
 		if walker.Err() != nil {
 			if r.IsNotExist(walker.Err()) {
 				debug.Log("ignoring non-existing directory")
-				return nil
+				return false, nil
 			}
-			return walker.Err()
+			return isConnectionLost(walker.Err()), walker.Err()
 		}
 
-		if walker.Path() == basedir {
+		if walker.Path() == dir {
 			continue
 		}
 
@@ -539,36 +1637,82 @@ func (r *SFTP) List(ctx context.Context, t restic.FileType, fn func(restic.FileI
 			continue
 		}
 
+		if depth := listDepth(basedir, walker.Path()); depth > maxDepth {
+			return false, backoff.Permanent(errors.Errorf("directory %v exceeds the maximum listing depth of %d", walker.Path(), maxDepth))
+		}
+
 		fi := walker.Stat()
+		if fi.Mode()&os.ModeSymlink != 0 {
+			fi, err = r.resolveSymlink(c, walker.Path())
+			if err != nil {
+				return false, err
+			}
+			if fi == nil {
+				continue
+			}
+		}
+
 		if !fi.Mode().IsRegular() {
 			continue
 		}
 
-		debug.Log("send %v\n", path.Base(walker.Path()))
+		name := path.Base(walker.Path())
+		if _, ok := sent[name]; ok {
+			continue
+		}
+
+		debug.Log("send %v\n", name)
 
 		rfi := restic.FileInfo{
-			Name: path.Base(walker.Path()),
+			Name: name,
 			Size: fi.Size(),
 		}
 
-		if ctx.Err() != nil {
-			return ctx.Err()
-		}
-
 		err := fn(rfi)
 		if err != nil {
-			return err
+			return false, err
+		}
+
+		if sent != nil {
+			sent[name] = struct{}{}
 		}
 
 		if ctx.Err() != nil {
-			return ctx.Err()
+			return false, ctx.Err()
 		}
 	}
 
-	return ctx.Err()
+	return false, ctx.Err()
+}
+
+// ListSorted returns the names of all files of type t in lexical order. For
+// data files, the names from all subdirectories are sorted together. All names
+// are collected in memory before they are returned.
+func (r *SFTP) ListSorted(ctx context.Context, t restic.FileType) ([]string, error) {
+	var names []string
+	err := r.List(ctx, t, func(fi restic.FileInfo) error {
+		names = append(names, fi.Name)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(names)
+	return names, nil
 }
 
-var closeTimeout = 2 * time.Second
+// defaultCloseTimeout is used if Config.CloseTimeout is zero.
+const defaultCloseTimeout = 2 * time.Second
+
+// closeTimeout returns the time to wait for the ssh process to exit after
+// closing the connection.
+func closeTimeout(cfg Config) time.Duration {
+	if cfg.CloseTimeout > 0 {
+		return cfg.CloseTimeout
+	}
+	return defaultCloseTimeout
+}
 
 // Close closes the sftp connection and terminates the underlying command.
 func (r *SFTP) Close() error {
@@ -577,28 +1721,80 @@ func (r *SFTP) Close() error {
 		return nil
 	}
 
-	err := r.c.Close()
-	debug.Log("Close returned error %v", err)
+	r.releaseInitLock()
 
-	// wait for closeTimeout before killing the process
-	select {
-	case err := <-r.result:
+	// flush the result stream and close the event channel first, so that
+	// no records are lost and nothing is written to them afterwards
+	flushErr := r.closeResults(closeTimeout(r.Config))
+	r.closeEvents()
+
+	err := r.closeConnection()
+	if err != nil {
 		return err
-	case <-time.After(closeTimeout):
+	}
+	return flushErr
+}
+
+// closeConnection closes the sftp clients of the main connection and the
+// pooled connections and terminates the underlying commands.
+func (r *SFTP) closeConnection() error {
+	r.conn.m.Lock()
+	r.conn.closed = true
+	r.conn.m.Unlock()
+
+	r.pool.close(clockFor(r.Config), closeTimeout(r.Config))
+	return r.conn.close(clockFor(r.Config), closeTimeout(r.Config))
+}
+
+// close closes the sftp client and terminates the underlying command. If the
+// command does not exit within timeout, it is killed.
+func (conn *connection) close(clk clock, timeout time.Duration) error {
+	conn.m.RLock()
+	c, cmd, sshClient, local, result := conn.c, conn.cmd, conn.ssh, conn.local, conn.result
+	conn.m.RUnlock()
+
+	// closing the client waits until the server has closed its output, which
+	// a hung process may never do
+	go func() {
+		err := c.Close()
+		debug.Log("Close returned error %v", err)
+	}()
+
+	if sshClient != nil {
+		// closing the native connection makes Wait return immediately
+		_ = sshClient.Close()
+		<-result.Done()
+		return nil
+	}
+	if local != nil {
+		_ = local.Close()
+		<-result.Done()
+		return nil
+	}
+
+	// wait for timeout before killing the process
+	select {
+	case <-result.Done():
+		return result.Err()
+	case <-clk.After(timeout):
 	}
 
-	if err := r.cmd.Process.Kill(); err != nil {
+	debug.Log("ssh process did not exit within %v, killing it", timeout)
+	if err := cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
 		return err
 	}
 
-	// get the error, but ignore it
-	<-r.result
+	// wait for the exit, but ignore the error
+	<-result.Done()
 	return nil
 }
 
 func (r *SFTP) deleteRecursive(ctx context.Context, name string) error {
 	entries, err := r.ReadDir(ctx, name)
 	if err != nil {
+		if r.IsNotExist(err) {
+			return nil
+		}
 		return errors.Wrap(err, "ReadDir")
 	}
 
@@ -607,27 +1803,120 @@ func (r *SFTP) deleteRecursive(ctx context.Context, name string) error {
 		if fi.IsDir() {
 			err := r.deleteRecursive(ctx, itemName)
 			if err != nil {
-				return errors.Wrap(err, "ReadDir")
+				return err
 			}
 
-			err = r.c.RemoveDirectory(itemName)
-			if err != nil {
+			err = r.client().RemoveDirectory(itemName)
+			if err != nil && !r.IsNotExist(err) {
 				return errors.Wrap(err, "RemoveDirectory")
 			}
 
 			continue
 		}
 
-		err := r.c.Remove(itemName)
-		if err != nil {
-			return errors.Wrap(err, "ReadDir")
+		err := r.client().Remove(itemName)
+		if err != nil && !r.IsNotExist(err) {
+			return errors.Wrap(err, "Remove")
 		}
 	}
 
 	return nil
 }
 
-// Delete removes all data in the backend.
+// Delete removes all data in the backend. Files and directories which do not
+// exist, for example in a partially created repository, are ignored. It fails
+// with ErrMaintenanceInProgress while another process holds the maintenance
+// lock.
 func (r *SFTP) Delete(ctx context.Context) error {
+	if err := r.checkReadOnly("delete"); err != nil {
+		return err
+	}
+
+	if p := path.Clean(r.p); r.p == "" || p == "/" || p == "." {
+		return backoff.Permanent(errors.Errorf("refusing to delete the repository at %q", r.p))
+	}
+	if err := r.checkMaintenance(); err != nil {
+		return err
+	}
 	return r.deleteRecursive(ctx, r.p)
 }
+
+// CompactPrefixDirs removes all empty subdirectories of the data directory,
+// which accumulate after pruning. Directories which still contain files are
+// left untouched. The number of removed directories is returned. It fails
+// with ErrMaintenanceInProgress while another process holds the maintenance
+// lock.
+func (r *SFTP) CompactPrefixDirs(ctx context.Context) (int, error) {
+	if err := r.checkReadOnly("compact"); err != nil {
+		return 0, err
+	}
+
+	if err := r.checkMaintenance(); err != nil {
+		return 0, err
+	}
+
+	basedir, subdirs := r.Basedir(restic.PackFile)
+	if !subdirs {
+		return 0, nil
+	}
+
+	r.sem.GetToken()
+	entries, err := r.ReadDir(ctx, basedir)
+	r.sem.ReleaseToken()
+	if err != nil {
+		if r.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, errors.Wrap(err, "ReadDir")
+	}
+
+	removed := 0
+	for _, fi := range entries {
+		if ctx.Err() != nil {
+			return removed, ctx.Err()
+		}
+
+		if !fi.IsDir() {
+			continue
+		}
+
+		dir := r.Join(basedir, fi.Name())
+		empty, err := r.isEmptyDir(ctx, dir)
+		if err != nil {
+			return removed, err
+		}
+		if !empty {
+			continue
+		}
+
+		r.sem.GetToken()
+		err = r.client().RemoveDirectory(dir)
+		r.sem.ReleaseToken()
+		if err != nil {
+			if r.IsNotExist(err) {
+				continue
+			}
+			r.reportResult("rmdir", objectName(restic.Handle{Type: restic.PackFile, Name: fi.Name()}), 0, err)
+			return removed, errors.Wrap(err, "RemoveDirectory")
+		}
+		r.reportResult("rmdir", objectName(restic.Handle{Type: restic.PackFile, Name: fi.Name()}), 0, nil)
+
+		debug.Log("removed empty directory %v", dir)
+		removed++
+	}
+
+	return removed, nil
+}
+
+// isEmptyDir returns true if dir does not contain any entries.
+func (r *SFTP) isEmptyDir(ctx context.Context, dir string) (bool, error) {
+	r.sem.GetToken()
+	defer r.sem.ReleaseToken()
+
+	entries, err := r.ReadDir(ctx, dir)
+	if err != nil {
+		return false, errors.Wrap(err, "ReadDir")
+	}
+
+	return len(entries) == 0, nil
+}
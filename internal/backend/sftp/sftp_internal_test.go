@@ -0,0 +1,395 @@
+package sftp
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/backend/layout"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+
+	"golang.org/x/sync/errgroup"
+)
+
+func findTestServerBinary() string {
+	for _, dir := range strings.Split(rtest.TestSFTPPath, ":") {
+		testpath := filepath.Join(dir, "sftp-server")
+		_, err := os.Stat(testpath)
+		if !errors.Is(err, os.ErrNotExist) {
+			return testpath
+		}
+	}
+
+	return ""
+}
+
+var testServer = findTestServerBinary()
+
+// newTestConfig returns a config for a new repository in a temporary
+// directory, served by the local sftp-server binary.
+func newTestConfig(t testing.TB) Config {
+	if testServer == "" {
+		t.Skip("sftp server binary not found")
+	}
+
+	cfg := NewConfig()
+	cfg.Path = filepath.Join(rtest.TempDir(t), "repo")
+	cfg.Command = fmt.Sprintf("%q -e", testServer)
+	return cfg
+}
+
+// newTestBackend creates a new repository and returns the backend for it.
+// The backend is closed when the test finishes.
+func newTestBackend(t testing.TB, cfg Config) *SFTP {
+	be, err := Create(context.TODO(), cfg)
+	rtest.OK(t, err)
+	t.Cleanup(func() {
+		rtest.OK(t, be.Close())
+	})
+	return be
+}
+
+func saveFile(t testing.TB, be *SFTP, h restic.Handle, data []byte) {
+	err := be.Save(context.TODO(), h, restic.NewByteReader(data, be.Hasher()))
+	rtest.OK(t, err)
+}
+
+func TestCompactPrefixDirs(t *testing.T) {
+	be := newTestBackend(t, newTestConfig(t))
+
+	data := []byte("foobar")
+	id := restic.Hash(data)
+	h := restic.Handle{Type: restic.PackFile, Name: id.String()}
+	saveFile(t, be, h, data)
+
+	basedir, _ := be.Basedir(restic.PackFile)
+	entries, err := be.ReadDir(context.TODO(), basedir)
+	rtest.OK(t, err)
+	rtest.Equals(t, 256, len(entries))
+
+	removed, err := be.CompactPrefixDirs(context.TODO())
+	rtest.OK(t, err)
+	rtest.Equals(t, 255, removed)
+
+	entries, err = be.ReadDir(context.TODO(), basedir)
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, len(entries))
+	rtest.Equals(t, id.String()[:2], entries[0].Name())
+
+	// running again must not remove anything
+	removed, err = be.CompactPrefixDirs(context.TODO())
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, removed)
+
+	buf, err := backend.LoadAll(context.TODO(), nil, be, h)
+	rtest.OK(t, err)
+	rtest.Equals(t, data, buf)
+
+	// saving to a removed prefix directory recreates it
+	saveFile(t, be, restic.Handle{Type: restic.PackFile, Name: restic.NewRandomID().String()}, []byte("baz"))
+}
+
+func TestPathJoiner(t *testing.T) {
+	cfg := Config{
+		Path: "/srv//repo",
+		PathJoiner: func(parts ...string) string {
+			return strings.Join(parts, "/")
+		},
+	}
+	be := &SFTP{Config: cfg}
+
+	l, err := layout.ParseLayout(context.TODO(), be, "default", defaultLayout, cfg.Path)
+	rtest.OK(t, err)
+	be.Layout = l
+
+	h := restic.Handle{Type: restic.SnapshotFile, Name: "foobar"}
+	rtest.Equals(t, "/srv//repo/snapshots/", be.Dirname(h))
+	rtest.Equals(t, "/srv//repo/snapshots//foobar", be.Filename(h))
+	rtest.Equals(t, "/srv//repo/config", be.Filename(restic.Handle{Type: restic.ConfigFile}))
+	rtest.Equals(t, "a//b/c", be.Join("a/", "b/c"))
+
+	// without a custom joiner, paths are cleaned
+	be = &SFTP{Config: Config{Path: cfg.Path}}
+	be.Layout, err = layout.ParseLayout(context.TODO(), be, "default", defaultLayout, cfg.Path)
+	rtest.OK(t, err)
+	rtest.Equals(t, "/srv/repo/snapshots/foobar", be.Filename(h))
+}
+
+// failingReader returns err (or a generic error, if unset) after the first
+// read.
+type failingReader struct {
+	*restic.ByteReader
+	reads int
+	err   error
+}
+
+func (rd *failingReader) Read(p []byte) (int, error) {
+	rd.reads++
+	if rd.reads > 1 && rd.err != nil {
+		return 0, rd.err
+	}
+	if rd.reads > 1 {
+		return 0, errors.New("injected read error")
+	}
+	return rd.ByteReader.Read(p[:1])
+}
+
+// dirEntries returns the names of all entries in the directory dir.
+func dirEntries(t testing.TB, be *SFTP, dir string) []string {
+	entries, err := be.client().ReadDir(dir)
+	rtest.OK(t, err)
+
+	var names []string
+	for _, fi := range entries {
+		names = append(names, fi.Name())
+	}
+	return names
+}
+
+func TestSaveVerified(t *testing.T) {
+	be := newTestBackend(t, newTestConfig(t))
+
+	data := []byte("snapshot data")
+	id := restic.Hash(data)
+	h := restic.Handle{Type: restic.SnapshotFile, Name: id.String()}
+
+	// write error
+	rd := &failingReader{ByteReader: restic.NewByteReader(data, nil)}
+	err := be.SaveVerified(context.TODO(), h, rd, id)
+	rtest.Assert(t, err != nil, "expected an error for a failing reader")
+	rtest.Assert(t, strings.Contains(err.Error(), "injected read error"), "unexpected error %v", err)
+	rtest.Equals(t, []string(nil), dirEntries(t, be, be.Dirname(h)))
+
+	// hash mismatch
+	err = be.SaveVerified(context.TODO(), h, restic.NewByteReader(data, nil), restic.NewRandomID())
+	rtest.Assert(t, err != nil, "expected an error for a hash mismatch")
+	rtest.Assert(t, strings.Contains(err.Error(), "hash mismatch"), "unexpected error %v", err)
+	rtest.Equals(t, []string(nil), dirEntries(t, be, be.Dirname(h)))
+
+	// success
+	err = be.SaveVerified(context.TODO(), h, restic.NewByteReader(data, nil), id)
+	rtest.OK(t, err)
+	rtest.Equals(t, []string{id.String()}, dirEntries(t, be, be.Dirname(h)))
+
+	buf, err := backend.LoadAll(context.TODO(), nil, be, h)
+	rtest.OK(t, err)
+	rtest.Equals(t, data, buf)
+}
+
+func TestSubprocessEnv(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test requires a POSIX shell")
+	}
+
+	cfg := newTestConfig(t)
+	dir := rtest.TempDir(t)
+	envfile := filepath.Join(dir, "env")
+	script := filepath.Join(dir, "server.sh")
+	rtest.OK(t, os.WriteFile(script, []byte(fmt.Sprintf("#!/bin/sh\n/usr/bin/env > %q\nexec %q\n", envfile, testServer)), 0700))
+
+	cfg.Command = script
+	cfg.Env = map[string]string{"TEST_SFTP_VAR": "foo"}
+	newTestBackend(t, cfg)
+
+	buf, err := os.ReadFile(envfile)
+	rtest.OK(t, err)
+	env := strings.Fields(string(buf))
+	// the shell may add a few variables of its own
+	rtest.Assert(t, len(env) <= 4, "unexpected variables in environment: %v", env)
+	rtest.Assert(t, strings.Contains(string(buf), "TEST_SFTP_VAR=foo\n"), "variable not found in environment: %v", env)
+}
+
+func TestCanonicalizePath(t *testing.T) {
+	// relative paths are resolved in the start directory of the server
+	cfg := fakeServerConfig(faultStartDir)
+	cfg.Path = "repo/sub/.."
+	cfg.CanonicalizePath = true
+
+	be := newTestBackend(t, cfg)
+	rtest.Equals(t, fakeStartDir+"/repo", be.Location())
+	rtest.Equals(t, fakeStartDir+"/repo/config", be.Filename(restic.Handle{Type: restic.ConfigFile}))
+}
+
+func TestMaxConcurrentLists(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.MaxConcurrentLists = 2
+	be := newTestBackend(t, cfg)
+
+	data := []byte("foobar")
+	saveFile(t, be, restic.Handle{Type: restic.SnapshotFile, Name: restic.Hash(data).String()}, data)
+
+	var (
+		m      sync.Mutex
+		active int
+		max    int
+	)
+
+	var wg errgroup.Group
+	for i := 0; i < 10; i++ {
+		wg.Go(func() error {
+			return be.List(context.TODO(), restic.SnapshotFile, func(restic.FileInfo) error {
+				m.Lock()
+				active++
+				if active > max {
+					max = active
+				}
+				m.Unlock()
+
+				time.Sleep(20 * time.Millisecond)
+
+				m.Lock()
+				active--
+				m.Unlock()
+				return nil
+			})
+		})
+	}
+	rtest.OK(t, wg.Wait())
+	rtest.Assert(t, max <= 2, "%d concurrent lists, expected at most 2", max)
+
+	// a queued List can be cancelled
+	be.listSem <- struct{}{}
+	be.listSem <- struct{}{}
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	err := be.List(ctx, restic.SnapshotFile, func(restic.FileInfo) error {
+		return nil
+	})
+	rtest.Assert(t, errors.Is(err, context.Canceled), "unexpected error %v", err)
+	<-be.listSem
+	<-be.listSem
+}
+
+func TestOpenRetries(t *testing.T) {
+	// the repository directory is only found by the fourth lookup
+	cfg := fakeServerConfig(faultHiddenRepo)
+
+	// without retries, a missing directory is not detected
+	be, err := Open(context.TODO(), cfg)
+	rtest.OK(t, err)
+	rtest.OK(t, be.Close())
+
+	cfg.OpenRetries = 2
+	_, err = Open(context.TODO(), cfg)
+	rtest.Assert(t, err != nil, "expected an error for a missing repository directory")
+
+	cfg.OpenRetries = 10
+	be, err = Open(context.TODO(), cfg)
+	rtest.OK(t, err)
+	rtest.OK(t, be.Close())
+}
+
+func TestListMaxDepth(t *testing.T) {
+	cfg := newTestConfig(t)
+	be := newTestBackend(t, cfg)
+
+	basedir, _ := be.Basedir(restic.PackFile)
+	deep := filepath.Join(basedir, "aa", "b", "c", "d", "e", "f", "g", "h", "i")
+	rtest.OK(t, os.MkdirAll(deep, 0700))
+	rtest.OK(t, os.WriteFile(filepath.Join(deep, "file"), []byte("foo"), 0600))
+
+	list := func(be *SFTP) error {
+		return be.List(context.TODO(), restic.PackFile, func(restic.FileInfo) error {
+			return nil
+		})
+	}
+
+	err := list(be)
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "maximum listing depth"), "unexpected error %v", err)
+
+	cfg.MaxListDepth = 10
+	be2, err := Open(context.TODO(), cfg)
+	rtest.OK(t, err)
+	defer func() {
+		rtest.OK(t, be2.Close())
+	}()
+	rtest.OK(t, list(be2))
+}
+
+func TestCheckWritable(t *testing.T) {
+	cfg := newTestConfig(t)
+	be := newTestBackend(t, cfg)
+
+	rtest.OK(t, be.CheckWritable(context.TODO()))
+	rtest.Equals(t, 5, len(dirEntries(t, be, be.Location())))
+
+	// start the server in read-only mode
+	cfg.Command = fmt.Sprintf("%q -e -R", testServer)
+	ro, err := Open(context.TODO(), cfg)
+	rtest.OK(t, err)
+	defer func() {
+		rtest.OK(t, ro.Close())
+	}()
+
+	err = ro.CheckWritable(context.TODO())
+	rtest.Assert(t, err != nil, "expected an error for a read-only server")
+	rtest.Equals(t, 5, len(dirEntries(t, be, be.Location())))
+}
+
+func TestListSorted(t *testing.T) {
+	be := newTestBackend(t, newTestConfig(t))
+
+	var want []string
+	for i := 0; i < 30; i++ {
+		data := []byte(fmt.Sprintf("pack %d", i))
+		id := restic.Hash(data)
+		saveFile(t, be, restic.Handle{Type: restic.PackFile, Name: id.String()}, data)
+		want = append(want, id.String())
+	}
+	sort.Strings(want)
+
+	names, err := be.ListSorted(context.TODO(), restic.PackFile)
+	rtest.OK(t, err)
+	rtest.Equals(t, want, names)
+
+	names, err = be.ListSorted(context.TODO(), restic.SnapshotFile)
+	rtest.OK(t, err)
+	rtest.Equals(t, []string(nil), names)
+}
+
+func TestConnectionsLimit(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.Connections = 2
+	be := newTestBackend(t, cfg)
+
+	var m sync.Mutex
+	active, maxActive := 0, 0
+
+	var wg errgroup.Group
+	for i := 0; i < 10; i++ {
+		data := []byte(fmt.Sprintf("file %d", i))
+		h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+		rd := &observingReader{ByteReader: restic.NewByteReader(data, nil), fn: func() {
+			m.Lock()
+			active++
+			if active > maxActive {
+				maxActive = active
+			}
+			m.Unlock()
+
+			// hold the connection for a while, so that the other
+			// operations have to wait
+			time.Sleep(20 * time.Millisecond)
+
+			m.Lock()
+			active--
+			m.Unlock()
+		}}
+		wg.Go(func() error {
+			return be.Save(context.TODO(), h, rd)
+		})
+	}
+	rtest.OK(t, wg.Wait())
+
+	rtest.Assert(t, maxActive >= 1 && maxActive <= 2, "%d operations ran concurrently, want at most 2", maxActive)
+}
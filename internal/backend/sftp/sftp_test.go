@@ -73,6 +73,24 @@ func newTestSuite(t testing.TB) *test.Suite {
 	}
 }
 
+// newLocalTestSuite returns a test suite which serves the repository from the
+// local file system, so it does not need an sftp server binary.
+func newLocalTestSuite(t testing.TB) *test.Suite {
+	suite := newTestSuite(t)
+	newConfig := suite.NewConfig
+	suite.NewConfig = func() (interface{}, error) {
+		cfg, err := newConfig()
+		if err != nil {
+			return nil, err
+		}
+		c := cfg.(sftp.Config)
+		c.Command = ""
+		c.LocalFallback = true
+		return c, nil
+	}
+	return suite
+}
+
 func TestBackendSFTP(t *testing.T) {
 	defer func() {
 		if t.Skipped() {
@@ -94,3 +112,7 @@ func BenchmarkBackendSFTP(t *testing.B) {
 
 	newTestSuite(t).RunBenchmarks(t)
 }
+
+func TestBackendSFTPLocal(t *testing.T) {
+	newLocalTestSuite(t).RunTests(t)
+}
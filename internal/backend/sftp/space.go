@@ -0,0 +1,111 @@
+package sftp
+
+import (
+	"context"
+	"strings"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/pkg/sftp"
+)
+
+var (
+	// ErrNoSpace is returned when a write failed because the file system on
+	// the server is full.
+	ErrNoSpace = errors.New("sftp: no space left on device")
+	// ErrQuotaExceeded is returned when a write failed because the disk quota
+	// of the user on the server is exhausted.
+	ErrQuotaExceeded = errors.New("sftp: disk quota exceeded")
+	// ErrStatVFSUnsupported is returned by FreeSpace if the server does not
+	// support the statvfs@openssh.com extension.
+	ErrStatVFSUnsupported = errors.New("sftp: server does not support statvfs@openssh.com")
+)
+
+// sftpFxQuotaExceeded is the status code for SSH_FX_QUOTA_EXCEEDED, which
+// pkg/sftp doesn't export.
+const sftpFxQuotaExceeded = 15
+
+// spaceChecker is the part of *sftp.Client used by checkNoSpace.
+type spaceChecker interface {
+	HasExtension(name string) (string, bool)
+	StatVFS(path string) (*sftp.StatVFS, error)
+}
+
+// checkNoSpace checks if err was likely caused by an exceeded quota or lack of
+// available space on the remote, and if so, returns ErrQuotaExceeded or
+// ErrNoSpace as a permanent error.
+func checkNoSpace(c spaceChecker, dir string, size int64, origErr error) error {
+	if isQuotaExceeded(origErr) {
+		debug.Log("sftp: quota exceeded: %v", origErr)
+		return backoff.Permanent(ErrQuotaExceeded)
+	}
+
+	// The SFTP protocol has a message for ENOSPC,
+	// but pkg/sftp doesn't export it and OpenSSH's sftp-server
+	// sends FX_FAILURE instead.
+
+	var e *sftp.StatusError
+	if !errors.As(origErr, &e) || e.FxCode() != sftp.ErrSSHFxFailure {
+		return origErr
+	}
+
+	if strings.Contains(strings.ToLower(origErr.Error()), "no space left") {
+		return backoff.Permanent(ErrNoSpace)
+	}
+
+	if _, hasExt := c.HasExtension("statvfs@openssh.com"); !hasExt {
+		return origErr
+	}
+
+	fsinfo, err := c.StatVFS(dir)
+	if err != nil {
+		debug.Log("sftp: StatVFS returned %v", err)
+		return origErr
+	}
+	if fsinfo.Favail == 0 || fsinfo.Frsize*fsinfo.Bavail < uint64(size) {
+		return backoff.Permanent(ErrNoSpace)
+	}
+	return origErr
+}
+
+// isQuotaExceeded returns true if err reports an exceeded disk quota, either
+// by the status code or by the message sent by the server.
+func isQuotaExceeded(err error) bool {
+	var e *sftp.StatusError
+	if errors.As(err, &e) && e.Code == sftpFxQuotaExceeded {
+		return true
+	}
+
+	return strings.Contains(strings.ToLower(err.Error()), "quota exceeded")
+}
+
+// FreeSpace returns the number of bytes available to the user and the total
+// size of the file system which stores the repository. It returns an error
+// wrapping ErrStatVFSUnsupported if the server cannot report them.
+func (r *SFTP) FreeSpace(ctx context.Context) (available, total uint64, err error) {
+	debug.Log("FreeSpace()%v", r.tagInfo())
+	if err := r.clientError(ctx); err != nil {
+		return 0, 0, err
+	}
+
+	r.sem.GetToken()
+	defer r.sem.ReleaseToken()
+
+	return freeSpace(r.client(), r.p)
+}
+
+// freeSpace returns the available and the total space of the file system
+// containing dir.
+func freeSpace(c spaceChecker, dir string) (available, total uint64, err error) {
+	if _, hasExt := c.HasExtension("statvfs@openssh.com"); !hasExt {
+		return 0, 0, backoff.Permanent(ErrStatVFSUnsupported)
+	}
+
+	fsinfo, err := c.StatVFS(dir)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "StatVFS")
+	}
+	return fsinfo.Frsize * fsinfo.Bavail, fsinfo.Frsize * fsinfo.Blocks, nil
+}
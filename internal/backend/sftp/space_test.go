@@ -0,0 +1,94 @@
+package sftp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+
+	"github.com/pkg/sftp"
+)
+
+type fakeSpaceChecker struct {
+	statvfs *sftp.StatVFS
+}
+
+func (c *fakeSpaceChecker) HasExtension(name string) (string, bool) {
+	return "2", c.statvfs != nil
+}
+
+func (c *fakeSpaceChecker) StatVFS(path string) (*sftp.StatVFS, error) {
+	return c.statvfs, nil
+}
+
+func TestCheckNoSpace(t *testing.T) {
+	failure := &sftp.StatusError{Code: uint32(sftp.ErrSSHFxFailure)}
+	full := &sftp.StatVFS{Frsize: 4096, Bavail: 0, Favail: 100}
+	free := &sftp.StatVFS{Frsize: 4096, Bavail: 1000, Favail: 100}
+
+	for i, test := range []struct {
+		err     error
+		statvfs *sftp.StatVFS
+		want    error
+	}{
+		{&sftp.StatusError{Code: sftpFxQuotaExceeded}, nil, ErrQuotaExceeded},
+		{fmt.Errorf("%w: Disk quota exceeded", failure), free, ErrQuotaExceeded},
+		{fmt.Errorf("%w: No space left on device", failure), nil, ErrNoSpace},
+		{failure, full, ErrNoSpace},
+		{failure, free, failure},
+		{failure, nil, failure},
+		{&sftp.StatusError{Code: uint32(sftp.ErrSSHFxPermissionDenied)}, full, nil},
+	} {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			err := checkNoSpace(&fakeSpaceChecker{statvfs: test.statvfs}, "/repo/data", 1024, test.err)
+			want := test.want
+			if want == nil {
+				want = test.err
+			}
+			rtest.Assert(t, errors.Is(err, want), "unexpected error %v, want %v", err, want)
+			if want == ErrQuotaExceeded {
+				rtest.Assert(t, !errors.Is(err, ErrNoSpace), "quota exceeded reported as no space")
+			}
+		})
+	}
+}
+
+func TestFreeSpace(t *testing.T) {
+	available, total, err := freeSpace(&fakeSpaceChecker{statvfs: &sftp.StatVFS{Frsize: 4096, Blocks: 1000, Bavail: 250, Bfree: 300}}, "/repo")
+	rtest.OK(t, err)
+	rtest.Equals(t, uint64(250*4096), available)
+	rtest.Equals(t, uint64(1000*4096), total)
+
+	_, _, err = freeSpace(&fakeSpaceChecker{}, "/repo")
+	rtest.Assert(t, errors.Is(err, ErrStatVFSUnsupported), "unexpected error %v", err)
+
+	be := newTestBackend(t, newTestConfig(t))
+	available, total, err = be.FreeSpace(context.TODO())
+	rtest.OK(t, err)
+	rtest.Assert(t, total > 0 && available <= total, "unexpected free space %v of %v", available, total)
+}
+
+func TestSaveNoSpaceCleanup(t *testing.T) {
+	be := newTestBackend(t, newTestConfig(t))
+
+	for _, test := range []struct {
+		err  error
+		want error
+	}{
+		{&sftp.StatusError{Code: sftpFxQuotaExceeded}, ErrQuotaExceeded},
+		{fmt.Errorf("%w: No space left on device", &sftp.StatusError{Code: uint32(sftp.ErrSSHFxFailure)}), ErrNoSpace},
+	} {
+		data := []byte("foobar")
+		h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+		rd := &failingReader{ByteReader: restic.NewByteReader(data, nil), err: test.err}
+
+		err := be.Save(context.TODO(), h, rd)
+		rtest.Assert(t, errors.Is(err, test.want), "unexpected error %v, want %v", err, test.want)
+
+		// the partial file has been removed
+		rtest.Equals(t, 0, len(dirEntries(t, be, be.Dirname(h))))
+	}
+}
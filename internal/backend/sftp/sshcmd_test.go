@@ -2,7 +2,10 @@ package sftp
 
 import (
 	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/restic/restic/internal/errors"
 )
 
 var sshcmdTests = []struct {
@@ -42,6 +45,69 @@ var sshcmdTests = []struct {
 		"ssh",
 		[]string{"::1%lo0", "-p", "22", "-l", "user", "-s", "sftp"},
 	},
+	{
+		// IPv4 address.
+		Config{Host: "192.0.2.1", Path: "dir"},
+		"ssh",
+		[]string{"192.0.2.1", "-s", "sftp"},
+	},
+	{
+		// IPv4 address with port in the host.
+		Config{Host: "192.0.2.1:2222", Path: "dir"},
+		"ssh",
+		[]string{"192.0.2.1", "-p", "2222", "-s", "sftp"},
+	},
+	{
+		// bracketed IPv6 address.
+		Config{Host: "[2001:db8::1]", Path: "dir"},
+		"ssh",
+		[]string{"2001:db8::1", "-s", "sftp"},
+	},
+	{
+		// bracketed IPv6 address with port in the host.
+		Config{Host: "[2001:db8::1]:22", Path: "dir"},
+		"ssh",
+		[]string{"2001:db8::1", "-p", "22", "-s", "sftp"},
+	},
+	{
+		// bare IPv6 address.
+		Config{Host: "2001:db8::1", Path: "dir"},
+		"ssh",
+		[]string{"2001:db8::1", "-s", "sftp"},
+	},
+	{
+		// the port option takes precedence over the port in the host.
+		Config{Host: "host:2222", Port: "10022", Path: "dir"},
+		"ssh",
+		[]string{"host", "-p", "10022", "-s", "sftp"},
+	},
+	{
+		Config{User: "user", Host: "host", Port: "10022", Path: "dir", ProxyJump: "admin@bastion:2222"},
+		"ssh",
+		[]string{"host", "-p", "10022", "-l", "user", "-J", "admin@bastion:2222", "-s", "sftp"},
+	},
+	{
+		// multiple jump hosts
+		Config{Host: "host", Path: "dir", ProxyJump: "bastion, admin@inner:22"},
+		"ssh",
+		[]string{"host", "-J", "bastion,admin@inner:22", "-s", "sftp"},
+	},
+	{
+		Config{User: "user", Host: "host", Path: "dir", ExtraArgs: []string{"-o", "ServerAliveInterval=30", "-i", "/home/user/key", "-vC", "-oBatchMode=yes"}},
+		"ssh",
+		[]string{"host", "-l", "user", "-o", "ServerAliveInterval=30", "-i", "/home/user/key", "-vC", "-oBatchMode=yes", "-s", "sftp"},
+	},
+	{
+		// the value of the last option in a group is the next argument
+		Config{Host: "host", Path: "dir", ExtraArgs: []string{"-4i", "key"}},
+		"ssh",
+		[]string{"host", "-4i", "key", "-s", "sftp"},
+	},
+	{
+		Config{User: "user", Host: "host", Path: "dir", SSHBinary: "/opt/bin/ssh"},
+		"/opt/bin/ssh",
+		[]string{"host", "-l", "user", "-s", "sftp"},
+	},
 }
 
 func TestBuildSSHCommand(t *testing.T) {
@@ -63,3 +129,89 @@ func TestBuildSSHCommand(t *testing.T) {
 		})
 	}
 }
+
+func TestBuildSSHCommandInvalidProxyJump(t *testing.T) {
+	for _, cfg := range []Config{
+		{Host: "host", Path: "dir", ProxyJump: "bastion,,inner"},
+		{Host: "host", Path: "dir", ProxyJump: "-oProxyCommand=evil"},
+		{Host: "host", Path: "dir", ProxyJump: "bastion inner"},
+		{Host: "host", Path: "dir", ProxyJump: "bastion", Command: "ssh bastion"},
+	} {
+		_, _, err := buildSSHCommand(cfg)
+		if err == nil {
+			t.Errorf("invalid jump host %q accepted", cfg.ProxyJump)
+		}
+	}
+}
+
+func TestBuildSSHCommandInvalidExtraArgs(t *testing.T) {
+	for _, args := range [][]string{
+		{"otherhost"},
+		{"-o", "ServerAliveInterval=30", "otherhost"},
+		{"-v", "uptime"},
+		{"-i"},
+		{"--", "-v"},
+		{"-"},
+	} {
+		_, _, err := buildSSHCommand(Config{Host: "host", Path: "dir", ExtraArgs: args})
+		if err == nil {
+			t.Errorf("invalid extra arguments %q accepted", args)
+		}
+	}
+
+	_, _, err := buildSSHCommand(Config{Host: "host", Path: "dir", Command: "ssh host", ExtraArgs: []string{"-v"}})
+	if err == nil {
+		t.Errorf("extra arguments accepted with a custom command")
+	}
+}
+
+func TestBuildSSHEnv(t *testing.T) {
+	t.Setenv("RESTIC_TEST_INHERITED", "inherited")
+	t.Setenv("RESTIC_TEST_OVERRIDDEN", "old")
+
+	env := buildSSHEnv(Config{})
+	if env != nil {
+		t.Fatalf("expected nil environment without Env, got %v", env)
+	}
+
+	env = buildSSHEnv(Config{Env: map[string]string{}})
+	if env == nil || len(env) != 0 {
+		t.Fatalf("expected empty environment, got %v", env)
+	}
+
+	vars := map[string]string{"RESTIC_TEST_OVERRIDDEN": "new", "DISPLAY": ""}
+	env = buildSSHEnv(Config{Env: vars})
+	want := []string{"DISPLAY=", "RESTIC_TEST_OVERRIDDEN=new"}
+	if !reflect.DeepEqual(env, want) {
+		t.Fatalf("wrong environment, want:\n  %v\ngot:\n  %v", want, env)
+	}
+
+	env = buildSSHEnv(Config{Env: vars, InheritEnv: true})
+	found := make(map[string]int)
+	for _, kv := range env {
+		found[kv]++
+	}
+	for _, kv := range []string{"RESTIC_TEST_INHERITED=inherited", "RESTIC_TEST_OVERRIDDEN=new", "DISPLAY="} {
+		if found[kv] != 1 {
+			t.Errorf("%v found %d times in environment", kv, found[kv])
+		}
+	}
+	if found["RESTIC_TEST_OVERRIDDEN=old"] != 0 {
+		t.Errorf("overridden variable still present in environment")
+	}
+}
+
+func TestSSHBinaryNotFound(t *testing.T) {
+	for _, cfg := range []Config{
+		{Host: "host", Path: "dir", SSHBinary: "/nonexistent/ssh"},
+		{Host: "host", Path: "dir", SSHBinary: "nonexistent-ssh-binary"},
+	} {
+		_, err := startClient(cfg)
+		if !errors.Is(err, ErrSSHNotFound) {
+			t.Fatalf("expected ErrSSHNotFound for %v, got %v", cfg.SSHBinary, err)
+		}
+		if !strings.Contains(err.Error(), cfg.SSHBinary) {
+			t.Errorf("error %q does not mention the binary", err)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package sftp
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+)
+
+// sshConfigHost holds the settings from an OpenSSH config file which are
+// used for connections with an identity file. ProxyJump and ProxyCommand are
+// only read to reject them, as they are not supported by native connections.
+type sshConfigHost struct {
+	HostName     string
+	Port         string
+	User         string
+	ProxyJump    string
+	ProxyCommand string
+}
+
+// resolveSSHConfig looks up cfg.Host in the OpenSSH config file
+// cfg.SSHConfigFile, which defaults to ~/.ssh/config, and fills in the host
+// name, and the port and user unless they are already set in cfg. A missing
+// default config file is ignored. An error is returned if the host is to be
+// reached via a jump host or proxy command, which would otherwise be
+// silently bypassed.
+func resolveSSHConfig(cfg Config) (Config, error) {
+	filename := cfg.SSHConfigFile
+	if filename == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return cfg, errors.Wrap(err, "UserHomeDir")
+		}
+		filename = filepath.Join(home, ".ssh", "config")
+	}
+
+	f, err := os.Open(filename)
+	if errors.Is(err, os.ErrNotExist) && cfg.SSHConfigFile == "" {
+		debug.Log("ssh config file %v does not exist", filename)
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, errors.Wrap(err, "Open")
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	host, err := parseSSHConfig(f, cfg.Host)
+	if err != nil {
+		return cfg, errors.Wrapf(err, "parse %v", filename)
+	}
+	debug.Log("ssh config for %v: %+v", cfg.Host, host)
+
+	if host.ProxyJump != "" && host.ProxyJump != "none" {
+		return cfg, errors.Errorf("ssh config for %v sets ProxyJump, which is not supported with an identity file", cfg.Host)
+	}
+	if host.ProxyCommand != "" && host.ProxyCommand != "none" {
+		return cfg, errors.Errorf("ssh config for %v sets ProxyCommand, which is not supported with an identity file", cfg.Host)
+	}
+
+	if host.HostName != "" {
+		cfg.Host = host.HostName
+	}
+	if cfg.Port == "" {
+		cfg.Port = host.Port
+	}
+	if cfg.User == "" {
+		cfg.User = host.User
+	}
+	return cfg, nil
+}
+
+// parseSSHConfig returns the settings for alias from the OpenSSH config file
+// read from rd. As in ssh, the first value found for each setting is used.
+// Match blocks and Include directives are not supported and skipped.
+func parseSSHConfig(rd io.Reader, alias string) (sshConfigHost, error) {
+	var host sshConfigHost
+	matches := true
+
+	sc := bufio.NewScanner(rd)
+	for sc.Scan() {
+		keyword, args := splitSSHConfigLine(sc.Text())
+		if keyword == "" {
+			continue
+		}
+
+		switch keyword {
+		case "host":
+			matches = matchSSHConfigHost(args, alias)
+			continue
+		case "match":
+			debug.Log("ssh config Match blocks are not supported, skipping")
+			matches = false
+			continue
+		case "include":
+			debug.Log("ssh config Include is not supported, skipping")
+			continue
+		}
+
+		if !matches || len(args) == 0 {
+			continue
+		}
+
+		var field *string
+		switch keyword {
+		case "hostname":
+			field = &host.HostName
+		case "port":
+			field = &host.Port
+		case "user":
+			field = &host.User
+		case "proxyjump":
+			field = &host.ProxyJump
+		case "proxycommand":
+			field = &host.ProxyCommand
+		default:
+			continue
+		}
+		if *field == "" {
+			*field = args[0]
+		}
+	}
+
+	return host, sc.Err()
+}
+
+// splitSSHConfigLine returns the lowercase keyword and the arguments of a
+// line in an OpenSSH config file. The keyword is empty for blank lines and
+// comments.
+func splitSSHConfigLine(line string) (string, []string) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", nil
+	}
+
+	// the keyword is separated from the arguments by whitespace and/or a
+	// single equals sign
+	i := strings.IndexAny(line, " \t=")
+	if i < 0 {
+		return strings.ToLower(line), nil
+	}
+	keyword, rest := line[:i], strings.TrimLeft(line[i:], " \t")
+	rest = strings.TrimPrefix(rest, "=")
+
+	return strings.ToLower(keyword), splitSSHConfigArgs(rest)
+}
+
+// splitSSHConfigArgs splits s at whitespace, except within double quotes.
+func splitSSHConfigArgs(s string) []string {
+	var (
+		args   []string
+		arg    strings.Builder
+		quoted bool
+		inArg  bool
+	)
+	for _, c := range s {
+		switch {
+		case c == '"':
+			quoted = !quoted
+			inArg = true
+		case !quoted && (c == ' ' || c == '\t'):
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(c)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args
+}
+
+// matchSSHConfigHost reports whether alias matches the patterns of a Host
+// line. Patterns may contain the wildcards * and ?, and alias must not match
+// any pattern negated with !.
+func matchSSHConfigHost(patterns []string, alias string) bool {
+	matched := false
+	for _, pattern := range patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		ok, _ := path.Match(strings.TrimPrefix(pattern, "!"), alias)
+		if !ok {
+			continue
+		}
+		if negated {
+			return false
+		}
+		matched = true
+	}
+	return matched
+}
@@ -0,0 +1,101 @@
+package sftp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+const testSSHConfig = `# restic test config
+Host backup
+	HostName backup.example.com
+	Port 2222
+	IdentityFile ~/.ssh/id_backup
+
+Host *.internal !db.internal
+	User admin
+	Port=2200
+
+Host = db.internal
+	HostName db.example.com
+
+Host jump
+	ProxyJump bastion.example.com
+
+Match host *
+	User ignored
+
+Host *
+	User "default user"
+	Port 22
+	IdentityFile /etc/ssh/id_default
+`
+
+func TestParseSSHConfig(t *testing.T) {
+	for _, test := range []struct {
+		alias string
+		want  sshConfigHost
+	}{
+		{"backup", sshConfigHost{HostName: "backup.example.com", Port: "2222", User: "default user"}},
+		{"web.internal", sshConfigHost{Port: "2200", User: "admin"}},
+		{"db.internal", sshConfigHost{HostName: "db.example.com", Port: "22", User: "default user"}},
+		{"jump", sshConfigHost{Port: "22", User: "default user", ProxyJump: "bastion.example.com"}},
+		{"other", sshConfigHost{Port: "22", User: "default user"}},
+	} {
+		t.Run(test.alias, func(t *testing.T) {
+			host, err := parseSSHConfig(strings.NewReader(testSSHConfig), test.alias)
+			rtest.OK(t, err)
+			rtest.Equals(t, test.want, host)
+		})
+	}
+}
+
+func TestResolveSSHConfig(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "config")
+	rtest.OK(t, os.WriteFile(filename, []byte(testSSHConfig), 0600))
+
+	// identity files from the config file are ignored
+	cfg, err := resolveSSHConfig(Config{Host: "backup", User: "restic", IdentityFile: "/id", SSHConfigFile: filename})
+	rtest.OK(t, err)
+	rtest.Equals(t, Config{
+		Host:          "backup.example.com",
+		Port:          "2222",
+		User:          "restic",
+		IdentityFile:  "/id",
+		SSHConfigFile: filename,
+	}, cfg)
+
+	// jump hosts are not silently bypassed
+	_, err = resolveSSHConfig(Config{Host: "jump", IdentityFile: "/id", SSHConfigFile: filename})
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "ProxyJump"), "expected ProxyJump error, got %v", err)
+
+	_, err = resolveSSHConfig(Config{Host: "backup", SSHConfigFile: filepath.Join(t.TempDir(), "missing")})
+	rtest.Assert(t, errors.Is(err, os.ErrNotExist), "expected a not exist error, got %v", err)
+}
+
+func TestNativeSSHConfig(t *testing.T) {
+	srv := newTestSSHServer(t)
+
+	identity := writeIdentity(t, t.TempDir(), "")
+	filename := filepath.Join(t.TempDir(), "config")
+	config := fmt.Sprintf("Host backup\n  HostName %v\n  Port %v\n", srv.Host, srv.Port)
+	rtest.OK(t, os.WriteFile(filename, []byte(config), 0600))
+
+	cfg := nativeTestConfig(t, srv)
+	cfg.Host = "backup"
+	cfg.Port = ""
+	cfg.IdentityFile = identity
+	cfg.UseSSHConfig = true
+	cfg.SSHConfigFile = filename
+
+	be, err := Create(context.TODO(), cfg)
+	rtest.OK(t, err)
+	rtest.Equals(t, "backup", be.Config.Host)
+	rtest.OK(t, be.Close())
+}
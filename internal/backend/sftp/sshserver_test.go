@@ -0,0 +1,184 @@
+package sftp
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os/exec"
+	"sync"
+	"testing"
+	"time"
+
+	rtest "github.com/restic/restic/internal/test"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// testSSHServer is an SSH server which serves the sftp subsystem for the
+// local file system, runs commands with sh and accepts all clients without
+// authentication.
+type testSSHServer struct {
+	Host, Port  string
+	HostKey     ssh.Signer
+	Fingerprint string
+
+	// idleTimeout drops connections on which the client has not sent
+	// anything for this long, if it is positive.
+	idleTimeout time.Duration
+
+	wg sync.WaitGroup
+}
+
+// newTestSSHServer starts an SSH server on localhost, which is stopped at the
+// end of the test.
+func newTestSSHServer(t testing.TB) *testSSHServer {
+	return newIdleTestSSHServer(t, 0)
+}
+
+// newIdleTestSSHServer starts an SSH server like newTestSSHServer, which drops
+// connections that have been silent for idleTimeout.
+func newIdleTestSSHServer(t testing.TB, idleTimeout time.Duration) *testSSHServer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	rtest.OK(t, err)
+	signer, err := ssh.NewSignerFromKey(priv)
+	rtest.OK(t, err)
+
+	cfg := &ssh.ServerConfig{NoClientAuth: true}
+	cfg.AddHostKey(signer)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	rtest.OK(t, err)
+
+	host, port, err := net.SplitHostPort(l.Addr().String())
+	rtest.OK(t, err)
+
+	srv := &testSSHServer{
+		Host:        host,
+		Port:        port,
+		HostKey:     signer,
+		Fingerprint: ssh.FingerprintSHA256(signer.PublicKey()),
+		idleTimeout: idleTimeout,
+	}
+
+	srv.wg.Add(1)
+	go func() {
+		defer srv.wg.Done()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			srv.wg.Add(1)
+			go func() {
+				defer srv.wg.Done()
+				srv.serve(conn, cfg)
+			}()
+		}
+	}()
+
+	t.Cleanup(func() {
+		_ = l.Close()
+	})
+
+	return srv
+}
+
+// idleConn fails reads if no data has been received for timeout.
+type idleConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *idleConn) Read(p []byte) (int, error) {
+	_ = c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Read(p)
+}
+
+func (srv *testSSHServer) serve(conn net.Conn, cfg *ssh.ServerConfig) {
+	if srv.idleTimeout > 0 {
+		conn = &idleConn{Conn: conn, timeout: srv.idleTimeout}
+	}
+
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	defer func() {
+		_ = sconn.Close()
+	}()
+	go ssh.DiscardRequests(reqs)
+
+	for newCh := range chans {
+		if newCh.ChannelType() != "session" {
+			_ = newCh.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+
+		ch, requests, err := newCh.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			for req := range requests {
+				if req.Type == "exec" {
+					var payload struct{ Command string }
+					ok := ssh.Unmarshal(req.Payload, &payload) == nil
+					_ = req.Reply(ok, nil)
+					if ok {
+						runCommand(ch, payload.Command)
+					}
+					continue
+				}
+
+				ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+				_ = req.Reply(ok, nil)
+				if !ok {
+					continue
+				}
+
+				server, err := sftp.NewServer(ch)
+				if err != nil {
+					_ = ch.Close()
+					return
+				}
+				_ = server.Serve()
+				_, _ = ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+				_ = ch.Close()
+			}
+		}()
+	}
+}
+
+// runCommand runs command with sh, sends its combined output and exit status
+// on ch and closes it.
+func runCommand(ch ssh.Channel, command string) {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdout = ch
+	cmd.Stderr = ch
+	status := 0
+	if err := cmd.Run(); err != nil {
+		status = 255
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			status = exitErr.ExitCode()
+		}
+	}
+	_, _ = ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(status)}))
+	_ = ch.Close()
+}
+
+// sshConfig returns a config for connecting to the server with the ssh
+// program. The test is skipped if ssh is not installed.
+func (srv *testSSHServer) sshConfig(t testing.TB) Config {
+	if _, err := exec.LookPath("ssh"); err != nil {
+		t.Skip("ssh not found")
+	}
+
+	cfg := NewConfig()
+	cfg.Host = srv.Host
+	cfg.Port = srv.Port
+	cfg.Path = t.TempDir()
+	return cfg
+}
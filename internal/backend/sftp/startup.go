@@ -0,0 +1,136 @@
+package sftp
+
+import (
+	"context"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// Policies for temporary files found when opening a repository, see
+// Config.StartupTempPolicy.
+const (
+	// StartupTempLeave leaves temporary files alone.
+	StartupTempLeave = "leave"
+	// StartupTempCleanup immediately removes the temporary files recorded in
+	// the temp file journals of other processes, see CleanupTemp.
+	StartupTempCleanup = "cleanup"
+	// StartupTempCleanupOlderThan removes temporary files which have not
+	// been modified for Config.StartupTempAge. Younger files may still be
+	// written by other processes using the repository.
+	StartupTempCleanupOlderThan = "cleanup-older-than"
+)
+
+// defaultStartupTempAge is used if Config.StartupTempAge is zero.
+const defaultStartupTempAge = 24 * time.Hour
+
+// minStartupTempAge is the minimum for Config.StartupTempAge, files which
+// have been modified more recently may belong to a save still in progress.
+const minStartupTempAge = time.Hour
+
+func validStartupTempPolicy(cfg Config) error {
+	switch cfg.StartupTempPolicy {
+	case "", StartupTempLeave, StartupTempCleanup:
+		return nil
+	case StartupTempCleanupOlderThan:
+		if cfg.StartupTempAge != 0 && cfg.StartupTempAge < minStartupTempAge {
+			return errors.Errorf("startup temp policy %v requires an age of at least %v", cfg.StartupTempPolicy, minStartupTempAge)
+		}
+		return nil
+	}
+	return errors.Errorf("invalid startup temp policy %q, may be one of: %v, %v, %v",
+		cfg.StartupTempPolicy, StartupTempLeave, StartupTempCleanup, StartupTempCleanupOlderThan)
+}
+
+// applyStartupTempPolicy removes stale temporary files according to
+// Config.StartupTempPolicy.
+func (r *SFTP) applyStartupTempPolicy(ctx context.Context) error {
+	if r.Config.ReadOnly {
+		debug.Log("repository is read-only, not applying the startup temp policy")
+		return nil
+	}
+
+	var n int
+	var err error
+	switch r.Config.StartupTempPolicy {
+	case StartupTempCleanup:
+		n, err = r.CleanupTemp(ctx)
+	case StartupTempCleanupOlderThan:
+		olderThan := r.Config.StartupTempAge
+		if olderThan == 0 {
+			olderThan = defaultStartupTempAge
+		}
+		n, err = r.removeTempFiles(ctx, olderThan)
+	default:
+		return nil
+	}
+	debug.Log("removed %d stale temp files", n)
+	return err
+}
+
+// removeTempFiles removes the temporary files in the directories of all file
+// types, of checksums and metadata and in the trash directory which have not
+// been modified for olderThan, according to the server's clock. The number of
+// removed files is returned.
+func (r *SFTP) removeTempFiles(ctx context.Context, olderThan time.Duration) (int, error) {
+	r.sem.GetToken()
+	defer r.sem.ReleaseToken()
+
+	now, err := r.serverTime()
+	if err != nil {
+		return 0, err
+	}
+
+	var dirs []string
+	for _, t := range []restic.FileType{restic.PackFile, restic.KeyFile, restic.LockFile, restic.SnapshotFile, restic.IndexFile} {
+		basedir, _ := r.Basedir(t)
+		dirs = append(dirs, basedir)
+	}
+	// checksums and metadata are written to temporary files as well
+	dirs = append(dirs, r.Join(r.p, checksumDir), r.Join(r.p, metaDir))
+	if r.Config.TrashDir != "" {
+		// files moved to the trash across file systems are copied first
+		dirs = append(dirs, r.trashDir())
+	}
+
+	removed := 0
+	for _, dir := range dirs {
+		walker := r.client().Walk(dir)
+		for walker.Step() {
+			if ctx.Err() != nil {
+				return removed, ctx.Err()
+			}
+
+			if err := walker.Err(); err != nil {
+				if r.IsNotExist(err) {
+					continue
+				}
+				return removed, errors.Wrap(err, "Walk")
+			}
+
+			fi := walker.Stat()
+			if !fi.Mode().IsRegular() || !strings.Contains(path.Base(walker.Path()), "-restic-temp-") {
+				continue
+			}
+
+			if now.Sub(fi.ModTime()) < olderThan {
+				continue
+			}
+
+			err := r.client().Remove(walker.Path())
+			if err != nil && !r.IsNotExist(err) {
+				return removed, errors.Wrap(err, "Remove")
+			}
+			if err == nil {
+				debug.Log("removed stale temp file %v", walker.Path())
+				removed++
+			}
+		}
+	}
+
+	return removed, nil
+}
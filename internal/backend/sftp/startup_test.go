@@ -0,0 +1,85 @@
+package sftp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestStartupTempPolicy(t *testing.T) {
+	for _, test := range []struct {
+		policy        string
+		age           time.Duration
+		oldGone       bool
+		newGone       bool
+		journaledGone bool
+	}{
+		{"", 0, false, false, false},
+		{StartupTempLeave, 0, false, false, false},
+		{StartupTempCleanup, 0, false, false, true},
+		{StartupTempCleanupOlderThan, 0, false, false, false},
+		{StartupTempCleanupOlderThan, time.Hour, true, false, false},
+	} {
+		t.Run(test.policy+"-"+test.age.String(), func(t *testing.T) {
+			cfg := newTestConfig(t)
+			cfg.TrashDir = "trash"
+			be := newTestBackend(t, cfg)
+
+			data := []byte("foobar")
+			h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+			saveFile(t, be, h, data)
+
+			oldTemp := be.Filename(h) + "-restic-temp-0123"
+			newTemp := be.Join(be.Location(), "index", "abcd-restic-temp-4567")
+			trashTemp := be.Join(be.trashDir(), "20060102-150405-data-"+h.Name+"-restic-temp-89ab")
+			rtest.OK(t, be.client().MkdirAll(be.trashDir()))
+			writeServerFile(t, be, oldTemp, data)
+			writeServerFile(t, be, newTemp, data)
+			writeServerFile(t, be, trashTemp, data)
+			// a temp file of a crashed process, recorded in its journal
+			journaled := be.Join(be.Location(), "keys", "0123-restic-temp-cdef")
+			writeServerFile(t, be, journaled, data)
+			rtest.OK(t, be.client().MkdirAll(be.Join(be.Location(), journalDir)))
+			writeServerFile(t, be, be.Join(be.Location(), journalDir, "crashed"), []byte(journaled+"\n"))
+
+			old := time.Now().Add(-2 * time.Hour)
+			rtest.OK(t, be.client().Chtimes(oldTemp, old, old))
+			rtest.OK(t, be.client().Chtimes(trashTemp, old, old))
+
+			cfg.StartupTempPolicy = test.policy
+			cfg.StartupTempAge = test.age
+			be2, err := Open(context.TODO(), cfg)
+			rtest.OK(t, err)
+			defer func() {
+				rtest.OK(t, be2.Close())
+			}()
+
+			for _, f := range []struct {
+				name string
+				gone bool
+			}{
+				{oldTemp, test.oldGone},
+				{newTemp, test.newGone},
+				{trashTemp, test.oldGone},
+				{journaled, test.journaledGone},
+				{be.Filename(h), false},
+			} {
+				_, err := be.client().Lstat(f.name)
+				rtest.Equals(t, f.gone, be.IsNotExist(err))
+			}
+		})
+	}
+}
+
+func TestStartupTempPolicyInvalid(t *testing.T) {
+	for _, cfg := range []Config{
+		{StartupTempPolicy: "always"},
+		{StartupTempPolicy: "cleanup-all"},
+		{StartupTempPolicy: StartupTempCleanupOlderThan, StartupTempAge: time.Minute},
+	} {
+		rtest.Assert(t, validStartupTempPolicy(cfg) != nil, "config %v accepted", cfg.StartupTempPolicy)
+	}
+}
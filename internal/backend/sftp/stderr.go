@@ -0,0 +1,58 @@
+package sftp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+)
+
+// ErrFatalStderr is returned when the ssh process has written a line to
+// stderr which matches one of the fatal patterns of the StderrPolicy.
+var ErrFatalStderr = errors.New("fatal output from ssh process")
+
+// StderrPolicy decides what happens with the lines the ssh process writes to
+// stderr. Lines matching one of the Fatal patterns abort the connection,
+// lines matching one of the Ignore patterns are discarded. All other lines are
+// informational and printed to stderr.
+type StderrPolicy struct {
+	Fatal  []*regexp.Regexp
+	Ignore []*regexp.Regexp
+}
+
+func matchAny(patterns []*regexp.Regexp, s string) bool {
+	for _, re := range patterns {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleStderr processes the stderr output of program according to policy.
+// When a fatal line is found, it is sent to fatal and abort is called. The
+// remaining output is still processed until rd returns an error.
+func handleStderr(rd io.Reader, program string, policy StderrPolicy, fatal chan<- string, abort func()) {
+	sc := bufio.NewScanner(rd)
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case matchAny(policy.Fatal, line):
+			debug.Log("fatal output from subprocess %v: %v", program, line)
+			select {
+			case fatal <- line:
+				abort()
+			default:
+			}
+		case matchAny(policy.Ignore, line):
+			continue
+		}
+
+		// prefix the errors with the program name
+		fmt.Fprintf(os.Stderr, "subprocess %v: %v\n", program, line)
+	}
+}
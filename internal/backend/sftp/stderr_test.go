@@ -0,0 +1,85 @@
+package sftp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+var testStderrPolicy = StderrPolicy{
+	Fatal:  []*regexp.Regexp{regexp.MustCompile(`^fatal: `)},
+	Ignore: []*regexp.Regexp{regexp.MustCompile(`^Warning: Permanently added`)},
+}
+
+func TestHandleStderr(t *testing.T) {
+	for _, test := range []struct {
+		input string
+		fatal string
+	}{
+		{"Warning: Permanently added 'host' to the list of known hosts.\n", ""},
+		{"some informational message\n", ""},
+		{"Warning: foo\nfatal: connection degraded\nfatal: second\n", "fatal: connection degraded"},
+	} {
+		fatal := make(chan string, 1)
+		aborted := 0
+		handleStderr(strings.NewReader(test.input), "ssh", testStderrPolicy, fatal, func() {
+			aborted++
+		})
+
+		var line string
+		select {
+		case line = <-fatal:
+		default:
+		}
+		rtest.Equals(t, test.fatal, line)
+
+		want := 0
+		if test.fatal != "" {
+			want = 1
+		}
+		rtest.Equals(t, want, aborted)
+	}
+}
+
+func TestStderrPolicy(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.StderrPolicy = testStderrPolicy
+	server := cfg.Command
+
+	// informational output does not affect the connection
+	cfg.Command = fmt.Sprintf(`sh -c 'echo "Warning: Permanently added host" >&2; echo "info" >&2; exec %s'`, server)
+	be := newTestBackend(t, cfg)
+	time.Sleep(100 * time.Millisecond)
+	saveFile(t, be, restic.Handle{Type: restic.ConfigFile}, []byte("config"))
+
+	// fatal output aborts the connection while the server is still running
+	cfg.Path += "2"
+	cfg.Command = fmt.Sprintf(`sh -c '(sleep 0.5; echo "fatal: degraded" >&2) & exec %s'`, server)
+	be, err := Create(context.TODO(), cfg)
+	rtest.OK(t, err)
+	defer func() {
+		_ = be.Close()
+	}()
+
+	h := restic.Handle{Type: restic.ConfigFile}
+	saveFile(t, be, h, []byte("config"))
+
+	// a Stat racing with the exit of the killed process may fail with a
+	// closed pipe, the following one must report the fatal output
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err = be.Stat(context.TODO(), h)
+		if errors.Is(err, ErrFatalStderr) || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	rtest.Assert(t, errors.Is(err, ErrFatalStderr), "expected ErrFatalStderr, got %v", err)
+}
@@ -0,0 +1,56 @@
+package sftp
+
+import (
+	"os"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/pkg/sftp"
+)
+
+// Policies for symlinks found by List, see Config.SymlinkPolicy.
+const (
+	// SymlinkPolicyInclude makes List report symlinks to regular files with
+	// the size of the target.
+	SymlinkPolicyInclude = "include"
+	// SymlinkPolicySkip makes List ignore symlinks.
+	SymlinkPolicySkip = "skip"
+	// SymlinkPolicyError makes List fail when it finds a symlink.
+	SymlinkPolicyError = "error"
+)
+
+func validSymlinkPolicy(policy string) error {
+	switch policy {
+	case "", SymlinkPolicyInclude, SymlinkPolicySkip, SymlinkPolicyError:
+		return nil
+	}
+	return errors.Errorf("invalid symlink policy %q, may be one of: %v, %v, %v",
+		policy, SymlinkPolicyInclude, SymlinkPolicySkip, SymlinkPolicyError)
+}
+
+// resolveSymlink applies the symlink policy to the symlink at name. It returns
+// the file info of the target if the symlink should be listed, or nil if it
+// should be skipped.
+func (r *SFTP) resolveSymlink(c *sftp.Client, name string) (os.FileInfo, error) {
+	switch r.Config.SymlinkPolicy {
+	case SymlinkPolicyError:
+		return nil, backoff.Permanent(errors.Errorf("found symlink %v", name))
+	case SymlinkPolicyInclude:
+		r.sem.GetToken()
+		fi, err := c.Stat(name)
+		r.sem.ReleaseToken()
+		if r.IsNotExist(err) {
+			debug.Log("skipping dangling symlink %v", name)
+			return nil, nil
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "Stat")
+		}
+		return fi, nil
+	}
+
+	debug.Log("skipping symlink %v", name)
+	return nil, nil
+}
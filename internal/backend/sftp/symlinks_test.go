@@ -0,0 +1,68 @@
+package sftp
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestSymlinkPolicy(t *testing.T) {
+	for _, test := range []struct {
+		policy string
+		want   []string
+		err    bool
+	}{
+		{"", []string{"file"}, false},
+		{SymlinkPolicySkip, []string{"file"}, false},
+		{SymlinkPolicyInclude, []string{"file", "latest"}, false},
+		{SymlinkPolicyError, nil, true},
+	} {
+		t.Run(test.policy, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			cfg.SymlinkPolicy = test.policy
+			be := newTestBackend(t, cfg)
+
+			data := []byte("foobar")
+			h := restic.Handle{Type: restic.SnapshotFile, Name: restic.Hash(data).String()}
+			saveFile(t, be, h, data)
+
+			dir := be.Join(be.Location(), "snapshots")
+			rtest.OK(t, be.client().Symlink(be.Filename(h), be.Join(dir, "latest")))
+			rtest.OK(t, be.client().Symlink(be.Join(dir, "missing"), be.Join(dir, "dangling")))
+			rtest.OK(t, be.client().Symlink(be.Location(), be.Join(dir, "dir")))
+
+			var names []string
+			sizes := make(map[string]int64)
+			err := be.List(context.TODO(), restic.SnapshotFile, func(fi restic.FileInfo) error {
+				name := fi.Name
+				if name == h.Name {
+					name = "file"
+				}
+				names = append(names, name)
+				sizes[name] = fi.Size
+				return nil
+			})
+			if test.err {
+				rtest.Assert(t, err != nil, "expected error for symlinks")
+				return
+			}
+			rtest.OK(t, err)
+
+			sort.Strings(names)
+			rtest.Equals(t, test.want, names)
+			for _, name := range names {
+				rtest.Equals(t, int64(len(data)), sizes[name])
+			}
+		})
+	}
+}
+
+func TestSymlinkPolicyInvalid(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.SymlinkPolicy = "follow"
+	_, err := Create(context.TODO(), cfg)
+	rtest.Assert(t, err != nil, "expected error for invalid policy")
+}
@@ -0,0 +1,24 @@
+package sftp
+
+// WithTag returns a copy of the backend which adds tag to the debug log
+// messages, results and events of all operations, so that they can be
+// correlated with the work they belong to. The copy shares the connection and
+// all other state with r, closing either of them closes both.
+func (r *SFTP) WithTag(tag string) *SFTP {
+	tagged := *r
+	tagged.tag = tag
+	return &tagged
+}
+
+// Tag returns the tag set by WithTag.
+func (r *SFTP) Tag() string {
+	return r.tag
+}
+
+// tagInfo returns a suffix for debug log messages with the tag, if set.
+func (r *SFTP) tagInfo() string {
+	if r.tag == "" {
+		return ""
+	}
+	return ", tag " + r.tag
+}
@@ -0,0 +1,57 @@
+package sftp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestWithTag(t *testing.T) {
+	var buf bytes.Buffer
+	cfg := newTestConfig(t)
+	cfg.ResultStream = &buf
+	cfg.EmitEvents = true
+	cfg.SlowOpThreshold = time.Nanosecond
+	be := newTestBackend(t, cfg)
+
+	tagged := be.WithTag("snapshot-1234")
+	rtest.Equals(t, "snapshot-1234", tagged.Tag())
+	rtest.Equals(t, "", be.Tag())
+	rtest.Equals(t, ", tag snapshot-1234", tagged.tagInfo())
+	rtest.Equals(t, "", be.tagInfo())
+
+	// the connect event has no tag
+	ev := <-be.Events()
+	rtest.Equals(t, EventConnect, ev.Type)
+	rtest.Equals(t, "", ev.Tag)
+
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.SnapshotFile, Name: restic.Hash(data).String()}
+	rtest.OK(t, tagged.SaveMany(context.TODO(), []SaveItem{{Handle: h, Reader: restic.NewByteReader(data, nil)}}))
+
+	var res Result
+	rtest.OK(t, json.Unmarshal(buf.Bytes(), &res))
+	rtest.Equals(t, "snapshot-1234", res.Tag)
+
+	for _, be := range []*SFTP{tagged, be} {
+		_, err := be.Stat(context.TODO(), h)
+		rtest.OK(t, err)
+	}
+
+	var tags []string
+	for i := 0; i < 3; i++ {
+		ev := <-be.Events()
+		rtest.Equals(t, EventSlowOp, ev.Type)
+		tags = append(tags, ev.Tag)
+	}
+	// save, stat with tag and stat without tag
+	rtest.Equals(t, []string{"snapshot-1234", "snapshot-1234", ""}, tags)
+
+	// both share the same connection
+	rtest.Assert(t, tagged.client() == be.client(), "tagged backend uses a different connection")
+}
@@ -0,0 +1,38 @@
+package sftp
+
+import (
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+// tempFilename returns the name of the temporary file which is written
+// before it is renamed to the file for h.
+func (r *SFTP) tempFilename(h restic.Handle) string {
+	return r.tempFilenameFor(h, r.Filename(h))
+}
+
+// tempFilenameFor returns the name of the temporary file which is written
+// before it is renamed to filename, which holds data belonging to h.
+func (r *SFTP) tempFilenameFor(h restic.Handle, filename string) string {
+	if r.Config.TempNameFunc != nil {
+		return filename + "-restic-temp-" + r.Config.TempNameFunc(h)
+	}
+	return filename + "-restic-temp-" + tempSuffix()
+}
+
+// checkTempCollision returns a permanent error if creating the temporary file
+// tmpFilename failed with err because the file already exists. Otherwise, err
+// is returned unchanged.
+func (r *SFTP) checkTempCollision(tmpFilename string, err error) error {
+	if err == nil || r.IsNotExist(err) {
+		return err
+	}
+
+	// servers usually report a generic failure for existing files
+	if _, serr := r.client().Lstat(tmpFilename); serr == nil {
+		return backoff.Permanent(errors.Errorf("temporary file %v already exists, the names returned by TempNameFunc must be unique", tmpFilename))
+	}
+	return err
+}
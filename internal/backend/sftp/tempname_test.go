@@ -0,0 +1,65 @@
+package sftp
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// observingReader calls fn before the first read.
+type observingReader struct {
+	*restic.ByteReader
+	fn func()
+}
+
+func (rd *observingReader) Read(p []byte) (int, error) {
+	if rd.fn != nil {
+		rd.fn()
+		rd.fn = nil
+	}
+	return rd.ByteReader.Read(p)
+}
+
+func TestTempNameFunc(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.TempNameFunc = func(h restic.Handle) string {
+		return "test-" + h.Name[:8]
+	}
+	be := newTestBackend(t, cfg)
+
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	tmpFilename := be.Filename(h) + "-restic-temp-test-" + h.Name[:8]
+
+	var tempExists bool
+	rd := &observingReader{ByteReader: restic.NewByteReader(data, nil), fn: func() {
+		_, err := os.Lstat(tmpFilename)
+		tempExists = err == nil
+	}}
+	rtest.OK(t, be.Save(context.TODO(), h, rd))
+	rtest.Assert(t, tempExists, "temporary file %v not found while saving", tmpFilename)
+
+	_, err := os.Lstat(tmpFilename)
+	rtest.Assert(t, os.IsNotExist(err), "temporary file %v still exists", tmpFilename)
+
+	// a colliding temporary file is not overwritten
+	data = []byte("other data")
+	h = restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	tmpFilename = be.Filename(h) + "-restic-temp-test-" + h.Name[:8]
+	rtest.OK(t, be.client().MkdirAll(be.Dirname(h)))
+	rtest.OK(t, os.WriteFile(tmpFilename, []byte("in use"), 0600))
+
+	err = be.Save(context.TODO(), h, restic.NewByteReader(data, nil))
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "already exists"), "expected collision error, got %v", err)
+
+	buf, err := os.ReadFile(tmpFilename)
+	rtest.OK(t, err)
+	rtest.Equals(t, []byte("in use"), buf)
+
+	_, err = be.Stat(context.TODO(), h)
+	rtest.Assert(t, be.IsNotExist(err), "file was saved despite the collision")
+}
@@ -0,0 +1,348 @@
+package sftp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+
+	"github.com/pkg/sftp"
+)
+
+// ErrTimeout is returned when connecting to the server or an operation takes
+// longer than Config.Timeout.
+var ErrTimeout = errors.New("sftp: timeout")
+
+// startSession runs start, which opens the sftp session. If it does not
+// return within timeout, abort is called, which must make start return, and
+// an error wrapping ErrTimeout is returned. A timeout of zero disables it.
+func startSession(timeout time.Duration, start func() (*sftp.Client, error), abort func()) (*sftp.Client, error) {
+	if timeout <= 0 {
+		return start()
+	}
+
+	type result struct {
+		c   *sftp.Client
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		c, err := start()
+		ch <- result{c, err}
+	}()
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+
+	select {
+	case res := <-ch:
+		return res.c, res.err
+	case <-t.C:
+		debug.Log("starting the sftp session timed out after %v", timeout)
+		abort()
+		if res := <-ch; res.c != nil {
+			_ = res.c.Close()
+		}
+		return nil, fmt.Errorf("%w: starting the sftp session took longer than %v", ErrTimeout, timeout)
+	}
+}
+
+// dialTimeoutError returns an error wrapping ErrTimeout if err was caused by
+// a network timeout while connecting, and err otherwise.
+func dialTimeoutError(timeout time.Duration, err error) error {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return fmt.Errorf("%w: connecting took longer than %v: %v", ErrTimeout, timeout, err)
+	}
+	return err
+}
+
+// runWithTimeout runs fn with a context from which the watchdog for the
+// operation can be retrieved. Config.Timeout limits how long fn may wait for
+// the server without making progress: the watchdog is paused while data is
+// passed to or received from the caller, and restarted afterwards. Time spent
+// in the caller's code, for example the function passed to Load, is therefore
+// not counted.
+//
+// When the timeout expires, the context passed to fn is cancelled and an
+// error wrapping ErrTimeout is returned once fn has returned. fn may still
+// use the caller's reader or function, so runWithTimeout never returns
+// before fn does. The sftp protocol has no way to abort a single request, so
+// if fn does not return within another Timeout, the server is considered
+// hung and the connection used by fn is closed, which makes all pending
+// requests on it fail. If Reconnect is set, a new connection is started for
+// the next operation.
+func (r *SFTP) runWithTimeout(ctx context.Context, operation string, fn func(ctx context.Context) error) error {
+	if r.Config.Timeout <= 0 {
+		return fn(ctx)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	w := &watchdog{timeout: r.Config.Timeout, timer: clockFor(r.Config).NewTimer(r.Config.Timeout)}
+	ctx = context.WithValue(ctx, watchdogKey{}, w)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- fn(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		w.stop()
+		cancel()
+		return err
+	case <-w.timer.C():
+	}
+
+	w.expire()
+	cancel()
+	select {
+	case err := <-done:
+		// fn has returned concurrently
+		return err
+	default:
+	}
+
+	debug.Log("%v made no progress for %v%v", operation, r.Config.Timeout, r.tagInfo())
+	if err := r.abortIfHung(w, operation, done); err == nil {
+		return nil
+	}
+	return fmt.Errorf("%w: %v made no progress for %v", ErrTimeout, operation, r.Config.Timeout)
+}
+
+// abortIfHung waits for another timeout for the operation which has timed out
+// to return on done. If it does not, the connection it uses is closed and
+// abortIfHung waits until the operation has returned. The error returned by
+// the operation is passed on.
+func (r *SFTP) abortIfHung(w *watchdog, operation string, done <-chan error) error {
+	t := clockFor(r.Config).NewTimer(r.Config.Timeout)
+	select {
+	case err := <-done:
+		t.Stop()
+		return err
+	case <-t.C():
+	}
+
+	debug.Log("%v still has not returned, closing its connection%v", operation, r.tagInfo())
+	conn := w.connection()
+	if conn == nil {
+		conn = r.conn
+	}
+	conn.abort()
+	return <-done
+}
+
+// watchdogKey is the context key for the watchdog of an operation.
+type watchdogKey struct{}
+
+// watchdog tracks whether an operation run by runWithTimeout makes progress.
+// Its timer runs while the operation waits for the server, and is stopped
+// while it is paused.
+type watchdog struct {
+	m       sync.Mutex
+	timer   timer
+	timeout time.Duration
+	paused  int
+	expired bool
+	conn    *connection
+}
+
+// watchdogFrom returns the watchdog of the operation running with ctx, or nil
+// if there is no timeout.
+func watchdogFrom(ctx context.Context) *watchdog {
+	w, _ := ctx.Value(watchdogKey{}).(*watchdog)
+	return w
+}
+
+// pause stops the timer while the operation does not wait for the server.
+// Calls to pause and resume may be nested.
+func (w *watchdog) pause() {
+	if w == nil {
+		return
+	}
+
+	w.m.Lock()
+	defer w.m.Unlock()
+	w.paused++
+	if w.paused == 1 && !w.expired {
+		w.timer.Stop()
+	}
+}
+
+// resume restarts the timer with the full timeout after pause.
+func (w *watchdog) resume() {
+	if w == nil {
+		return
+	}
+
+	w.m.Lock()
+	defer w.m.Unlock()
+	w.paused--
+	if w.paused == 0 && !w.expired {
+		w.timer.Reset(w.timeout)
+	}
+}
+
+// timedOut returns an error wrapping ErrTimeout if the timeout has expired.
+func (w *watchdog) timedOut() error {
+	if w == nil {
+		return nil
+	}
+
+	w.m.Lock()
+	defer w.m.Unlock()
+	if w.expired {
+		return fmt.Errorf("%w: no progress for %v", ErrTimeout, w.timeout)
+	}
+	return nil
+}
+
+func (w *watchdog) stop() {
+	w.m.Lock()
+	defer w.m.Unlock()
+	w.timer.Stop()
+}
+
+func (w *watchdog) expire() {
+	w.m.Lock()
+	defer w.m.Unlock()
+	w.expired = true
+}
+
+// use records that the operation runs on the pooled connection conn.
+func (w *watchdog) use(conn *connection) {
+	if w == nil {
+		return
+	}
+
+	w.m.Lock()
+	defer w.m.Unlock()
+	w.conn = conn
+}
+
+func (w *watchdog) connection() *connection {
+	w.m.Lock()
+	defer w.m.Unlock()
+	return w.conn
+}
+
+// watchLoad returns a function which calls fn with a reader that resumes the
+// watchdog of the operation running with ctx while data is read from the
+// server. The watchdog is paused while fn runs otherwise.
+func watchLoad(ctx context.Context, fn func(rd io.Reader) error) func(rd io.Reader) error {
+	w := watchdogFrom(ctx)
+	if w == nil {
+		return fn
+	}
+
+	return func(rd io.Reader) error {
+		w.pause()
+		defer w.resume()
+		return fn(&watchedReader{Reader: rd, w: w})
+	}
+}
+
+// watchList returns a function which calls fn with the watchdog of the
+// operation running with ctx paused.
+func watchList(ctx context.Context, fn func(restic.FileInfo) error) func(restic.FileInfo) error {
+	w := watchdogFrom(ctx)
+	if w == nil {
+		return fn
+	}
+
+	return func(fi restic.FileInfo) error {
+		w.pause()
+		defer w.resume()
+		return fn(fi)
+	}
+}
+
+// watchedReader passes the data read from the server to the caller of an
+// operation with a watchdog. The watchdog is paused while the caller
+// processes the data. Once the timeout has expired, reading fails.
+type watchedReader struct {
+	io.Reader
+	w *watchdog
+}
+
+func (rd *watchedReader) Read(p []byte) (int, error) {
+	rd.w.resume()
+	n, err := rd.Reader.Read(p)
+	rd.w.pause()
+	if terr := rd.w.timedOut(); terr != nil {
+		return 0, terr
+	}
+	return n, err
+}
+
+func (rd *watchedReader) WriteTo(dst io.Writer) (int64, error) {
+	rd.w.resume()
+	var n int64
+	var err error
+	pw := &pausingWriter{Writer: dst, w: rd.w}
+	if wt, ok := rd.Reader.(io.WriterTo); ok {
+		n, err = wt.WriteTo(pw)
+	} else {
+		n, err = io.Copy(pw, struct{ io.Reader }{rd.Reader})
+	}
+	rd.w.pause()
+	if terr := rd.w.timedOut(); terr != nil && err == nil {
+		err = terr
+	}
+	return n, err
+}
+
+// pausingWriter pauses the watchdog while data is written to the caller's
+// writer. Once the timeout has expired, writing fails.
+type pausingWriter struct {
+	io.Writer
+	w *watchdog
+}
+
+func (pw *pausingWriter) Write(p []byte) (int, error) {
+	if err := pw.w.timedOut(); err != nil {
+		return 0, err
+	}
+	pw.w.pause()
+	defer pw.w.resume()
+	return pw.Writer.Write(p)
+}
+
+// pausingReader pauses the watchdog while data to be uploaded is read from
+// the caller's reader. Once the timeout has expired, reading fails.
+type pausingReader struct {
+	io.Reader
+	w *watchdog
+}
+
+func (pr *pausingReader) Read(p []byte) (int, error) {
+	if err := pr.w.timedOut(); err != nil {
+		return 0, err
+	}
+	pr.w.pause()
+	defer pr.w.resume()
+	return pr.Reader.Read(p)
+}
+
+// abort terminates the connection without waiting for pending requests.
+func (conn *connection) abort() {
+	conn.m.RLock()
+	cmd, sshClient, local := conn.cmd, conn.ssh, conn.local
+	conn.m.RUnlock()
+
+	if sshClient != nil {
+		_ = sshClient.Close()
+		return
+	}
+	if local != nil {
+		_ = local.Close()
+		return
+	}
+	_ = cmd.Process.Kill()
+}
@@ -0,0 +1,194 @@
+package sftp
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestTimeoutStartSession(t *testing.T) {
+	cfg := NewConfig()
+	cfg.Path = "/repo"
+	// the command never starts an sftp server
+	cfg.Command = "sleep 60"
+	cfg.Timeout = 200 * time.Millisecond
+
+	start := time.Now()
+	_, err := Open(context.TODO(), cfg)
+	rtest.Assert(t, errors.Is(err, ErrTimeout), "expected ErrTimeout, got %v", err)
+	rtest.Assert(t, time.Since(start) < 30*time.Second, "timeout was not applied")
+}
+
+func TestTimeoutNativeDial(t *testing.T) {
+	// the server accepts connections, but never sends anything
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	rtest.OK(t, err)
+	defer func() {
+		_ = l.Close()
+	}()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer func() {
+				_ = conn.Close()
+			}()
+		}
+	}()
+
+	host, port, err := net.SplitHostPort(l.Addr().String())
+	rtest.OK(t, err)
+
+	cfg := NewConfig()
+	cfg.Host = host
+	cfg.Port = port
+	cfg.Path = "/repo"
+	cfg.IdentityFile = writeIdentity(t, t.TempDir(), "")
+	cfg.HostKeyFingerprint = "SHA256:" + base64.RawStdEncoding.EncodeToString(make([]byte, sha256.Size))
+	cfg.Timeout = 200 * time.Millisecond
+
+	_, err = Open(context.TODO(), cfg)
+	rtest.Assert(t, errors.Is(err, ErrTimeout), "expected ErrTimeout, got %v", err)
+}
+
+// newHangingBackend returns a backend for a server which never answers
+// requests to stat data files or read them, and the handle of a data file.
+func newHangingBackend(t testing.TB, timeout time.Duration) (*SFTP, restic.Handle) {
+	cfg := fakeServerConfig(faultHang)
+	cfg.Timeout = timeout
+
+	be, err := Create(context.TODO(), cfg)
+	rtest.OK(t, err)
+	t.Cleanup(func() {
+		// ignore the error as the server has been killed
+		_ = be.Close()
+	})
+
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	saveFile(t, be, h, data)
+	return be, h
+}
+
+func TestTimeoutStat(t *testing.T) {
+	timeout := 200 * time.Millisecond
+	be, h := newHangingBackend(t, timeout)
+
+	start := time.Now()
+	_, err := be.Stat(context.TODO(), h)
+	rtest.Assert(t, errors.Is(err, ErrTimeout), "expected ErrTimeout, got %v", err)
+	rtest.Assert(t, !be.IsNotExist(err), "timeout reported as missing file")
+
+	// the hanging request only returns once the connection is closed after
+	// another timeout
+	rtest.Assert(t, time.Since(start) >= 2*timeout, "Stat returned after %v while the request was still pending", time.Since(start))
+	<-be.conn.result.Done()
+}
+
+func TestTimeoutLoad(t *testing.T) {
+	be, h := newHangingBackend(t, 200*time.Millisecond)
+
+	err := be.Load(context.TODO(), h, 0, 0, func(rd io.Reader) error {
+		_, err := io.ReadAll(rd)
+		return err
+	})
+	rtest.Assert(t, errors.Is(err, ErrTimeout), "expected ErrTimeout, got %v", err)
+}
+
+func TestTimeoutLoadAndHash(t *testing.T) {
+	be, h := newHangingBackend(t, 200*time.Millisecond)
+
+	_, err := be.LoadAndHash(context.TODO(), h, sha256.New())
+	rtest.Assert(t, errors.Is(err, ErrTimeout), "expected ErrTimeout, got %v", err)
+}
+
+func TestTimeoutSlowCaller(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.Timeout = 100 * time.Millisecond
+	be := newTestBackend(t, cfg)
+
+	data := rtest.Random(5, 1024*1024)
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	saveFile(t, be, h, data)
+
+	// the time spent by fn is not limited by the timeout
+	for _, length := range []int{0, 1000} {
+		err := be.Load(context.TODO(), h, length, 0, func(rd io.Reader) error {
+			time.Sleep(3 * cfg.Timeout)
+			buf := make([]byte, 1000)
+			if _, err := io.ReadFull(rd, buf); err != nil {
+				return err
+			}
+			time.Sleep(3 * cfg.Timeout)
+			_, err := io.Copy(io.Discard, rd)
+			return err
+		})
+		rtest.OK(t, err)
+	}
+
+	err := be.List(context.TODO(), restic.PackFile, func(fi restic.FileInfo) error {
+		time.Sleep(3 * cfg.Timeout)
+		return nil
+	})
+	rtest.OK(t, err)
+}
+
+func TestTimeoutReconnect(t *testing.T) {
+	clock := newFakeClock()
+	cfg := newTestConfig(t)
+	cfg.Timeout = time.Minute
+	cfg.Reconnect = true
+	cfg.ReconnectInitialDelay = -1
+	setClock(&cfg, clock)
+	be := newTestBackend(t, cfg)
+
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	saveFile(t, be, h, data)
+
+	be.conn.m.RLock()
+	result := be.conn.result
+	be.conn.m.RUnlock()
+
+	// the operation only returns when the connection is closed
+	done := make(chan error, 1)
+	go func() {
+		done <- be.runWithTimeout(context.TODO(), "test", func(ctx context.Context) error {
+			<-result.Done()
+			return result.Err()
+		})
+	}()
+	clock.waitTimers(t, 1)
+	clock.Advance(time.Minute)
+
+	// the connection is closed if the operation still has not returned
+	// after another timeout, runWithTimeout waits for it until then
+	clock.waitTimers(t, 1)
+	select {
+	case <-result.Done():
+		t.Fatal("connection closed before the operation was considered hung")
+	case err := <-done:
+		t.Fatalf("returned while the operation was still running: %v", err)
+	default:
+	}
+	clock.Advance(time.Minute)
+	err := <-done
+	rtest.Assert(t, errors.Is(err, ErrTimeout), "expected ErrTimeout, got %v", err)
+	<-result.Done()
+
+	// the next operation reconnects
+	fi, err := be.Stat(context.TODO(), h)
+	rtest.OK(t, err)
+	rtest.Equals(t, int64(len(data)), fi.Size)
+	rtest.Equals(t, 1, be.ReconnectCount())
+}
@@ -0,0 +1,56 @@
+package sftp
+
+import (
+	"io"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// writeData copies the data from rd to w, which is the file for h. If a
+// transformation is configured for h, the data is passed through it. The
+// number of bytes read from rd is returned, which may differ from the number
+// of bytes stored.
+func (r *SFTP) writeData(h restic.Handle, w io.Writer, rd io.Reader) (int64, error) {
+	transform := r.writeTransform(h)
+	if transform == nil {
+		// make sure to use the optimized sftp upload method
+		if rf, ok := w.(io.ReaderFrom); ok {
+			return rf.ReadFrom(rd)
+		}
+		return io.Copy(w, rd)
+	}
+
+	tw := transform(w)
+	n, err := io.Copy(tw, rd)
+	if err != nil {
+		return n, err
+	}
+
+	if c, ok := tw.(io.Closer); ok {
+		err = c.Close()
+	}
+	return n, err
+}
+
+// readCloser combines a reader and a closer.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// transformReader passes the data read from rd, the file for h, through the
+// transformation returned by readTransform. As the transformed data cannot
+// be seeked, the first offset bytes are read and discarded.
+func (r *SFTP) transformReader(h restic.Handle, rd io.ReadCloser, offset int64) (io.ReadCloser, error) {
+	tr := r.readTransform(h)(rd)
+	if offset > 0 {
+		_, err := io.CopyN(io.Discard, tr, offset)
+		if err != nil {
+			_ = rd.Close()
+			return nil, errors.Wrap(err, "Seek")
+		}
+	}
+
+	return &readCloser{Reader: tr, Closer: rd}, nil
+}
@@ -0,0 +1,75 @@
+package sftp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+const xorKey = 0x5a
+
+type xorReader struct {
+	rd io.Reader
+}
+
+func (x xorReader) Read(p []byte) (int, error) {
+	n, err := x.rd.Read(p)
+	for i := range p[:n] {
+		p[i] ^= xorKey
+	}
+	return n, err
+}
+
+type xorWriter struct {
+	wr io.Writer
+}
+
+func (x xorWriter) Write(p []byte) (int, error) {
+	buf := make([]byte, len(p))
+	for i := range p {
+		buf[i] = p[i] ^ xorKey
+	}
+	return x.wr.Write(buf)
+}
+
+func TestTransform(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.WriteTransform = func(wr io.Writer) io.Writer { return xorWriter{wr} }
+	cfg.ReadTransform = func(rd io.Reader) io.Reader { return xorReader{rd} }
+	be := newTestBackend(t, cfg)
+
+	data := rtest.Random(23, 5000)
+	id := restic.Hash(data)
+	h := restic.Handle{Type: restic.PackFile, Name: id.String()}
+	saveFile(t, be, h, data)
+
+	// the stored data is transformed
+	stored, err := os.ReadFile(be.Filename(h))
+	rtest.OK(t, err)
+	rtest.Equals(t, len(data), len(stored))
+	rtest.Assert(t, !bytes.Equal(data, stored), "data was stored without transformation")
+
+	buf, err := backend.LoadAll(context.TODO(), nil, be, h)
+	rtest.OK(t, err)
+	rtest.Equals(t, data, buf)
+
+	err = be.Load(context.TODO(), h, 100, 1000, func(rd io.Reader) error {
+		buf, err := io.ReadAll(rd)
+		rtest.OK(t, err)
+		rtest.Equals(t, data[1000:1100], buf)
+		return nil
+	})
+	rtest.OK(t, err)
+
+	// SaveVerified checks the hash of the untransformed data
+	data = rtest.Random(42, 100)
+	id = restic.Hash(data)
+	h = restic.Handle{Type: restic.IndexFile, Name: id.String()}
+	rtest.OK(t, be.SaveVerified(context.TODO(), h, restic.NewByteReader(data, nil), id))
+}
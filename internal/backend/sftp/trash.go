@@ -0,0 +1,136 @@
+package sftp
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// trashTimeFormat is used to prefix the names of files moved to the trash
+// directory with the time they were removed.
+const trashTimeFormat = "20060102-150405.000000000"
+
+// trashDir returns the path of the trash directory.
+func (r *SFTP) trashDir() string {
+	return r.Join(r.p, r.Config.TrashDir)
+}
+
+// moveToTrash moves the file for h to the trash directory. The name in the
+// trash directory is prefixed with the current time, so that removing the
+// same file more than once does not cause collisions.
+func (r *SFTP) moveToTrash(h restic.Handle) error {
+	now := clockFor(r.Config).Now().UTC()
+	name := now.Format(trashTimeFormat) + "-" + h.Type.String() + "-" + h.Name
+	if h.Type == restic.ConfigFile {
+		name = now.Format(trashTimeFormat) + "-config"
+	}
+
+	dir := r.trashDir()
+	target := r.Join(dir, name)
+
+	err := r.moveFile(h, r.Filename(h), target)
+	if r.IsNotExist(err) {
+		// the trash directory may not exist yet
+		if _, statErr := r.client().Lstat(dir); r.IsNotExist(statErr) {
+			if err := r.mkdirAll(dir); err != nil {
+				return err
+			}
+			err = r.moveFile(h, r.Filename(h), target)
+		}
+	}
+	if err != nil {
+		return errors.Wrap(err, "Rename")
+	}
+
+	debug.Log("moved %v to %v", h, target)
+	return nil
+}
+
+// trashHandle returns the handle of the file which was moved to the trash
+// directory with the given name by moveToTrash.
+func trashHandle(name string) (restic.Handle, bool) {
+	if len(name) <= len(trashTimeFormat)+1 {
+		return restic.Handle{}, false
+	}
+	rest := name[len(trashTimeFormat)+1:]
+	if rest == "config" {
+		return restic.Handle{Type: restic.ConfigFile}, true
+	}
+
+	typ, id, ok := strings.Cut(rest, "-")
+	if !ok {
+		return restic.Handle{}, false
+	}
+	for _, t := range []restic.FileType{restic.PackFile, restic.KeyFile, restic.LockFile, restic.SnapshotFile, restic.IndexFile} {
+		if t.String() == typ {
+			return restic.Handle{Type: t, Name: id}, true
+		}
+	}
+	return restic.Handle{}, false
+}
+
+// EmptyTrash permanently removes all files which have been moved to the trash
+// directory at least olderThan ago. It fails with ErrMaintenanceInProgress
+// while another process holds the maintenance lock.
+func (r *SFTP) EmptyTrash(ctx context.Context, olderThan time.Duration) error {
+	if err := r.checkReadOnly("remove"); err != nil {
+		return err
+	}
+
+	if r.Config.TrashDir == "" {
+		return errors.New("no trash directory configured")
+	}
+	if err := r.checkMaintenance(); err != nil {
+		return err
+	}
+
+	r.sem.GetToken()
+	defer r.sem.ReleaseToken()
+
+	entries, err := r.ReadDir(ctx, r.trashDir())
+	if err != nil {
+		if r.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrap(err, "ReadDir")
+	}
+
+	now := clockFor(r.Config).Now()
+	for _, fi := range entries {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if !fi.Mode().IsRegular() || len(fi.Name()) < len(trashTimeFormat) {
+			continue
+		}
+
+		removed, err := time.Parse(trashTimeFormat, fi.Name()[:len(trashTimeFormat)])
+		if err != nil {
+			debug.Log("ignoring file %v in trash: %v", fi.Name(), err)
+			continue
+		}
+
+		if now.Sub(removed) < olderThan {
+			continue
+		}
+
+		object := fi.Name()
+		if h, ok := trashHandle(fi.Name()); ok {
+			object = objectName(h)
+		}
+
+		err = r.client().Remove(r.Join(r.trashDir(), fi.Name()))
+		if err != nil && !r.IsNotExist(err) {
+			r.reportResult("remove", object, fi.Size(), err)
+			return errors.Wrap(err, "Remove")
+		}
+		r.reportResult("remove", object, fi.Size(), nil)
+	}
+
+	return nil
+}
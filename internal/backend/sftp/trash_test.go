@@ -0,0 +1,89 @@
+package sftp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestTrash(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.TrashDir = "trash"
+	be := newTestBackend(t, cfg)
+
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	saveFile(t, be, h, data)
+	rtest.OK(t, be.Remove(context.TODO(), h))
+
+	_, err := be.Stat(context.TODO(), h)
+	rtest.Assert(t, be.IsNotExist(err), "file still exists after Remove: %v", err)
+
+	trash := dirEntries(t, be, be.trashDir())
+	rtest.Equals(t, 1, len(trash))
+	rtest.Assert(t, strings.HasSuffix(trash[0], "-data-"+h.Name), "unexpected name in trash: %v", trash[0])
+
+	// save and remove the same file again, both copies must be kept
+	saveFile(t, be, h, data)
+	rtest.OK(t, be.Remove(context.TODO(), h))
+	rtest.Equals(t, 2, len(dirEntries(t, be, be.trashDir())))
+
+	// files are too young to be purged
+	rtest.OK(t, be.EmptyTrash(context.TODO(), time.Hour))
+	rtest.Equals(t, 2, len(dirEntries(t, be, be.trashDir())))
+
+	rtest.OK(t, be.EmptyTrash(context.TODO(), 0))
+	rtest.Equals(t, []string(nil), dirEntries(t, be, be.trashDir()))
+}
+
+func TestTrashResults(t *testing.T) {
+	var buf bytes.Buffer
+	cfg := newTestConfig(t)
+	cfg.TrashDir = "trash"
+	cfg.ResultStream = &buf
+	be := newTestBackend(t, cfg)
+
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	saveFile(t, be, h, data)
+	rtest.OK(t, be.Remove(context.TODO(), h))
+	rtest.OK(t, be.EmptyTrash(context.TODO(), 0))
+
+	var res Result
+	rtest.OK(t, json.Unmarshal(buf.Bytes(), &res))
+	rtest.Equals(t, "remove", res.Operation)
+	rtest.Equals(t, objectName(h), res.Object)
+}
+
+func TestTrashHandle(t *testing.T) {
+	name := restic.NewRandomID().String()
+	for _, test := range []struct {
+		name string
+		h    restic.Handle
+		ok   bool
+	}{
+		{"20060102-150405.000000000-data-" + name, restic.Handle{Type: restic.PackFile, Name: name}, true},
+		{"20060102-150405.000000000-snapshot-" + name, restic.Handle{Type: restic.SnapshotFile, Name: name}, true},
+		{"20060102-150405.000000000-config", restic.Handle{Type: restic.ConfigFile}, true},
+		{"20060102-150405.000000000-foo-" + name, restic.Handle{}, false},
+		{"20060102-150405.000000000", restic.Handle{}, false},
+	} {
+		h, ok := trashHandle(test.name)
+		rtest.Equals(t, test.ok, ok)
+		rtest.Equals(t, test.h, h)
+	}
+}
+
+func TestEmptyTrashMissingDir(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.TrashDir = "trash"
+	be := newTestBackend(t, cfg)
+
+	rtest.OK(t, be.EmptyTrash(context.TODO(), 0))
+}
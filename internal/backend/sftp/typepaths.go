@@ -0,0 +1,111 @@
+package sftp
+
+import (
+	"path"
+	"strings"
+
+	"github.com/restic/restic/internal/backend/layout"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// typePathLayout wraps a layout and stores the files of some types in
+// separate directories.
+type typePathLayout struct {
+	layout.Layout
+	join  func(...string) string
+	paths map[restic.FileType]string
+}
+
+// newTypePathLayout returns a layout which uses the directories from
+// typePaths instead of the ones from l. Relative directories are interpreted
+// relative to the repository at repo.
+func newTypePathLayout(l layout.Layout, join func(...string) string, repo string, typePaths map[restic.FileType]string) (layout.Layout, error) {
+	if len(typePaths) == 0 {
+		return l, nil
+	}
+
+	paths := make(map[restic.FileType]string, len(typePaths))
+	for t, dir := range typePaths {
+		switch t {
+		case restic.PackFile, restic.KeyFile, restic.LockFile, restic.SnapshotFile, restic.IndexFile:
+		default:
+			return nil, errors.Errorf("invalid type %v for a custom directory", t)
+		}
+
+		if dir == "" {
+			return nil, errors.Errorf("empty directory for type %v", t)
+		}
+
+		if !path.IsAbs(dir) {
+			dir = path.Clean(dir)
+			if dir == "." || dir == ".." || strings.HasPrefix(dir, "../") {
+				return nil, errors.Errorf("directory %q for type %v is not within the repository", typePaths[t], t)
+			}
+			dir = join(repo, dir)
+		}
+		paths[t] = join(dir)
+	}
+
+	return &typePathLayout{Layout: l, join: join, paths: paths}, nil
+}
+
+func (l *typePathLayout) String() string {
+	return "<TypePathLayout>"
+}
+
+// Dirname returns the directory path for a given file type and name.
+func (l *typePathLayout) Dirname(h restic.Handle) string {
+	dir, ok := l.paths[h.Type]
+	if !ok {
+		return l.Layout.Dirname(h)
+	}
+
+	_, subdirs := l.Layout.Basedir(h.Type)
+	if subdirs && len(h.Name) > 2 {
+		dir = l.join(dir, h.Name[:2])
+	}
+
+	return dir + "/"
+}
+
+// Filename returns a path to a file, including its name.
+func (l *typePathLayout) Filename(h restic.Handle) string {
+	if _, ok := l.paths[h.Type]; !ok {
+		return l.Layout.Filename(h)
+	}
+
+	return l.join(l.Dirname(h), h.Name)
+}
+
+// Basedir returns the base dir name for type t.
+func (l *typePathLayout) Basedir(t restic.FileType) (dirname string, subdirs bool) {
+	dirname, subdirs = l.Layout.Basedir(t)
+	if dir, ok := l.paths[t]; ok {
+		dirname = dir
+	}
+	return dirname, subdirs
+}
+
+// Paths returns all directory names needed for a repo.
+func (l *typePathLayout) Paths() (dirs []string) {
+	for _, dir := range l.Layout.Paths() {
+		dirs = append(dirs, l.relocate(dir))
+	}
+	return dirs
+}
+
+// relocate moves dir to the custom directory of the type whose base dir
+// contains it.
+func (l *typePathLayout) relocate(dir string) string {
+	for t, custom := range l.paths {
+		basedir, _ := l.Layout.Basedir(t)
+		if dir == basedir {
+			return custom
+		}
+		if strings.HasPrefix(dir, basedir+"/") {
+			return l.join(custom, strings.TrimPrefix(dir, basedir+"/"))
+		}
+	}
+	return dir
+}
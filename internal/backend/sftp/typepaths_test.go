@@ -0,0 +1,72 @@
+package sftp
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestTypePaths(t *testing.T) {
+	cfg := newTestConfig(t)
+	dataDir := filepath.Join(filepath.Dir(cfg.Path), "tier2", "data")
+	cfg.TypePaths = map[restic.FileType]string{
+		restic.PackFile:  dataDir,
+		restic.IndexFile: "meta/index",
+	}
+	be := newTestBackend(t, cfg)
+
+	// Create initializes the custom directories
+	rtest.Equals(t, 256, len(dirEntries(t, be, dataDir)))
+	rtest.Equals(t, 0, len(dirEntries(t, be, be.Join(cfg.Path, "meta", "index"))))
+
+	for _, test := range []struct {
+		t        restic.FileType
+		filename string
+	}{
+		{restic.PackFile, dataDir},
+		{restic.IndexFile, be.Join(cfg.Path, "meta", "index")},
+		{restic.SnapshotFile, be.Join(cfg.Path, "snapshots")},
+	} {
+		data := []byte("foobar " + test.t.String())
+		id := restic.Hash(data)
+		h := restic.Handle{Type: test.t, Name: id.String()}
+		saveFile(t, be, h, data)
+
+		filename := be.Join(test.filename, h.Name)
+		if test.t == restic.PackFile {
+			filename = be.Join(test.filename, h.Name[:2], h.Name)
+		}
+		_, err := be.client().Lstat(filename)
+		rtest.OK(t, err)
+
+		buf, err := backend.LoadAll(context.TODO(), nil, be, h)
+		rtest.OK(t, err)
+		rtest.Equals(t, data, buf)
+
+		var names []string
+		rtest.OK(t, be.List(context.TODO(), test.t, func(fi restic.FileInfo) error {
+			names = append(names, fi.Name)
+			return nil
+		}))
+		rtest.Equals(t, []string{h.Name}, names)
+	}
+}
+
+func TestTypePathsInvalid(t *testing.T) {
+	for _, typePaths := range []map[restic.FileType]string{
+		{restic.ConfigFile: "config"},
+		{restic.PackFile: ""},
+		{restic.PackFile: "."},
+		{restic.PackFile: "../data"},
+		{restic.IndexFile: "foo/../../index"},
+	} {
+		cfg := newTestConfig(t)
+		cfg.TypePaths = typePaths
+		_, err := Create(context.TODO(), cfg)
+		rtest.Assert(t, err != nil, "expected error for %v", typePaths)
+	}
+}
@@ -0,0 +1,53 @@
+package sftp
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestSaveConfigVerified(t *testing.T) {
+	cfg := fakeServerConfig(faultCorruptConfig)
+
+	be, err := Create(context.TODO(), cfg)
+	rtest.OK(t, err)
+	defer func() {
+		rtest.OK(t, be.Close())
+	}()
+
+	// other files are not read back
+	data := []byte("foobar")
+	saveFile(t, be, restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}, data)
+
+	h := restic.Handle{Type: restic.ConfigFile}
+	err = be.Save(context.TODO(), h, restic.NewByteReader([]byte("config data"), nil))
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "content mismatch"), "expected content mismatch, got %v", err)
+
+	_, err = be.Stat(context.TODO(), h)
+	rtest.Assert(t, be.IsNotExist(err), "corrupted config file was saved")
+
+	entries, err := be.client().ReadDir(cfg.Path)
+	rtest.OK(t, err)
+	for _, fi := range entries {
+		rtest.Assert(t, !strings.HasPrefix(fi.Name(), "config"), "file %v was not removed", fi.Name())
+	}
+}
+
+func TestSaveConfigReadBack(t *testing.T) {
+	be := newTestBackend(t, newTestConfig(t))
+
+	data := []byte("config data")
+	h := restic.Handle{Type: restic.ConfigFile}
+	saveFile(t, be, h, data)
+
+	// replacing the config file is verified as well
+	data = []byte("new config data")
+	saveFile(t, be, h, data)
+
+	buf, err := be.LoadConfig(context.TODO(), 1024)
+	rtest.OK(t, err)
+	rtest.Equals(t, data, buf)
+}
@@ -0,0 +1,50 @@
+package sftp
+
+import (
+	"fmt"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+// ErrUploadMismatch is returned by Save if Config.VerifyUploads is set and
+// the saved file does not match the uploaded data.
+var ErrUploadMismatch = errors.New("sftp: saved file does not match the uploaded data")
+
+// checkFileExtensions are the names of the extension which lets the server
+// compute the hash of a file.
+var checkFileExtensions = []string{"check-file", "check-file-name", "check-file-handle"}
+
+// verifyUpload checks that filename, which has just been saved, has size
+// bytes on the server. The sftp client cannot send the check-file request,
+// so the server cannot be asked for the hash of the file even if it supports
+// it, SaveVerified reads the file back for a full check instead. If the size
+// does not match, the file is removed, so that it is saved again by a retry.
+func (r *SFTP) verifyUpload(filename string, size int64) error {
+	supported := false
+	for _, name := range checkFileExtensions {
+		if _, ok := r.client().HasExtension(name); ok {
+			supported = true
+		}
+	}
+	if !supported {
+		debug.Log("server does not support check-file, only verifying the size of %v", filename)
+	} else {
+		debug.Log("check-file is not supported by the sftp client, only verifying the size of %v", filename)
+	}
+
+	fi, err := r.client().Lstat(filename)
+	if err != nil {
+		return errors.Wrap(err, "Lstat")
+	}
+	if fi.Size() == size {
+		return nil
+	}
+
+	if rmErr := r.client().Remove(filename); rmErr != nil {
+		debug.Log("unable to remove mismatching file %v: %v", filename, rmErr)
+	}
+	return backoff.Permanent(fmt.Errorf("%w: %v has size %d, but %d bytes were uploaded", ErrUploadMismatch, filename, fi.Size(), size))
+}
@@ -0,0 +1,30 @@
+package sftp
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestVerifyUploads(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.VerifyUploads = true
+	be := newTestBackend(t, cfg)
+
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	saveFile(t, be, h, data)
+
+	fi, err := be.Stat(context.TODO(), h)
+	rtest.OK(t, err)
+	rtest.Equals(t, int64(len(data)), fi.Size)
+
+	// a file with the wrong size is removed
+	err = be.verifyUpload(be.Filename(h), int64(len(data))+1)
+	rtest.Assert(t, errors.Is(err, ErrUploadMismatch), "expected ErrUploadMismatch, got %v", err)
+	_, err = be.Stat(context.TODO(), h)
+	rtest.Assert(t, be.IsNotExist(err), "mismatching file was not removed: %v", err)
+}
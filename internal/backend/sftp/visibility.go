@@ -0,0 +1,49 @@
+package sftp
+
+import (
+	"context"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// ErrNotVisible is returned by Save if Config.VerifyListAfterSave is set and
+// the saved file does not become visible on the server.
+var ErrNotVisible = errors.New("sftp: saved file is not visible")
+
+const (
+	// visibilityRetries is the number of times Stat is retried until a
+	// saved file is visible.
+	visibilityRetries = 8
+	// visibilityDelay is the delay before the first retry, it is doubled
+	// for each further retry.
+	visibilityDelay = 10 * time.Millisecond
+)
+
+// waitVisible waits until Stat finds the file for h, which has just been
+// saved. Some servers with caches only show new files after a short delay.
+func (r *SFTP) waitVisible(ctx context.Context, h restic.Handle) error {
+	delay := visibilityDelay
+	for i := 0; ; i++ {
+		_, err := r.Stat(ctx, h)
+		if err == nil {
+			return nil
+		}
+		if !r.IsNotExist(err) {
+			return err
+		}
+		if i == visibilityRetries {
+			return errors.Wrap(ErrNotVisible, objectName(h))
+		}
+
+		debug.Log("%v not visible yet, retrying in %v", h, delay)
+		select {
+		case <-clockFor(r.Config).After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay *= 2
+	}
+}
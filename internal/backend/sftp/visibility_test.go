@@ -0,0 +1,47 @@
+package sftp
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestVerifyListAfterSave(t *testing.T) {
+	for _, verify := range []bool{false, true} {
+		cfg := fakeServerConfig(faultDelayVisible)
+		cfg.VerifyListAfterSave = verify
+		be := newTestBackend(t, cfg)
+
+		data := []byte("foobar")
+		h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+		rtest.OK(t, be.Save(context.TODO(), h, restic.NewByteReader(data, nil)))
+
+		// the lookups made while verifying have used up the delay
+		_, err := be.Stat(context.TODO(), h)
+		if verify {
+			rtest.OK(t, err)
+		} else {
+			rtest.Assert(t, be.IsNotExist(err), "file visible immediately after Save: %v", err)
+		}
+	}
+}
+
+func TestVerifyListAfterSaveGivesUp(t *testing.T) {
+	cfg := fakeServerConfig(faultDelayVisible)
+	cfg.VerifyListAfterSave = true
+	be := newTestBackend(t, cfg)
+
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	saveFile(t, be, h, data)
+
+	// the file exists, waiting for it is not retried
+	rtest.OK(t, be.waitVisible(context.TODO(), h))
+
+	h = restic.Handle{Type: restic.PackFile, Name: restic.NewRandomID().String()}
+	err := be.waitVisible(context.TODO(), h)
+	rtest.Assert(t, errors.Is(err, ErrNotVisible), "expected ErrNotVisible, got %v", err)
+}
@@ -0,0 +1,54 @@
+package sftp
+
+import (
+	"context"
+	"os"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+)
+
+// CheckWritable verifies that files can be written to the repository by
+// creating, syncing and removing a small temporary file. This allows
+// detecting missing permissions or read-only file systems before uploading
+// large amounts of data.
+func (r *SFTP) CheckWritable(ctx context.Context) (err error) {
+	debug.Log("CheckWritable")
+	if err := r.checkReadOnly("save"); err != nil {
+		return err
+	}
+
+	if err := r.clientError(ctx); err != nil {
+		return err
+	}
+
+	r.sem.GetToken()
+	defer r.sem.ReleaseToken()
+
+	filename := r.Join(r.p, "restic-write-test-"+tempSuffix())
+	f, err := r.client().OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY)
+	if err != nil {
+		return errors.Wrap(err, "OpenFile")
+	}
+
+	defer func() {
+		rmErr := r.client().Remove(filename)
+		if rmErr != nil && err == nil {
+			err = errors.Wrap(rmErr, "Remove")
+		}
+	}()
+
+	_, err = f.Write([]byte("restic write test\n"))
+	if err != nil {
+		_ = f.Close()
+		return errors.Wrap(err, "Write")
+	}
+
+	err = r.syncFile(f)
+	if err != nil {
+		_ = f.Close()
+		return errors.Wrap(err, "Sync")
+	}
+
+	return errors.Wrap(f.Close(), "Close")
+}